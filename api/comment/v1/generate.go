@@ -0,0 +1,4 @@
+// Package commentv1 - generated gRPC client and server code for comment.proto
+package commentv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative comment/v1/comment.proto
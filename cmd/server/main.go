@@ -1,50 +1,419 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/breaker"
 	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/config"
 	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/db"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/health"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/metrics"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/outbox"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/tracing"
+	transportGraphql "github.com/ridwanulhoquejr/go-rest-api-v2/internal/transport/graphql"
+	transportGrpc "github.com/ridwanulhoquejr/go-rest-api-v2/internal/transport/grpc"
 	transportHttp "github.com/ridwanulhoquejr/go-rest-api-v2/internal/transport/http"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/webhook"
 )
 
 // RUN - is going to be responsible for
 // the instantiation and startup of our
 // GO Application
-func Run() error {
-	fmt.Println("starting up our application")
-
-	db, err := db.NewDatabase()
+func Run(cfg config.Config, logger logging.Logger) error {
+	ctx := context.Background()
+	logger.Info(ctx, "starting up our application")
 
+	shutdownTracing, err := tracing.Setup(ctx, tracingConfig(cfg.Tracing))
 	if err != nil {
-		fmt.Println("failed to connect to the database")
+		logger.WithError(err).Error(ctx, "failed to set up tracing")
 		return err
 	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.WithError(err).Error(ctx, "failed to flush traces")
+		}
+	}()
+
+	db, err := db.NewDatabase(cfg.Database, logger)
 
-	if err := db.MigrateDB(); err != nil {
-		fmt.Println("failed to migrate the database")
+	if err != nil {
+		logger.WithError(err).Error(ctx, "failed to connect to the database")
 		return err
 	}
 
+	// with auto_migrate off, `app migrate up` is expected to run first
+	if cfg.Database.AutoMigrate {
+		if err := db.MigrateDB(); err != nil {
+			logger.WithError(err).Error(ctx, "failed to migrate the database")
+			return err
+		}
+	}
+
+	// every mutating comment operation ends up in the audit_log table
+	auditRecorder := audit.NewRecorder(db)
+
+	m := metrics.New()
+	m.RegisterDBStats(db.Client.DB, "comments")
+
+	// comment changes leave domain events in the outbox table,
+	// the relay publishes them in the background until we shut down
+	var (
+		events   comment.Emitter
+		webhooks *webhook.Service
+	)
+	if cfg.Outbox.Publisher != "none" {
+		publisher, closePublisher, err := newOutboxPublisher(cfg.Outbox, logger)
+		if err != nil {
+			logger.WithError(err).Error(ctx, "failed to set up the outbox publisher")
+			return err
+		}
+		defer closePublisher()
+
+		// webhooks are one more publisher: each event becomes a delivery per
+		// matching webhook, the dispatcher sends and retries those
+		if cfg.Webhooks.Enabled {
+			publisher = outbox.Publishers{publisher, webhook.Fanout{Store: db}}
+			webhooks = webhook.NewService(db, comment.EventTypes, logger)
+
+			dispatcher := webhook.NewDispatcher(db, webhook.DispatcherOptions{
+				PollInterval: cfg.Webhooks.PollInterval,
+				BatchSize:    cfg.Webhooks.BatchSize,
+				Timeout:      cfg.Webhooks.Timeout,
+				MaxAttempts:  cfg.Webhooks.MaxAttempts,
+				MinBackoff:   cfg.Webhooks.MinBackoff,
+				MaxBackoff:   cfg.Webhooks.MaxBackoff,
+				AllowPrivate: cfg.Webhooks.AllowPrivate,
+			}, logger)
+			defer runInBackground(ctx, dispatcher.Run)()
+		}
+
+		events = outbox.NewWriter(db)
+		relay := outbox.NewRelay(db, publisher, outbox.RelayOptions{
+			PollInterval:   cfg.Outbox.PollInterval,
+			BatchSize:      cfg.Outbox.BatchSize,
+			PublishTimeout: cfg.Outbox.PublishTimeout,
+			Retention:      cfg.Outbox.Retention,
+		}, logger)
+		// stops before closePublisher runs, the relay may be mid-batch
+		defer runInBackground(ctx, relay.Run)()
+	}
+
+	// committed changes are pushed to the live comment streams, with
+	// several replicas they make a round trip through postgres first so
+	// every replica's clients get them
+	broker := stream.NewBroker(stream.Options{ReplayBuffer: cfg.Stream.ReplayBuffer})
+	var notifier comment.Notifier = broker
+	if cfg.Stream.Fanout == "postgres" {
+		fanout := stream.NewFanout(db, broker, logger)
+		notifier = fanout
+		defer runInBackground(ctx, fanout.Run)()
+	}
+
 	// creating a new instance of the comment service
 	//? we are passing the repository as a dependency to the service
 	//? so that the service can use the repository to interact with the database
-	cmtService := comment.NewService(db)
+	//? the repository is wrapped so every store call is timed,
+	//? and put behind a circuit breaker that fails fast while the db is down
+	storeBreaker := newStoreBreaker(cfg.Database.Breaker, m, logger)
+	cmtService := comment.NewService(
+		breaker.GuardStore(metrics.InstrumentStore(db, m), storeBreaker),
+		// a change and its audit entry are committed in one transaction
+		breaker.GuardTransactor(db, storeBreaker),
+		auditRecorder,
+		events,
+		notifier,
+		// changes are counted once they commit, not per store call
+		m,
+		logger,
+	)
+
+	// the same db instance also satisfies the auth.Store interface
+	signingKey := []byte(cfg.Auth.JWTSecret)
+	authService := auth.NewService(db, signingKey)
+
+	// /readyz and the gRPC health service answer from the same checks
+	healthRegistry := newHealthRegistry(db, signingKey)
+
+	opts := []transportHttp.Option{
+		transportHttp.WithAddr(cfg.Server.Addr),
+		transportHttp.WithTimeouts(cfg.Server.ReadTimeout, cfg.Server.WriteTimeout),
+		transportHttp.WithBroker(broker),
+		transportHttp.WithStreamHeartbeat(cfg.Stream.Heartbeat),
+		transportHttp.WithMaxSubscriptions(cfg.Stream.MaxSubscriptions),
+		transportHttp.WithHealth(healthRegistry),
+		transportHttp.WithDrainDelay(cfg.Server.DrainDelay),
+		transportHttp.WithShutdownTimeout(cfg.Server.ShutdownTimeout),
+		transportHttp.WithRateLimiter(newRateLimiter(db, cfg.RateLimit.Backend)),
+		// a 503 tells clients when the breaker lets the next call through
+		transportHttp.WithCooldown(storeBreaker),
+		transportHttp.WithAccessLogSampleRate(cfg.Server.AccessLogSampleRate),
+		transportHttp.WithMetrics(m),
+		// the same comment service as a GraphQL schema, its subscriptions
+		// are fed by the broker like the streams
+		transportHttp.WithGraphQL(transportGraphql.NewHandler(cmtService, logger,
+			transportGraphql.WithBroker(broker),
+			transportGraphql.WithHeartbeat(cfg.Stream.Heartbeat),
+			transportGraphql.WithMaxSubscriptions(cfg.Stream.MaxSubscriptions),
+		)),
+	}
+	if webhooks != nil {
+		opts = append(opts, transportHttp.WithWebhooks(webhooks))
+	}
+	// server.validate_requests checks requests against /openapi.json, outside
+	// production responses are checked too, logging wherever handlers drift
+	if cfg.Server.ValidateRequests {
+		opts = append(opts, transportHttp.WithRequestValidation())
+	}
+	if cfg.Environment != config.EnvProduction {
+		opts = append(opts, transportHttp.WithResponseValidation())
+	}
+	// server.admin_addr (e.g. ":9090") serves /metrics on a separate port
+	if cfg.Server.AdminAddr != "" {
+		opts = append(opts, transportHttp.WithAdminAddr(cfg.Server.AdminAddr))
+	}
 
 	// entry point for our http server route handling
-	httpHandler := transportHttp.NewHandler(cmtService)
+	httpHandler := transportHttp.NewHandler(
+		cmtService,
+		authService,
+		auditRecorder,
+		logger,
+		opts...,
+	)
+
+	// internal services get the same comment service over gRPC
+	if cfg.GRPC.Addr != "" {
+		grpcServer := transportGrpc.NewServer(cmtService, authService, logger,
+			transportGrpc.WithAddr(cfg.GRPC.Addr),
+			transportGrpc.WithShutdownTimeout(cfg.Server.ShutdownTimeout),
+			// drains along with /readyz
+			transportGrpc.WithReadiness(healthRegistry, 5*time.Second),
+		)
+		if err := grpcServer.Listen(); err != nil {
+			logger.WithError(err).Error(ctx, "failed to start the grpc server")
+			return err
+		}
+		// stops when the HTTP server does, so the two shutdowns overlap
+		grpcCtx, stopGRPC := context.WithCancel(ctx)
+		httpHandler.Server.RegisterOnShutdown(stopGRPC)
+		defer runInBackground(grpcCtx, func(ctx context.Context) {
+			if err := grpcServer.Run(ctx); err != nil {
+				logger.WithError(err).Error(ctx, "grpc server stopped")
+			}
+		})()
+	}
+
 	if err := httpHandler.Serve(); err != nil {
-		fmt.Println("failed to start the server")
+		logger.WithError(err).Error(ctx, "failed to start the server")
 		return err
 	}
 
 	return nil
 }
 
+// newHealthRegistry - /readyz checks the database, the schema version,
+// free disk space and the signing key, /healthz only needs the process up
+func newHealthRegistry(d *db.Database, signingKey []byte) *health.Registry {
+	r := health.NewRegistry()
+	r.RegisterReadiness("database", time.Second, d.Ping)
+	r.RegisterReadiness("migrations", 2*time.Second, health.MigrationCheck(d))
+	r.RegisterReadiness("disk", time.Second, health.DiskSpaceCheck(os.TempDir(), 64<<20))
+	r.RegisterReadiness("config", time.Second, health.MinLengthCheck("jwt signing key", signingKey, config.MinJWTSecretLength))
+	return r
+}
+
+// newStoreBreaker - only connection level errors trip it, see db.IsUnavailable
+func newStoreBreaker(cfg config.Breaker, m *metrics.Metrics, logger logging.Logger) *breaker.Breaker {
+	return breaker.New(breaker.Options{
+		FailureThreshold: cfg.FailureThreshold,
+		OpenTimeout:      cfg.OpenTimeout,
+		IsFailure:        db.IsUnavailable,
+		OnStateChange: func(from, to breaker.State) {
+			m.StoreBreakerState.Set(float64(to))
+			logger.WithFields(logging.Fields{
+				"from": from.String(),
+				"to":   to.String(),
+			}).Warn(context.Background(), "comment store circuit breaker changed state")
+		},
+	})
+}
+
+// runInBackground - runs fn in a goroutine, the returned func cancels
+// its context and waits for it to return
+func runInBackground(ctx context.Context, fn func(ctx context.Context)) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// newOutboxPublisher - the returned func releases the publisher's connection
+func newOutboxPublisher(cfg config.Outbox, logger logging.Logger) (outbox.Publisher, func(), error) {
+	switch cfg.Publisher {
+	case "webhook":
+		return outbox.NewWebhookPublisher(cfg.WebhookURL, cfg.PublishTimeout), func() {}, nil
+	case "nats":
+		p, err := outbox.NewNATSPublisher(cfg.NATSURL, cfg.NATSSubject, cfg.PublishTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, p.Close, nil
+	default:
+		return outbox.LogPublisher{Log: logger}, func() {}, nil
+	}
+}
+
+// tracingConfig - maps the tracing section onto tracing.Config
+func tracingConfig(cfg config.Tracing) tracing.Config {
+	return tracing.Config{
+		ServiceName: "comments-api",
+		Exporter:    cfg.Exporter,
+		Endpoint:    cfg.Endpoint,
+		Insecure:    cfg.Insecure,
+		FilePath:    cfg.File,
+		SampleRatio: cfg.SampleRatio,
+	}
+}
+
+// newRateLimiter - the postgres backend shares the buckets
+// between replicas, memory keeps them per process
+func newRateLimiter(d *db.Database, backend string) *transportHttp.RateLimiter {
+	var store ratelimit.Store = ratelimit.NewMemoryStore(time.Hour)
+	if backend == "postgres" {
+		store = d.NewRateLimitStore()
+	}
+
+	return &transportHttp.RateLimiter{
+		Store: store,
+		Default: transportHttp.RateLimitPolicy{
+			Read:  ratelimit.Limit{Rate: 20, Burst: 40},
+			Write: ratelimit.Limit{Rate: 5, Burst: 10},
+		},
+		Routes: map[string]transportHttp.RateLimitPolicy{
+			// password guessing gets slow quickly
+			"/api/v1/auth/token": {
+				Write: ratelimit.Limit{Rate: 0.2, Burst: 5},
+			},
+			// /graphql keeps the defaults: every request is a read and
+			// every mutation in it a write, see RateLimitReads
+		},
+	}
+}
+
+// VerifyAuditLog - walks the audit_log hash chain and reports
+// the first entry that does not match, if any
+func VerifyAuditLog(cfg config.Config, logger logging.Logger) error {
+	ctx := context.Background()
+
+	db, err := db.NewDatabase(cfg.Database, logger)
+	if err != nil {
+		logger.WithError(err).Error(ctx, "failed to connect to the database")
+		return err
+	}
+
+	checked, badID, err := audit.Verify(ctx, db)
+	if err != nil {
+		if badID != 0 {
+			return fmt.Errorf("%w at entry %d (%d entries verified before it)", err, badID, checked)
+		}
+		return err
+	}
+
+	logger.WithFields(logging.Fields{"entries": checked}).Info(ctx, "audit chain intact")
+	return nil
+}
+
+// PrintConfig - writes the effective config with secrets masked
+func PrintConfig(cfg config.Config) error {
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// usage: app [serve|audit-verify|config|migrate ...] [-config file.yaml] [-database.host ...]
 func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	// positional arguments of the command, e.g. `migrate down 1`,
+	// come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+	if len(positional) > 0 && command != "migrate" {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(positional, " "))
+		os.Exit(2)
+	}
+
+	// an invalid config stops us before anything is started
+	cfg, err := config.Load(args, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(logging.Options{
+		Level:  cfg.Log.Level,
+		Format: cfg.Log.Format,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	fmt.Println("Hello World!!")
-	if err := Run(); err != nil {
-		fmt.Println(err)
+	switch command {
+	case "serve":
+		if err := Run(cfg, logger); err != nil {
+			logger.WithError(err).Error(context.Background(), "application stopped")
+			os.Exit(1)
+		}
+	// `app audit-verify` checks the audit chain instead of starting the server
+	case "audit-verify":
+		if err := VerifyAuditLog(cfg, logger); err != nil {
+			logger.WithError(err).Error(context.Background(), "audit verification failed")
+			os.Exit(1)
+		}
+	case "config":
+		if err := PrintConfig(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "migrate":
+		if err := Migrate(cfg, logger, positional, os.Stdout); err != nil {
+			if errors.Is(err, errMigrateUsage) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			logger.WithError(err).Error(context.Background(), "migration failed")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected serve, audit-verify, config or migrate\n", command)
+		os.Exit(2)
 	}
 }
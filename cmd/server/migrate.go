@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/config"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/db"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+)
+
+const migrateUsage = `usage: app migrate <command> [flags]
+
+commands:
+  up              apply every pending migration
+  down N          roll back the last N migrations
+  goto VERSION    migrate up or down to VERSION
+  force VERSION   mark VERSION as applied and clear the dirty flag
+  status          print the current and latest version`
+
+var errMigrateUsage = errors.New(migrateUsage)
+
+// Migrate - the `app migrate` subcommand, args are what follows "migrate"
+func Migrate(cfg config.Config, logger logging.Logger, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+	ctx := context.Background()
+
+	// number parses the single argument down, goto and force take
+	number := func() (int, error) {
+		if len(args) != 2 {
+			return 0, errMigrateUsage
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a valid number\n\n%w", args[1], errMigrateUsage)
+		}
+		return n, nil
+	}
+
+	// validate the arguments before connecting anywhere
+	var n int
+	switch args[0] {
+	case "up", "status":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+	case "down", "goto", "force":
+		var err error
+		if n, err = number(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%w", args[0], errMigrateUsage)
+	}
+
+	d, err := db.NewDatabase(cfg.Database, logger)
+	if err != nil {
+		return err
+	}
+	defer d.Client.Close()
+
+	switch args[0] {
+	case "up":
+		err = d.MigrateDB()
+	case "down":
+		err = d.MigrateDown(ctx, n)
+	case "goto":
+		err = d.MigrateTo(ctx, uint(n))
+	case "force":
+		err = d.ForceMigrationVersion(ctx, n)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := d.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	printMigrationStatus(out, status)
+	return nil
+}
+
+func printMigrationStatus(out io.Writer, s db.MigrationStatus) {
+	fmt.Fprintf(out, "version: %d\n", s.Version)
+	fmt.Fprintf(out, "latest:  %d\n", s.Latest)
+	if s.Dirty {
+		fmt.Fprintln(out, "dirty:   true (fix the schema, then `app migrate force VERSION`)")
+	}
+	if len(s.Pending) > 0 {
+		fmt.Fprintf(out, "pending: %v\n", s.Pending)
+	}
+}
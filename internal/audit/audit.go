@@ -0,0 +1,270 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+
+	// Anonymous - actor recorded when no authenticated principal is present
+	Anonymous = "anonymous"
+)
+
+var ErrChainBroken = errors.New("audit chain is broken")
+
+// Entry - one record in the append-only audit log.
+// Every entry carries the hash of the one before it,
+// so editing or removing a row breaks the chain from that point on.
+type Entry struct {
+	ID         int64           `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor"`
+	RemoteAddr string          `json:"remote_addr"`
+	RequestID  string          `json:"request_id"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceID string          `json:"resource_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Diff       json.RawMessage `json:"diff,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// ComputeHash - sha256 over the previous hash and every recorded field.
+// The id is left out on purpose, it is assigned by the database.
+func (e Entry) ComputeHash() string {
+	fields := []string{
+		e.PrevHash,
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.RemoteAddr,
+		e.RequestID,
+		e.Action,
+		e.Resource,
+		e.ResourceID,
+		string(e.Before),
+		string(e.After),
+		string(e.Diff),
+	}
+
+	h := sha256.New()
+	for _, f := range fields {
+		// length prefix so that field boundaries can't be shifted around
+		h.Write([]byte(strconv.Itoa(len(f))))
+		h.Write([]byte{':'})
+		h.Write([]byte(f))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Filter - criteria for querying the log, zero values are ignored
+type Filter struct {
+	Actor      string
+	ResourceID string
+	From       time.Time
+	To         time.Time
+	AfterID    int64
+	Limit      int
+}
+
+// Store - persistence for the audit log.
+// Append is responsible for linking the entry to the current
+// head of the chain and must do so atomically.
+type Store interface {
+	AppendAuditEntry(ctx context.Context, e Entry) (Entry, error)
+	QueryAuditLog(ctx context.Context, f Filter) ([]Entry, error)
+}
+
+// Recorder - builds audit entries out of the request context
+// and appends them to the store
+type Recorder struct {
+	Store Store
+	now   func() time.Time
+}
+
+// NewRecorder - returns a recorder writing to the given store
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{
+		Store: store,
+		now:   time.Now,
+	}
+}
+
+// Record - appends an entry for a change on a resource.
+// before is nil for creates and after is nil for deletes.
+func (r *Recorder) Record(
+	ctx context.Context,
+	action, resource, resourceID string,
+	before, after any,
+) error {
+	beforeJSON, err := marshalState(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalState(after)
+	if err != nil {
+		return err
+	}
+	diff, err := Diff(beforeJSON, afterJSON)
+	if err != nil {
+		return err
+	}
+
+	info := RequestInfoFromContext(ctx)
+
+	actor := Anonymous
+	if claims, ok := auth.ClaimsFromContext(ctx); ok && claims.Subject != "" {
+		actor = claims.Subject
+	}
+
+	_, err = r.Store.AppendAuditEntry(ctx, Entry{
+		// postgres keeps microseconds, truncate so the hash survives a round trip
+		OccurredAt: r.now().UTC().Truncate(time.Microsecond),
+		Actor:      actor,
+		RemoteAddr: info.RemoteAddr,
+		RequestID:  info.RequestID,
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		Diff:       diff,
+	})
+	if err != nil {
+		return fmt.Errorf("could not append audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// Query - returns the entries matching the filter, oldest first
+func (r *Recorder) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	return r.Store.QueryAuditLog(ctx, f)
+}
+
+// Verify - walks the whole chain and recomputes every hash.
+// It returns the id of the first bad entry together with ErrChainBroken.
+func Verify(ctx context.Context, store Store) (checked int, badID int64, err error) {
+	const pageSize = 500
+
+	prevHash := ""
+	var afterID int64
+
+	for {
+		entries, err := store.QueryAuditLog(ctx, Filter{AfterID: afterID, Limit: pageSize})
+		if err != nil {
+			return checked, 0, err
+		}
+
+		for _, e := range entries {
+			if e.PrevHash != prevHash || e.ComputeHash() != e.Hash {
+				return checked, e.ID, ErrChainBroken
+			}
+			prevHash = e.Hash
+			afterID = e.ID
+			checked++
+		}
+
+		if len(entries) < pageSize {
+			return checked, 0, nil
+		}
+	}
+}
+
+// Diff - field level diff between two json objects:
+// {"field": {"from": <before>, "to": <after>}} for every field that changed
+func Diff(before, after json.RawMessage) (json.RawMessage, error) {
+	var b, a map[string]any
+
+	if len(before) > 0 {
+		if err := json.Unmarshal(before, &b); err != nil {
+			return nil, fmt.Errorf("could not decode before state: %w", err)
+		}
+	}
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &a); err != nil {
+			return nil, fmt.Errorf("could not decode after state: %w", err)
+		}
+	}
+
+	type change struct {
+		From any `json:"from"`
+		To   any `json:"to"`
+	}
+	changes := map[string]change{}
+
+	for k, bv := range b {
+		av, ok := a[k]
+		if !ok || !jsonEqual(bv, av) {
+			changes[k] = change{From: bv, To: av}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = change{From: nil, To: av}
+		}
+	}
+
+	// encoding/json sorts map keys, so the output is stable for hashing
+	return json.Marshal(changes)
+}
+
+func jsonEqual(a, b any) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+func marshalState(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode audit state: %w", err)
+	}
+	return b, nil
+}
+
+// RequestInfo - where a change came from
+type RequestInfo struct {
+	RemoteAddr string
+	RequestID  string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo - stores the request origin in the context
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext - returns the request origin, empty if not set
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// RemoteIP - strips the port from an http.Request RemoteAddr
+func RemoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return strings.TrimSpace(remoteAddr)
+	}
+	return host
+}
@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memStore - chains entries the same way the postgres store does
+type memStore struct {
+	entries []Entry
+}
+
+func (m *memStore) AppendAuditEntry(_ context.Context, e Entry) (Entry, error) {
+	if len(m.entries) > 0 {
+		e.PrevHash = m.entries[len(m.entries)-1].Hash
+	}
+	e.ID = int64(len(m.entries) + 1)
+	e.Hash = e.ComputeHash()
+	m.entries = append(m.entries, e)
+	return e, nil
+}
+
+func (m *memStore) QueryAuditLog(_ context.Context, f Filter) ([]Entry, error) {
+	var out []Entry
+	for _, e := range m.entries {
+		if e.ID > f.AfterID {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func TestDiff(t *testing.T) {
+	diff, err := Diff(
+		json.RawMessage(`{"id":"1","body":"old","author":"a"}`),
+		json.RawMessage(`{"id":"1","body":"new","author":"a"}`),
+	)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"body":{"from":"old","to":"new"}}`, string(diff))
+
+	diff, err = Diff(nil, json.RawMessage(`{"id":"1"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":{"from":null,"to":"1"}}`, string(diff))
+}
+
+func TestVerify(t *testing.T) {
+	store := &memStore{}
+	rec := NewRecorder(store)
+	ctx := WithRequestInfo(context.Background(), RequestInfo{RemoteAddr: "10.0.0.1", RequestID: "req-1"})
+
+	assert.NoError(t, rec.Record(ctx, ActionCreate, "comment", "1", nil, map[string]string{"body": "a"}))
+	assert.NoError(t, rec.Record(ctx, ActionUpdate, "comment", "1", map[string]string{"body": "a"}, map[string]string{"body": "b"}))
+	assert.NoError(t, rec.Record(ctx, ActionDelete, "comment", "1", map[string]string{"body": "b"}, nil))
+
+	checked, _, err := Verify(context.Background(), store)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, checked)
+	assert.Equal(t, Anonymous, store.entries[0].Actor)
+	assert.Equal(t, "req-1", store.entries[0].RequestID)
+
+	// rewrite history in the middle of the chain
+	store.entries[1].Diff = json.RawMessage(`{}`)
+
+	checked, badID, err := Verify(context.Background(), store)
+	assert.ErrorIs(t, err, ErrChainBroken)
+	assert.Equal(t, int64(2), badID)
+	assert.Equal(t, 1, checked)
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrNotFound           = errors.New("not found")
+)
+
+const (
+	// AccessTokenTTL - how long an access JWT stays valid
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL - how long a refresh token can be exchanged
+	RefreshTokenTTL = 7 * 24 * time.Hour
+	// RoleUser - default role for new accounts
+	RoleUser = "user"
+	// RoleAdmin - may access the /api/v1/admin endpoints
+	RoleAdmin = "admin"
+
+	// clockSkew - leeway allowed on exp/iat/nbf between us and the issuer
+	clockSkew = 30 * time.Second
+)
+
+// allowedAlgorithms - the only signing methods we accept.
+// Anything else, "none" included, is rejected before the key is looked up.
+var allowedAlgorithms = []string{jwt.SigningMethodHS256.Alg()}
+
+// User - a local account that can authenticate against the API
+type User struct {
+	ID           string
+	Username     string
+	PasswordHash string
+	Role         string
+	CreatedAt    time.Time
+}
+
+// RefreshToken - the stored representation of a refresh token
+// only the sha256 hash of the raw token is ever persisted
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// Claims - the claims carried by an access token
+type Claims struct {
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair - what gets handed back to the client on a successful grant
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+}
+
+// Store - the persistence contract for the auth service
+type Store interface {
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	GetUserByID(ctx context.Context, id string) (User, error)
+	CreateUser(ctx context.Context, u User) (User, error)
+
+	CreateRefreshToken(ctx context.Context, rt RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	// ConsumeRefreshToken marks the token as revoked and reports
+	// whether this call was the one that revoked it
+	ConsumeRefreshToken(ctx context.Context, id string) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// Service - issues, validates and revokes tokens
+type Service struct {
+	Store      Store
+	signingKey []byte
+	now        func() time.Time
+}
+
+// NewService - returns a new auth service signing tokens with the given key
+func NewService(store Store, signingKey []byte) *Service {
+	return &Service{
+		Store:      store,
+		signingKey: signingKey,
+		now:        time.Now,
+	}
+}
+
+// CreateUser - hashes the password and stores a new user,
+// an empty role falls back to RoleUser
+func (s *Service) CreateUser(
+	ctx context.Context,
+	username, password, role string,
+) (User, error) {
+	if role == "" {
+		role = RoleUser
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.Store.CreateUser(ctx, User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+	})
+}
+
+// Login - checks the credentials and issues a fresh token pair
+func (s *Service) Login(
+	ctx context.Context,
+	username, password string,
+) (TokenPair, error) {
+	user, err := s.Store.GetUserByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// as slow as a wrong password, or timing tells which
+			// usernames exist
+			VerifyPassword(dummyHash(), password)
+			return TokenPair{}, ErrInvalidCredentials
+		}
+		return TokenPair{}, err
+	}
+
+	ok, err := VerifyPassword(user.PasswordHash, password)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !ok {
+		return TokenPair{}, ErrInvalidCredentials
+	}
+
+	return s.issue(ctx, user, uuid.NewV4().String())
+}
+
+// Refresh - rotates a refresh token: the presented token is consumed
+// and a new pair is issued in the same family. Presenting an already
+// consumed token revokes the whole family, since it means the token leaked.
+func (s *Service) Refresh(
+	ctx context.Context,
+	rawRefreshToken string,
+) (TokenPair, error) {
+	rt, err := s.Store.GetRefreshToken(ctx, hashToken(rawRefreshToken))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return TokenPair{}, ErrInvalidToken
+		}
+		return TokenPair{}, err
+	}
+
+	if rt.RevokedAt != nil {
+		if err := s.Store.RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrTokenRevoked
+	}
+
+	if s.now().After(rt.ExpiresAt) {
+		return TokenPair{}, ErrInvalidToken
+	}
+
+	consumed, err := s.Store.ConsumeRefreshToken(ctx, rt.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !consumed {
+		// somebody else rotated it first, treat it as reuse
+		if err := s.Store.RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrTokenRevoked
+	}
+
+	user, err := s.Store.GetUserByID(ctx, rt.UserID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return s.issue(ctx, user, rt.FamilyID)
+}
+
+// Revoke - adds the access token's jti to the denylist and, when a
+// refresh token is given, revokes its whole family as well
+func (s *Service) Revoke(
+	ctx context.Context,
+	claims Claims,
+	rawRefreshToken string,
+) error {
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := s.Store.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+	}
+
+	if rawRefreshToken == "" {
+		return nil
+	}
+
+	rt, err := s.Store.GetRefreshToken(ctx, hashToken(rawRefreshToken))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	if claims.Subject != "" && rt.UserID != claims.Subject {
+		return ErrInvalidToken
+	}
+
+	return s.Store.RevokeRefreshTokenFamily(ctx, rt.FamilyID)
+}
+
+// ValidateToken - parses the access token, checks the signature
+// and makes sure its jti is not on the denylist.
+// Only HS256 is accepted and the token must carry an exp claim.
+func (s *Service) ValidateToken(
+	ctx context.Context,
+	accessToken string,
+) (Claims, error) {
+	var claims Claims
+
+	token, err := jwt.ParseWithClaims(
+		accessToken, &claims, func(token *jwt.Token) (interface{}, error) {
+			return s.signingKey, nil
+		},
+		jwt.WithValidMethods(allowedAlgorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+		jwt.WithTimeFunc(s.now),
+	)
+
+	if err != nil || !token.Valid {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if claims.ID != "" {
+		revoked, err := s.Store.IsTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return Claims{}, fmt.Errorf("could not check the token denylist: %w", err)
+		}
+		if revoked {
+			return Claims{}, ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+func (s *Service) issue(
+	ctx context.Context,
+	user User,
+	familyID string,
+) (TokenPair, error) {
+	now := s.now()
+
+	claims := Claims{
+		Username: user.Username,
+		Role:     user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewV4().String(),
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).
+		SignedString(s.signingKey)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("could not sign the access token: %w", err)
+	}
+
+	rawRefreshToken, err := newRefreshToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	err = s.Store.CreateRefreshToken(ctx, RefreshToken{
+		ID:        uuid.NewV4().String(),
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(rawRefreshToken),
+		ExpiresAt: now.Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: rawRefreshToken,
+		ExpiresIn:    AccessTokenTTL,
+	}, nil
+}
+
+// newRefreshToken - 32 random bytes, url safe encoded
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate a refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateToken(t *testing.T) {
+	key := []byte("test-key")
+	// no jti in these tokens, so the store is never consulted
+	svc := NewService(nil, key)
+
+	sign := func(method jwt.SigningMethod, claims jwt.Claims, k interface{}) string {
+		s, err := jwt.NewWithClaims(method, claims).SignedString(k)
+		assert.NoError(t, err)
+		return s
+	}
+
+	valid := jwt.RegisteredClaims{
+		Subject:   "user",
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+
+	t.Run("accepts HS256 with expiry", func(t *testing.T) {
+		claims, err := svc.ValidateToken(context.Background(), sign(jwt.SigningMethodHS256, valid, key))
+		assert.NoError(t, err)
+		assert.Equal(t, "user", claims.Subject)
+	})
+
+	t.Run("rejects a token without exp", func(t *testing.T) {
+		_, err := svc.ValidateToken(context.Background(),
+			sign(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "user"}, key))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("rejects an expired token", func(t *testing.T) {
+		expired := valid
+		expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		_, err := svc.ValidateToken(context.Background(), sign(jwt.SigningMethodHS256, expired, key))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("rejects algorithms outside the allow-list", func(t *testing.T) {
+		_, err := svc.ValidateToken(context.Background(), sign(jwt.SigningMethodHS512, valid, key))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		_, err = svc.ValidateToken(context.Background(),
+			sign(jwt.SigningMethodNone, valid, jwt.UnsafeAllowNoneSignatureType))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("rejects a token signed with another key", func(t *testing.T) {
+		_, err := svc.ValidateToken(context.Background(),
+			sign(jwt.SigningMethodHS256, valid, []byte("other-key")))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+// memoryStore - Store kept in maps, enough for the service's own logic
+type memoryStore struct {
+	mu      sync.Mutex
+	users   map[string]User
+	tokens  map[string]RefreshToken
+	revoked map[string]bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:   map[string]User{},
+		tokens:  map[string]RefreshToken{},
+		revoked: map[string]bool{},
+	}
+}
+
+func (s *memoryStore) GetUserByUsername(_ context.Context, username string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memoryStore) GetUserByID(_ context.Context, id string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *memoryStore) CreateUser(_ context.Context, u User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.ID = fmt.Sprintf("user-%d", len(s.users)+1)
+	s.users[u.ID] = u
+	return u, nil
+}
+
+func (s *memoryStore) CreateRefreshToken(_ context.Context, rt RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[rt.TokenHash] = rt
+	return nil
+}
+
+func (s *memoryStore) GetRefreshToken(_ context.Context, tokenHash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.tokens[tokenHash]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *memoryStore) ConsumeRefreshToken(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, rt := range s.tokens {
+		if rt.ID == id && rt.RevokedAt == nil {
+			now := time.Now()
+			rt.RevokedAt = &now
+			s.tokens[hash] = rt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) RevokeRefreshTokenFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for hash, rt := range s.tokens {
+		if rt.FamilyID == familyID && rt.RevokedAt == nil {
+			rt.RevokedAt = &now
+			s.tokens[hash] = rt
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RevokeToken(_ context.Context, jti string, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[jti] = true
+	return nil
+}
+
+func (s *memoryStore) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[jti], nil
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newMemoryStore(), []byte("test-key"))
+	_, err := svc.CreateUser(ctx, "ann", "s3cret", "")
+	require.NoError(t, err)
+
+	t.Run("issues a pair for the right password", func(t *testing.T) {
+		pair, err := svc.Login(ctx, "ann", "s3cret")
+		require.NoError(t, err)
+		assert.NotEmpty(t, pair.RefreshToken)
+		assert.Equal(t, AccessTokenTTL, pair.ExpiresIn)
+
+		claims, err := svc.ValidateToken(ctx, pair.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "ann", claims.Username)
+		assert.Equal(t, RoleUser, claims.Role)
+		assert.NotEmpty(t, claims.ID)
+	})
+
+	t.Run("a wrong password and an unknown user look the same", func(t *testing.T) {
+		_, err := svc.Login(ctx, "ann", "wrong")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		_, err = svc.Login(ctx, "bob", "wrong")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("unknown users pay for a bcrypt compare too", func(t *testing.T) {
+		cost, err := bcrypt.Cost([]byte(dummyHash()))
+		require.NoError(t, err)
+		assert.Equal(t, bcrypt.DefaultCost, cost)
+
+		timed := func(username string) time.Duration {
+			start := time.Now()
+			svc.Login(ctx, username, "wrong")
+			return time.Since(start)
+		}
+		// loose on purpose, without the dummy hash bob is turned down
+		// orders of magnitude faster
+		assert.Greater(t, timed("bob"), timed("ann")/4)
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newMemoryStore(), []byte("test-key"))
+	_, err := svc.CreateUser(ctx, "ann", "s3cret", "")
+	require.NoError(t, err)
+
+	first, err := svc.Login(ctx, "ann", "s3cret")
+	require.NoError(t, err)
+
+	second, err := svc.Refresh(ctx, first.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	t.Run("unknown tokens are invalid", func(t *testing.T) {
+		_, err := svc.Refresh(ctx, "nope")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("reuse revokes the whole family", func(t *testing.T) {
+		_, err := svc.Refresh(ctx, first.RefreshToken)
+		assert.ErrorIs(t, err, ErrTokenRevoked)
+
+		_, err = svc.Refresh(ctx, second.RefreshToken)
+		assert.ErrorIs(t, err, ErrTokenRevoked)
+	})
+
+	t.Run("expired tokens are invalid", func(t *testing.T) {
+		pair, err := svc.Login(ctx, "ann", "s3cret")
+		require.NoError(t, err)
+
+		later := NewService(svc.Store, []byte("test-key"))
+		later.now = func() time.Time { return time.Now().Add(RefreshTokenTTL + time.Hour) }
+		_, err = later.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newMemoryStore(), []byte("test-key"))
+	_, err := svc.CreateUser(ctx, "ann", "s3cret", "")
+	require.NoError(t, err)
+
+	pair, err := svc.Login(ctx, "ann", "s3cret")
+	require.NoError(t, err)
+	claims, err := svc.ValidateToken(ctx, pair.AccessToken)
+	require.NoError(t, err)
+
+	t.Run("someone else's refresh token is refused", func(t *testing.T) {
+		other := claims
+		other.Subject = "user-2"
+		assert.ErrorIs(t, svc.Revoke(ctx, other, pair.RefreshToken), ErrInvalidToken)
+	})
+
+	require.NoError(t, svc.Revoke(ctx, claims, pair.RefreshToken))
+
+	_, err = svc.ValidateToken(ctx, pair.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = svc.Refresh(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
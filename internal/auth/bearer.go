@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrMalformedAuthorization = errors.New("malformed authorization header")
+
+// BearerToken - extracts the token from the values of an authorization
+// header, `Bearer <token>`, whether it came over HTTP or gRPC metadata.
+// The scheme is matched case-insensitively (RFC 7235) and any run of
+// whitespace separates it from the token, but exactly one value with
+// exactly one non-empty token is required.
+func BearerToken(values []string) (string, error) {
+	if len(values) != 1 {
+		return "", ErrMalformedAuthorization
+	}
+
+	fields := strings.Fields(values[0])
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "bearer") {
+		return "", ErrMalformedAuthorization
+	}
+
+	return fields[1], nil
+}
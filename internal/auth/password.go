@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// dummyHash - what Login compares against for usernames that don't
+// exist, so they take as long to turn down as wrong passwords. Hashed on
+// first use, not every process has to pay for it.
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := HashPassword("not the password of anyone")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// HashPassword - hashes a password with bcrypt at the default cost
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("could not hash the password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword - compares a password against a stored hash.
+// Both bcrypt ($2a$, $2b$, $2y$) and argon2id PHC strings
+// ($argon2id$v=19$m=...,t=...,p=...$salt$hash) are supported.
+func VerifyPassword(encodedHash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encodedHash, "$2a$"),
+		strings.HasPrefix(encodedHash, "$2b$"),
+		strings.HasPrefix(encodedHash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not compare bcrypt hash: %w", err)
+		}
+		return true, nil
+
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		return verifyArgon2id(encodedHash, password)
+	}
+
+	return false, ErrUnknownHashFormat
+}
+
+func verifyArgon2id(encodedHash, password string) (bool, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, hash
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return false, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, ErrUnknownHashFormat
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrUnknownHashFormat
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrUnknownHashFormat
+	}
+
+	got := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(want)))
+
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/argon2"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	t.Run("bcrypt hash", func(t *testing.T) {
+		hash, err := HashPassword("s3cret")
+		assert.NoError(t, err)
+
+		ok, err := VerifyPassword(hash, "s3cret")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = VerifyPassword(hash, "wrong")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("argon2id hash", func(t *testing.T) {
+		salt := []byte("0123456789abcdef")
+		key := argon2.IDKey([]byte("s3cret"), salt, 1, 64*1024, 2, 32)
+		hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, 64*1024, 1, 2,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		)
+
+		ok, err := VerifyPassword(hash, "s3cret")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = VerifyPassword(hash, "wrong")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("unknown hash format", func(t *testing.T) {
+		_, err := VerifyPassword("plaintext", "plaintext")
+		assert.ErrorIs(t, err, ErrUnknownHashFormat)
+	})
+}
@@ -0,0 +1,176 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen - returned without calling through while the circuit is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State - closed lets calls through, open fails them fast,
+// half-open lets a single probe through to see if things recovered
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Options - how quickly the breaker opens and how long it stays open
+type Options struct {
+	// FailureThreshold - consecutive failures that open the circuit
+	FailureThreshold int
+	// OpenTimeout - how long the circuit stays open before a probe
+	OpenTimeout time.Duration
+	// IsFailure - which errors count against the dependency, a missing
+	// row is an answer and not an outage. nil counts every error.
+	IsFailure func(error) bool
+	// OnStateChange - called outside the lock on every transition
+	OnStateChange func(from, to State)
+}
+
+// Breaker - a consecutive-failure circuit breaker, safe for concurrent use
+type Breaker struct {
+	opts Options
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func New(opts Options) *Breaker {
+	if opts.FailureThreshold < 1 {
+		opts.FailureThreshold = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool { return err != nil }
+	}
+	return &Breaker{opts: opts, now: time.Now}
+}
+
+// State - the current state, an expired open circuit reports half-open
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.opts.OpenTimeout {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// RetryAfter - how long until the next probe is allowed, 0 unless open
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != Open {
+		return 0
+	}
+	if d := b.opts.OpenTimeout - b.now().Sub(b.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Do - runs fn unless the circuit is open, and records its outcome
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	b.record(err)
+	return err
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.opts.OpenTimeout {
+			b.mu.Unlock()
+			return ErrOpen
+		}
+		b.probing = true
+		b.transition(HalfOpen)
+		return nil
+	case HalfOpen:
+		// only one probe at a time, everybody else keeps failing fast
+		if b.probing {
+			b.mu.Unlock()
+			return ErrOpen
+		}
+		b.probing = true
+	}
+
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Breaker) record(err error) {
+	failed := b.IsFailure(err)
+
+	b.mu.Lock()
+
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			break
+		}
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.openedAt = b.now()
+			b.transition(Open)
+			return
+		}
+	case HalfOpen:
+		b.probing = false
+		b.failures = 0
+		if failed {
+			b.openedAt = b.now()
+			b.transition(Open)
+			return
+		}
+		b.transition(Closed)
+		return
+	}
+
+	b.mu.Unlock()
+}
+
+// transition - must be called with the lock held, releases it
+func (b *Breaker) transition(to State) {
+	from := b.state
+	b.state = to
+	b.mu.Unlock()
+
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(from, to)
+	}
+}
+
+// IsFailure - whether err counts against the dependency
+func (b *Breaker) IsFailure(err error) bool {
+	return err != nil && b.opts.IsFailure(err)
+}
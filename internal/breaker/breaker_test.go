@@ -0,0 +1,140 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errDown     = errors.New("connection refused")
+	errNotFound = errors.New("no rows")
+)
+
+func newTestBreaker(now *time.Time) *Breaker {
+	b := New(Options{
+		FailureThreshold: 3,
+		OpenTimeout:      10 * time.Second,
+		IsFailure:        func(err error) bool { return errors.Is(err, errDown) },
+	})
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+func fail() error    { return errDown }
+func succeed() error { return nil }
+
+func TestBreaker(t *testing.T) {
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, b.Do(fail), errDown)
+		}
+		assert.Equal(t, Open, b.State())
+
+		called := false
+		err := b.Do(func() error { called = true; return nil })
+		assert.ErrorIs(t, err, ErrOpen)
+		assert.False(t, called)
+		assert.Equal(t, 10*time.Second, b.RetryAfter())
+	})
+
+	t.Run("a success resets the count", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+
+		b.Do(fail)
+		b.Do(fail)
+		b.Do(succeed)
+		b.Do(fail)
+		assert.Equal(t, Closed, b.State())
+	})
+
+	t.Run("errors that are not outages don't count", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+
+		for i := 0; i < 5; i++ {
+			b.Do(func() error { return errNotFound })
+		}
+		assert.Equal(t, Closed, b.State())
+	})
+
+	t.Run("a successful probe closes it again", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+		for i := 0; i < 3; i++ {
+			b.Do(fail)
+		}
+
+		now = now.Add(10 * time.Second)
+		assert.Equal(t, HalfOpen, b.State())
+		assert.NoError(t, b.Do(succeed))
+		assert.Equal(t, Closed, b.State())
+	})
+
+	t.Run("a failed probe opens it for another timeout", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+		for i := 0; i < 3; i++ {
+			b.Do(fail)
+		}
+
+		now = now.Add(10 * time.Second)
+		assert.ErrorIs(t, b.Do(fail), errDown)
+		assert.Equal(t, Open, b.State())
+		assert.ErrorIs(t, b.Do(succeed), ErrOpen)
+	})
+
+	t.Run("only one probe at a time", func(t *testing.T) {
+		now := time.Now()
+		b := newTestBreaker(&now)
+		for i := 0; i < 3; i++ {
+			b.Do(fail)
+		}
+		now = now.Add(10 * time.Second)
+
+		err := b.Do(func() error {
+			assert.ErrorIs(t, b.Do(succeed), ErrOpen)
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("reports state changes", func(t *testing.T) {
+		var changes []State
+		b := New(Options{
+			FailureThreshold: 1,
+			OpenTimeout:      time.Minute,
+			OnStateChange:    func(_, to State) { changes = append(changes, to) },
+		})
+
+		b.Do(fail)
+		assert.Equal(t, []State{Open}, changes)
+	})
+}
+
+type downStore struct{ comment.Store }
+
+func (downStore) GetComment(context.Context, string) (comment.Comment, error) {
+	return comment.Comment{}, errDown
+}
+
+func TestGuardStore(t *testing.T) {
+	now := time.Now()
+	s := GuardStore(downStore{}, newTestBreaker(&now))
+
+	for i := 0; i < 4; i++ {
+		_, err := s.GetComment(context.Background(), "id")
+		assert.ErrorIs(t, err, comment.ErrUnavailable)
+	}
+
+	_, err := s.GetComment(context.Background(), "id")
+	assert.ErrorIs(t, err, ErrOpen)
+}
@@ -0,0 +1,132 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+)
+
+// Store - wraps a comment.Store so an unreachable database fails fast.
+// Outages, whether the circuit is open or a call just failed to reach
+// the database, come back wrapped in comment.ErrUnavailable.
+type Store struct {
+	next    comment.Store
+	breaker *Breaker
+}
+
+// GuardStore - returns next behind the breaker
+func GuardStore(next comment.Store, b *Breaker) *Store {
+	return &Store{next: next, breaker: b}
+}
+
+func (s *Store) do(fn func() error) error {
+	err := s.breaker.Do(fn)
+	if errors.Is(err, ErrOpen) || s.breaker.IsFailure(err) {
+		return fmt.Errorf("%w: %w", comment.ErrUnavailable, err)
+	}
+	return err
+}
+
+func (s *Store) GetComment(ctx context.Context, id string) (cmt comment.Comment, err error) {
+	err = s.do(func() error {
+		cmt, err = s.next.GetComment(ctx, id)
+		return err
+	})
+	return cmt, err
+}
+
+func (s *Store) GetCommentForUpdate(ctx context.Context, id string) (cmt comment.Comment, err error) {
+	err = s.do(func() error {
+		cmt, err = s.next.GetCommentForUpdate(ctx, id)
+		return err
+	})
+	return cmt, err
+}
+
+func (s *Store) PostComment(ctx context.Context, c comment.Comment) (cmt comment.Comment, err error) {
+	err = s.do(func() error {
+		cmt, err = s.next.PostComment(ctx, c)
+		return err
+	})
+	return cmt, err
+}
+
+func (s *Store) DeleteComment(ctx context.Context, id string) (deleted bool, err error) {
+	err = s.do(func() error {
+		deleted, err = s.next.DeleteComment(ctx, id)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *Store) UpdateComment(ctx context.Context, id string, c comment.Comment) (cmt comment.Comment, err error) {
+	err = s.do(func() error {
+		cmt, err = s.next.UpdateComment(ctx, id, c)
+		return err
+	})
+	return cmt, err
+}
+
+func (s *Store) GetMultipleComment(ctx context.Context) (cmts []comment.Comment, err error) {
+	err = s.do(func() error {
+		cmts, err = s.next.GetMultipleComment(ctx)
+		return err
+	})
+	return cmts, err
+}
+
+func (s *Store) ListComments(ctx context.Context, page comment.Page) (cmts []comment.Comment, err error) {
+	err = s.do(func() error {
+		cmts, err = s.next.ListComments(ctx, page)
+		return err
+	})
+	return cmts, err
+}
+
+func (s *Store) ListCommentsBy(
+	ctx context.Context,
+	field comment.Field,
+	values []string,
+	page comment.Page,
+) (cmts map[string][]comment.Comment, err error) {
+	err = s.do(func() error {
+		cmts, err = s.next.ListCommentsBy(ctx, field, values, page)
+		return err
+	})
+	return cmts, err
+}
+
+func (s *Store) CountCommentsBy(ctx context.Context, field comment.Field, values []string) (counts map[string]int, err error) {
+	err = s.do(func() error {
+		counts, err = s.next.CountCommentsBy(ctx, field, values)
+		return err
+	})
+	return counts, err
+}
+
+// Transactor - fails fast instead of beginning a transaction while the
+// circuit is open. It doesn't record outcomes itself, the store calls
+// made inside the transaction already do.
+type Transactor struct {
+	next    comment.Transactor
+	breaker *Breaker
+}
+
+// GuardTransactor - returns next behind the breaker
+func GuardTransactor(next comment.Transactor, b *Breaker) *Transactor {
+	return &Transactor{next: next, breaker: b}
+}
+
+func (t *Transactor) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t.breaker.State() == Open {
+		return fmt.Errorf("%w: %w", comment.ErrUnavailable, ErrOpen)
+	}
+
+	err := t.next.WithTx(ctx, fn)
+	if !errors.Is(err, comment.ErrUnavailable) && t.breaker.IsFailure(err) {
+		return fmt.Errorf("%w: %w", comment.ErrUnavailable, err)
+	}
+	return err
+}
@@ -4,11 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	ErrFetchingComment = errors.New("failed to fetch comment by id")
 	ErrNotImplemented  = errors.New("not implemented")
+	// ErrNotFound - no comment with the given id
+	ErrNotFound = errors.New("comment not found")
+	// ErrUnavailable - the store can't be reached right now, retry later
+	ErrUnavailable = errors.New("comment store is unavailable")
 )
 
 // Comment - a representation of the comment
@@ -28,10 +39,99 @@ type Comment struct {
 // they will access the interface decalaration func form anywhere
 type Store interface {
 	GetComment(context.Context, string) (Comment, error)
+	// GetCommentForUpdate - GetComment that also locks the row until the
+	// transaction in ctx ends, so it can't change before it is written
+	GetCommentForUpdate(context.Context, string) (Comment, error)
 	PostComment(context.Context, Comment) (Comment, error)
-	DeleteComment(context.Context, string) error
+	// DeleteComment reports whether a comment was actually removed
+	DeleteComment(context.Context, string) (bool, error)
 	UpdateComment(context.Context, string, Comment) (Comment, error)
 	GetMultipleComment(context.Context) ([]Comment, error)
+	// ListComments, ListCommentsBy, CountCommentsBy - see the Service methods
+	ListComments(context.Context, Page) ([]Comment, error)
+	ListCommentsBy(context.Context, Field, []string, Page) (map[string][]Comment, error)
+	CountCommentsBy(context.Context, Field, []string) (map[string]int, error)
+}
+
+// Transactor - runs fn as one unit of work: store and audit calls made
+// with the ctx passed to fn commit together or not at all. Nested calls
+// use savepoints, fn may be retried so it must only touch the store.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Auditor - records every mutating operation for the audit trail
+// before is nil for creates, after is nil for deletes
+type Auditor interface {
+	Record(ctx context.Context, action, resource, resourceID string, before, after any) error
+}
+
+// Emitter - publishes domain events about comments. Events emitted inside
+// a transaction must only become visible once it commits.
+type Emitter interface {
+	Emit(ctx context.Context, eventType, aggregateType, aggregateID string, data any) error
+}
+
+// Change - a committed change to a comment
+type Change struct {
+	// Type - one of the Event* constants
+	Type    string
+	Comment Comment
+	// Previous - the comment before an update, zero otherwise. Its body
+	// is left out when the change came from another replica and didn't
+	// fit a notification, see db.NotifyCommentChange.
+	Previous Comment
+}
+
+// Notifier - told about every change once it is committed, for live
+// updates. Rolled back changes are never notified. Notify must not block.
+type Notifier interface {
+	Notify(ctx context.Context, c Change)
+}
+
+// TxNotifier - a Notifier whose delivery is transactional itself, like
+// postgres NOTIFY. It is told inside the transaction instead, with its
+// ctx, and only delivers if the change commits. A failure costs live
+// clients the update, not the change.
+type TxNotifier interface {
+	Notifier
+	NotifyInTx(ctx context.Context, c Change) error
+}
+
+// Counter - counts changes once they are committed, by event type, so
+// rolled back and retried attempts don't show up in the metrics
+type Counter interface {
+	CommentChanged(eventType string)
+}
+
+// auditResource - the resource name comments are recorded and emitted under
+const auditResource = "comment"
+
+// Domain event types, consumers subscribe to these names
+const (
+	EventCommentCreated = "comment.created"
+	EventCommentUpdated = "comment.updated"
+	EventCommentDeleted = "comment.deleted"
+)
+
+// EventTypes - every event type the service emits
+var EventTypes = []string{EventCommentCreated, EventCommentUpdated, EventCommentDeleted}
+
+// CommentCreated - the data of a comment.created event
+type CommentCreated struct {
+	Comment Comment `json:"comment"`
+}
+
+// CommentUpdated - the data of a comment.updated event
+type CommentUpdated struct {
+	Comment  Comment `json:"comment"`
+	Previous Comment `json:"previous"`
+}
+
+// CommentDeleted - the data of a comment.deleted event,
+// the comment as it was before it was removed
+type CommentDeleted struct {
+	Comment Comment `json:"comment"`
 }
 
 // Service - is the struct on which all our
@@ -40,6 +140,23 @@ type Service struct {
 	// here, we ultimatly have a db connection
 	Store Store //? db connection
 
+	// Tx - optional, without it every store call commits on its own
+	Tx Transactor
+
+	// Audit - optional, nothing is recorded when it is nil
+	Audit Auditor
+
+	// Events - optional, no domain events are emitted when it is nil
+	Events Emitter
+
+	// Notifier - optional, told about changes after they commit
+	Notifier Notifier
+
+	// Counter - optional, counts changes after they commit
+	Counter Counter
+
+	Log logging.Logger
+
 	//? why a struct  field as an interface?
 	//ans:
 	/*
@@ -57,18 +174,109 @@ type Service struct {
 // returns a pointer to a new [Service] struct,
 // where Service.Store is a db connection
 // so, every method in this interface can access the db connection
-func NewService(store Store) *Service {
+func NewService(
+	store Store,
+	tx Transactor,
+	auditor Auditor,
+	events Emitter,
+	notifier Notifier,
+	counter Counter,
+	logger logging.Logger,
+) *Service {
 	return &Service{
-		Store: store,
+		Store:    store,
+		Tx:       tx,
+		Audit:    auditor,
+		Events:   events,
+		Notifier: notifier,
+		Counter:  counter,
+		Log:      logger.WithFields(logging.Fields{"component": "comment"}),
+	}
+}
+
+// record - writes an audit entry, a failure here fails the whole operation
+// since a change missing from the trail is worse than a retried request
+func (s *Service) record(
+	ctx context.Context,
+	action, id string,
+	before, after any,
+) error {
+	if s.Audit == nil {
+		return nil
+	}
+	if err := s.Audit.Record(ctx, action, auditResource, id, before, after); err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to record audit entry")
+		return err
+	}
+	return nil
+}
+
+// emit - writes a domain event next to the change. As with record, a
+// failure fails the operation: a lost event is never noticed downstream.
+func (s *Service) emit(ctx context.Context, eventType, id string, data any) error {
+	if s.Events == nil {
+		return nil
+	}
+	if err := s.Events.Emit(ctx, eventType, auditResource, id, data); err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to emit domain event")
+		return err
+	}
+	return nil
+}
+
+// notifyInTx - tells a TxNotifier about c in the transaction of ctx
+func (s *Service) notifyInTx(ctx context.Context, c Change) {
+	n, ok := s.Notifier.(TxNotifier)
+	if !ok {
+		return
+	}
+	if err := n.NotifyInTx(ctx, c); err != nil {
+		s.Log.WithError(err).Warn(ctx, "failed to notify a comment change")
 	}
 }
 
+// notify - only called once the change is committed
+func (s *Service) notify(ctx context.Context, c Change) {
+	if s.Counter != nil {
+		s.Counter.CommentChanged(c.Type)
+	}
+	if _, ok := s.Notifier.(TxNotifier); ok {
+		// went out with the transaction
+		return
+	}
+	if s.Notifier != nil {
+		s.Notifier.Notify(ctx, c)
+	}
+}
+
+// inTx - runs fn in a transaction when there is a Transactor
+func (s *Service) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.Tx == nil {
+		return fn(ctx)
+	}
+	return s.Tx.WithTx(ctx, fn)
+}
+
+// tracer - every Service method runs in its own span
+var tracer = otel.Tracer("github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment")
+
+// endSpan - marks the span as failed when the method returned an error
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
 // Implementing the declared methods
 
 // GetMultipleComment - get all the comments
 func (s *Service) GetMultipleComment(
 	ctx context.Context,
-) ([]Comment, error) {
+) (_ []Comment, err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.GetMultipleComment")
+	defer func() { endSpan(span, err) }()
 
 	///? bcz of repository methods are a reciver of (*Database) struct
 	//? and our Store is also a reciver of (*Database) struct
@@ -80,24 +288,28 @@ func (s *Service) GetMultipleComment(
 	cmts, err := s.Store.GetMultipleComment(ctx)
 
 	if err != nil {
-		fmt.Println(err)
+		s.Log.WithError(err).Error(ctx, "failed to fetch multiple comments")
 		return []Comment{}, err
 	}
 
-	fmt.Println(cmts)
+	// only the count, the comments themselves never belong in the logs
+	s.Log.WithFields(logging.Fields{"count": len(cmts)}).Debug(ctx, "fetched comments")
 	return cmts, nil
 }
 
 func (s *Service) GetComment(
 	ctx context.Context,
 	id string,
-) (Comment, error) {
+) (_ Comment, err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.GetComment",
+		trace.WithAttributes(attribute.String("comment.id", id)))
+	defer func() { endSpan(span, err) }()
 
-	fmt.Println("retreiving a comment")
+	s.Log.WithFields(logging.Fields{"comment_id": id}).Debug(ctx, "retreiving a comment")
 	cmt, err := s.Store.GetComment(ctx, id)
 	if err != nil {
-		fmt.Println(err)
-		return Comment{}, ErrFetchingComment
+		s.Log.WithError(err).Error(ctx, "failed to fetch comment")
+		return Comment{}, fmt.Errorf("%w: %w", ErrFetchingComment, err)
 	}
 	return cmt, nil
 }
@@ -106,14 +318,42 @@ func (s *Service) UpdateComment(
 	ctx context.Context,
 	id string,
 	cmt Comment,
-) (Comment, error) {
+) (_ Comment, err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.UpdateComment",
+		trace.WithAttributes(attribute.String("comment.id", id)))
+	defer func() { endSpan(span, err) }()
 
-	updatedCmt, err := s.Store.UpdateComment(ctx, id, cmt)
+	// the change, its audit entry and its event commit together
+	var updatedCmt, before Comment
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		// the previous state is needed for the audit diff, locked so a
+		// concurrent change can't slip in between it and the update
+		before, err = s.Store.GetCommentForUpdate(ctx, id)
+		if err != nil {
+			s.Log.WithError(err).Error(ctx, "failed to fetch comment before update")
+			return fmt.Errorf("%w: %w", ErrFetchingComment, err)
+		}
 
+		updatedCmt, err = s.Store.UpdateComment(ctx, id, cmt)
+
+		if err != nil {
+			s.Log.WithError(err).Error(ctx, "failed to update comment")
+			return err
+		}
+
+		if err := s.record(ctx, audit.ActionUpdate, id, before, updatedCmt); err != nil {
+			return err
+		}
+		if err := s.emit(ctx, EventCommentUpdated, id, CommentUpdated{Comment: updatedCmt, Previous: before}); err != nil {
+			return err
+		}
+		s.notifyInTx(ctx, Change{Type: EventCommentUpdated, Comment: updatedCmt, Previous: before})
+		return nil
+	})
 	if err != nil {
-		fmt.Println(err)
 		return Comment{}, err
 	}
+	s.notify(ctx, Change{Type: EventCommentUpdated, Comment: updatedCmt, Previous: before})
 
 	return updatedCmt, nil
 }
@@ -121,16 +361,46 @@ func (s *Service) UpdateComment(
 func (s *Service) DeleteComment(
 	ctx context.Context,
 	id string,
-) error {
+) (err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.DeleteComment",
+		trace.WithAttributes(attribute.String("comment.id", id)))
+	defer func() { endSpan(span, err) }()
+
+	var before Comment
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		before, err = s.Store.GetCommentForUpdate(ctx, id)
+		if err != nil {
+			s.Log.WithError(err).Error(ctx, "failed to fetch comment before delete")
+			return fmt.Errorf("%w: %w", ErrFetchingComment, err)
+		}
 
-	err := s.Store.DeleteComment(ctx, id)
+		deleted, err := s.Store.DeleteComment(ctx, id)
+		if err != nil {
+			s.Log.WithError(err).Error(ctx, "failed to delete comment")
+			return err
+
+		}
+		// without a transaction somebody else may have deleted it since
+		// we fetched it, nothing to audit then
+		if !deleted {
+			return ErrNotFound
+		}
+
+		if err := s.record(ctx, audit.ActionDelete, id, before, nil); err != nil {
+			return err
+		}
+		if err := s.emit(ctx, EventCommentDeleted, id, CommentDeleted{Comment: before}); err != nil {
+			return err
+		}
+		s.notifyInTx(ctx, Change{Type: EventCommentDeleted, Comment: before})
+		return nil
+	})
 	if err != nil {
-		fmt.Println(err)
 		return err
-
 	}
+	s.notify(ctx, Change{Type: EventCommentDeleted, Comment: before})
 
-	fmt.Println("deleting a comment")
+	s.Log.WithFields(logging.Fields{"comment_id": id}).Info(ctx, "deleted a comment")
 
 	return nil
 }
@@ -138,7 +408,9 @@ func (s *Service) DeleteComment(
 func (s *Service) PostComment(
 	ctx context.Context,
 	cmt Comment,
-) (Comment, error) {
+) (_ Comment, err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.PostComment")
+	defer func() { endSpan(span, err) }()
 
 	// communitcate with the Repository layer: Store.PostComment
 	// which is a member of the Service struct
@@ -149,12 +421,29 @@ func (s *Service) PostComment(
 	// so that we can call the Method form reppo layer by calling the Store.PostComment;
 	// which also takes a reciver of the Store struct i,e a db connection
 
-	insertedCmt, err := s.Store.PostComment(ctx, cmt)
+	var insertedCmt Comment
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		insertedCmt, err = s.Store.PostComment(ctx, cmt)
+
+		if err != nil {
+			s.Log.WithError(err).Error(ctx, "failed to post comment")
+			return err
+		}
 
+		if err := s.record(ctx, audit.ActionCreate, insertedCmt.ID, nil, insertedCmt); err != nil {
+			return err
+		}
+		if err := s.emit(ctx, EventCommentCreated, insertedCmt.ID, CommentCreated{Comment: insertedCmt}); err != nil {
+			return err
+		}
+		s.notifyInTx(ctx, Change{Type: EventCommentCreated, Comment: insertedCmt})
+		return nil
+	})
 	if err != nil {
-		fmt.Println(err)
 		return Comment{}, err
 	}
+	span.SetAttributes(attribute.String("comment.id", insertedCmt.ID))
+	s.notify(ctx, Change{Type: EventCommentCreated, Comment: insertedCmt})
 
 	return insertedCmt, nil
 }
@@ -0,0 +1,248 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type txKey struct{}
+
+// fakeTx - marks the context so the store can tell it runs inside WithTx
+type fakeTx struct {
+	calls int
+}
+
+func (f *fakeTx) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	f.calls++
+	return fn(context.WithValue(ctx, txKey{}, true))
+}
+
+type fakeStore struct {
+	Store
+	inTx   []bool
+	pages  []Page
+	locked []string
+}
+
+func (f *fakeStore) seen(ctx context.Context) {
+	f.inTx = append(f.inTx, ctx.Value(txKey{}) != nil)
+}
+
+func (f *fakeStore) GetComment(ctx context.Context, id string) (Comment, error) {
+	f.seen(ctx)
+	return Comment{ID: id, Body: "before"}, nil
+}
+
+func (f *fakeStore) GetCommentForUpdate(ctx context.Context, id string) (Comment, error) {
+	f.seen(ctx)
+	f.locked = append(f.locked, id)
+	return Comment{ID: id, Body: "before"}, nil
+}
+
+func (f *fakeStore) UpdateComment(ctx context.Context, id string, c Comment) (Comment, error) {
+	f.seen(ctx)
+	c.ID = id
+	return c, nil
+}
+
+func (f *fakeStore) DeleteComment(ctx context.Context, id string) (bool, error) {
+	f.seen(ctx)
+	return false, nil
+}
+
+func (f *fakeStore) PostComment(ctx context.Context, c Comment) (Comment, error) {
+	f.seen(ctx)
+	c.ID = "42"
+	return c, nil
+}
+
+func (f *fakeStore) ListCommentsBy(_ context.Context, _ Field, _ []string, page Page) (map[string][]Comment, error) {
+	f.pages = append(f.pages, page)
+	return map[string][]Comment{}, nil
+}
+
+type fakeEmitter struct {
+	types []string
+	inTx  []bool
+}
+
+func (f *fakeEmitter) Emit(ctx context.Context, eventType, _, _ string, _ any) error {
+	f.types = append(f.types, eventType)
+	f.inTx = append(f.inTx, ctx.Value(txKey{}) != nil)
+	return nil
+}
+
+type fakeNotifier struct {
+	changes []Change
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, c Change) {
+	f.changes = append(f.changes, c)
+}
+
+// fakeTxNotifier - a notifier that joins the transaction
+type fakeTxNotifier struct {
+	fakeNotifier
+	err  error
+	inTx []bool
+}
+
+func (f *fakeTxNotifier) NotifyInTx(ctx context.Context, c Change) error {
+	f.inTx = append(f.inTx, ctx.Value(txKey{}) != nil)
+	f.changes = append(f.changes, c)
+	return f.err
+}
+
+type fakeCounter struct {
+	counted []string
+}
+
+func (f *fakeCounter) CommentChanged(eventType string) {
+	f.counted = append(f.counted, eventType)
+}
+
+type fakeAuditor struct {
+	err  error
+	inTx bool
+}
+
+func (f *fakeAuditor) Record(ctx context.Context, _, _, _ string, _, _ any) error {
+	f.inTx = ctx.Value(txKey{}) != nil
+	return f.err
+}
+
+func TestServiceUnitOfWork(t *testing.T) {
+	t.Run("the change and its audit entry share the transaction", func(t *testing.T) {
+		store, tx, auditor := &fakeStore{}, &fakeTx{}, &fakeAuditor{}
+		s := NewService(store, tx, auditor, nil, nil, nil, logging.Nop())
+
+		_, err := s.UpdateComment(context.Background(), "42", Comment{Body: "after"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, 1, tx.calls)
+		assert.Equal(t, []bool{true, true}, store.inTx)
+		assert.True(t, auditor.inTx)
+		assert.Equal(t, []string{"42"}, store.locked, "the previous state is read locked")
+	})
+
+	t.Run("an audit failure fails the transaction", func(t *testing.T) {
+		boom := errors.New("audit_log is gone")
+		s := NewService(&fakeStore{}, &fakeTx{}, &fakeAuditor{err: boom}, nil, nil, nil, logging.Nop())
+
+		_, err := s.UpdateComment(context.Background(), "42", Comment{Body: "after"})
+		assert.ErrorIs(t, err, boom)
+	})
+
+	t.Run("deleting a row that is already gone is not found", func(t *testing.T) {
+		auditor := &fakeAuditor{}
+		s := NewService(&fakeStore{}, &fakeTx{}, auditor, nil, nil, nil, logging.Nop())
+
+		err := s.DeleteComment(context.Background(), "42")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.False(t, auditor.inTx, "nothing should be recorded")
+	})
+
+	t.Run("works without a transactor", func(t *testing.T) {
+		store := &fakeStore{}
+		s := NewService(store, nil, nil, nil, nil, nil, logging.Nop())
+
+		_, err := s.UpdateComment(context.Background(), "42", Comment{Body: "after"})
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{false, false}, store.inTx)
+	})
+
+	t.Run("domain events are written in the transaction", func(t *testing.T) {
+		events := &fakeEmitter{}
+		s := NewService(&fakeStore{}, &fakeTx{}, &fakeAuditor{}, events, nil, nil, logging.Nop())
+
+		_, err := s.PostComment(context.Background(), Comment{Body: "new"})
+		assert.NoError(t, err)
+		_, err = s.UpdateComment(context.Background(), "42", Comment{Body: "after"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{EventCommentCreated, EventCommentUpdated}, events.types)
+		assert.Equal(t, []bool{true, true}, events.inTx)
+	})
+
+	t.Run("no event for a failed change", func(t *testing.T) {
+		events := &fakeEmitter{}
+		s := NewService(&fakeStore{}, &fakeTx{}, &fakeAuditor{}, events, nil, nil, logging.Nop())
+
+		err := s.DeleteComment(context.Background(), "42")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Empty(t, events.types)
+	})
+
+	t.Run("notifies committed changes only", func(t *testing.T) {
+		notifier := &fakeNotifier{}
+		s := NewService(&fakeStore{}, &fakeTx{}, &fakeAuditor{}, nil, notifier, nil, logging.Nop())
+
+		_, err := s.UpdateComment(context.Background(), "42", Comment{Body: "after"})
+		assert.NoError(t, err)
+		require.Len(t, notifier.changes, 1)
+		assert.Equal(t, EventCommentUpdated, notifier.changes[0].Type)
+		assert.Equal(t, "after", notifier.changes[0].Comment.Body)
+		assert.Equal(t, "before", notifier.changes[0].Previous.Body)
+
+		s.Audit = &fakeAuditor{err: errors.New("audit_log is gone")}
+		_, err = s.UpdateComment(context.Background(), "42", Comment{Body: "rolled back"})
+		assert.Error(t, err)
+		assert.Len(t, notifier.changes, 1)
+	})
+
+	t.Run("transactional notifiers are told inside the transaction", func(t *testing.T) {
+		notifier := &fakeTxNotifier{}
+		s := NewService(&fakeStore{}, &fakeTx{}, &fakeAuditor{}, nil, notifier, nil, logging.Nop())
+
+		_, err := s.PostComment(context.Background(), Comment{Body: "new"})
+		assert.NoError(t, err)
+		require.Len(t, notifier.changes, 1, "and not again after the commit")
+		assert.Equal(t, EventCommentCreated, notifier.changes[0].Type)
+		assert.Equal(t, []bool{true}, notifier.inTx)
+
+		notifier.err = errors.New("pg_notify failed")
+		_, err = s.UpdateComment(context.Background(), "42", Comment{Body: "after"})
+		assert.NoError(t, err, "live clients miss out, the change stands")
+	})
+
+	t.Run("counts committed changes only", func(t *testing.T) {
+		counter := &fakeCounter{}
+		s := NewService(&fakeStore{}, &fakeTx{}, &fakeAuditor{}, nil, nil, counter, logging.Nop())
+
+		_, err := s.PostComment(context.Background(), Comment{Body: "new"})
+		assert.NoError(t, err)
+
+		s.Audit = &fakeAuditor{err: errors.New("audit_log is gone")}
+		_, err = s.UpdateComment(context.Background(), "42", Comment{Body: "rolled back"})
+		assert.Error(t, err)
+
+		assert.Equal(t, []string{EventCommentCreated}, counter.counted)
+	})
+}
+
+func TestListCommentsBy(t *testing.T) {
+	store := &fakeStore{}
+	s := NewService(store, &fakeTx{}, &fakeAuditor{}, nil, nil, nil, logging.Nop())
+	ctx := context.Background()
+
+	_, err := s.ListCommentsBy(ctx, "body; DROP TABLE comments", []string{"go"}, Page{})
+	assert.Error(t, err, "only known columns reach the store")
+	assert.Empty(t, store.pages)
+
+	for _, limit := range []int{0, -1, MaxPageSize + 1} {
+		_, err := s.ListCommentsBy(ctx, BySlug, []string{"go"}, Page{Limit: limit})
+		require.NoError(t, err)
+	}
+	_, err = s.ListCommentsBy(ctx, ByAuthor, []string{"ann"}, Page{After: "42", Limit: 5})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Page{
+		{Limit: MaxPageSize}, {Limit: MaxPageSize}, {Limit: MaxPageSize},
+		{After: "42", Limit: 5},
+	}, store.pages)
+}
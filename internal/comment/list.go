@@ -0,0 +1,105 @@
+package comment
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Field - a column comments are grouped by
+type Field string
+
+const (
+	BySlug   Field = "slug"
+	ByAuthor Field = "author"
+)
+
+// MaxPageSize - the most comments one Page holds, per group for ListCommentsBy
+const MaxPageSize = 100
+
+// Page - up to Limit comments after the one with id After, in id order.
+// Comments have no timestamps, their ids are the only stable order.
+type Page struct {
+	After string
+	Limit int
+}
+
+// clamp - a Limit outside 1..MaxPageSize becomes MaxPageSize
+func (p Page) clamp() Page {
+	if p.Limit < 1 || p.Limit > MaxPageSize {
+		p.Limit = MaxPageSize
+	}
+	return p
+}
+
+// ListComments - a Page of all comments
+func (s *Service) ListComments(ctx context.Context, page Page) (_ []Comment, err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.ListComments")
+	defer func() { endSpan(span, err) }()
+
+	cmts, err := s.Store.ListComments(ctx, page.clamp())
+	if err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to list comments")
+		return nil, err
+	}
+	return cmts, nil
+}
+
+// ListCommentsBy - a Page of comments for each of values, so many
+// threads or authors are loaded with one query instead of one each
+func (s *Service) ListCommentsBy(
+	ctx context.Context,
+	field Field,
+	values []string,
+	page Page,
+) (_ map[string][]Comment, err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.ListCommentsBy",
+		trace.WithAttributes(attribute.String("comment.field", string(field)), attribute.Int("comment.values", len(values))))
+	defer func() { endSpan(span, err) }()
+
+	if err := field.validate(); err != nil {
+		return nil, err
+	}
+
+	cmts, err := s.Store.ListCommentsBy(ctx, field, values, page.clamp())
+	if err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to list comments by "+string(field))
+		return nil, err
+	}
+	return cmts, nil
+}
+
+// CountCommentsBy - how many comments each of values has, values
+// without any are left out
+func (s *Service) CountCommentsBy(
+	ctx context.Context,
+	field Field,
+	values []string,
+) (_ map[string]int, err error) {
+	ctx, span := tracer.Start(ctx, "comment.Service.CountCommentsBy",
+		trace.WithAttributes(attribute.String("comment.field", string(field)), attribute.Int("comment.values", len(values))))
+	defer func() { endSpan(span, err) }()
+
+	if err := field.validate(); err != nil {
+		return nil, err
+	}
+
+	counts, err := s.Store.CountCommentsBy(ctx, field, values)
+	if err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to count comments by "+string(field))
+		return nil, err
+	}
+	return counts, nil
+}
+
+// validate - the store puts the field into its queries
+func (f Field) validate() error {
+	switch f {
+	case BySlug, ByAuthor:
+		return nil
+	default:
+		return fmt.Errorf("comments can't be grouped by %q", string(f))
+	}
+}
@@ -0,0 +1,422 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the environments a server can run in
+const (
+	EnvProduction  = "production"
+	EnvDevelopment = "development"
+)
+
+// MinJWTSecretLength - HS256 wants a key at least as long as its output
+const MinJWTSecretLength = 32
+
+// Config - every setting the server reads, grouped by component.
+//
+// Values are resolved in this order, later ones win:
+// defaults, the config file, environment variables, command line flags.
+// Each field can be set with the flag named after its file key, e.g.
+// -database.host, and with the variable(s) in its env tag.
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Database  Database  `yaml:"database" toml:"database"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Outbox    Outbox    `yaml:"outbox" toml:"outbox"`
+	Webhooks  Webhooks  `yaml:"webhooks" toml:"webhooks"`
+	Stream    Stream    `yaml:"stream" toml:"stream"`
+	GRPC      GRPC      `yaml:"grpc" toml:"grpc"`
+
+	// Environment - development turns on checks too costly for production
+	Environment string `yaml:"environment" toml:"environment" env:"APP_ENV" usage:"production or development"`
+}
+
+type Server struct {
+	Addr string `yaml:"addr" toml:"addr" env:"HTTP_ADDR" usage:"address the API listens on"`
+	// AdminAddr - empty serves /metrics next to the API
+	AdminAddr           string        `yaml:"admin_addr" toml:"admin_addr" env:"ADMIN_ADDR" usage:"separate address for /metrics"`
+	DrainDelay          time.Duration `yaml:"drain_delay" toml:"drain_delay" env:"DRAIN_DELAY" usage:"how long /readyz fails before shutting down"`
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long in-flight requests get to finish"`
+	AccessLogSampleRate float64       `yaml:"access_log_sample_rate" toml:"access_log_sample_rate" env:"ACCESS_LOG_SAMPLE_RATE" usage:"share of successful requests that are logged"`
+	// ReadTimeout, WriteTimeout - per request, 0 disables them.
+	// Comment streams lift them for their own connection.
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	// ValidateRequests - rejects requests that don't match /openapi.json, off
+	// by default. Outside production responses are checked either way.
+	ValidateRequests bool `yaml:"validate_requests" toml:"validate_requests" env:"HTTP_VALIDATE_REQUESTS" usage:"check requests against the OpenAPI document"`
+}
+
+type Database struct {
+	Host     string `yaml:"host" toml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" toml:"user" env:"DB_USERNAME"`
+	Password string `yaml:"password" toml:"password" env:"DB_PASSWORD" secret:"true"`
+	// Name - DB_TABLE is still read for older deployments
+	Name    string `yaml:"name" toml:"name" env:"DB_NAME,DB_TABLE"`
+	SSLMode string `yaml:"ssl_mode" toml:"ssl_mode" env:"DB_SSL_MODE,SSL_MODE"`
+	// MigrationsSource - empty uses the migrations embedded in the binary
+	MigrationsSource string `yaml:"migrations_source" toml:"migrations_source" env:"DB_MIGRATIONS_SOURCE" usage:"golang-migrate source url, empty for the embedded migrations"`
+	// AutoMigrate - apply pending migrations on start, otherwise run `app migrate up`
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate" env:"DB_AUTO_MIGRATE" usage:"apply pending migrations on start"`
+
+	// ConnectTimeout - how long startup keeps retrying before giving up
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" toml:"connect_timeout" env:"DB_CONNECT_TIMEOUT" usage:"how long to retry the first connection"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+
+	Breaker Breaker `yaml:"breaker" toml:"breaker"`
+}
+
+// Breaker - the circuit breaker in front of the comment store
+type Breaker struct {
+	// FailureThreshold - consecutive connection failures that open the circuit
+	FailureThreshold int `yaml:"failure_threshold" toml:"failure_threshold" env:"DB_BREAKER_FAILURE_THRESHOLD"`
+	// OpenTimeout - how long to fail fast before letting a probe through
+	OpenTimeout time.Duration `yaml:"open_timeout" toml:"open_timeout" env:"DB_BREAKER_OPEN_TIMEOUT"`
+}
+
+// DSN - the lib/pq connection string, values are quoted so passwords
+// with spaces or quotes survive
+func (d Database) DSN() string {
+	return strings.Join([]string{
+		"host=" + quoteDSN(d.Host),
+		"port=" + strconv.Itoa(d.Port),
+		"user=" + quoteDSN(d.User),
+		"dbname=" + quoteDSN(d.Name),
+		"password=" + quoteDSN(d.Password),
+		"sslmode=" + quoteDSN(d.SSLMode),
+	}, " ")
+}
+
+func quoteDSN(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+type Auth struct {
+	JWTSecret string `yaml:"jwt_secret" toml:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"HS256 signing key for access tokens"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT" usage:"json or text"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" usage:"none, otlp, stdout or file"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"TRACING_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACING_INSECURE"`
+	File        string  `yaml:"file" toml:"file" env:"TRACING_FILE"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+type RateLimit struct {
+	Backend string `yaml:"backend" toml:"backend" env:"RATE_LIMIT_BACKEND" usage:"memory or postgres"`
+}
+
+// Outbox - where domain events about comments are relayed to
+type Outbox struct {
+	// Publisher - none stops writing events altogether
+	Publisher    string        `yaml:"publisher" toml:"publisher" env:"OUTBOX_PUBLISHER" usage:"none, log, webhook or nats"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"OUTBOX_POLL_INTERVAL" usage:"pause between polls of an empty outbox"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size" env:"OUTBOX_BATCH_SIZE"`
+	// Retention - how long published events are kept, 0 keeps them forever
+	Retention      time.Duration `yaml:"retention" toml:"retention" env:"OUTBOX_RETENTION" usage:"how long published events are kept"`
+	PublishTimeout time.Duration `yaml:"publish_timeout" toml:"publish_timeout" env:"OUTBOX_PUBLISH_TIMEOUT"`
+	WebhookURL     string        `yaml:"webhook_url" toml:"webhook_url" env:"OUTBOX_WEBHOOK_URL"`
+	NATSURL        string        `yaml:"nats_url" toml:"nats_url" env:"OUTBOX_NATS_URL"`
+	// NATSSubject - events go to <subject>.<event type>
+	NATSSubject string `yaml:"nats_subject" toml:"nats_subject" env:"OUTBOX_NATS_SUBJECT" usage:"subject prefix, a jetstream stream must cover <prefix>.>"`
+}
+
+// Webhooks - delivery of events to the URLs registered by admins,
+// fed by the outbox so it needs a publisher other than none
+type Webhooks struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"WEBHOOKS_ENABLED" usage:"deliver events to registered webhooks"`
+	// MaxAttempts - failed attempts before a delivery is dead
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS" usage:"failed attempts before a delivery is dead"`
+	MinBackoff   time.Duration `yaml:"min_backoff" toml:"min_backoff" env:"WEBHOOKS_MIN_BACKOFF"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" toml:"max_backoff" env:"WEBHOOKS_MAX_BACKOFF"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" env:"WEBHOOKS_TIMEOUT" usage:"per delivery attempt"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"WEBHOOKS_POLL_INTERVAL"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size" env:"WEBHOOKS_BATCH_SIZE"`
+	// AllowPrivate - deliveries to loopback, link-local and private
+	// addresses are refused otherwise
+	AllowPrivate bool `yaml:"allow_private" toml:"allow_private" env:"WEBHOOKS_ALLOW_PRIVATE" usage:"deliver to loopback and private addresses, for development"`
+}
+
+// Stream - the live comment streams
+type Stream struct {
+	// Fanout - local only reaches clients of the replica that made the
+	// change, postgres reaches every replica through LISTEN/NOTIFY
+	Fanout string `yaml:"fanout" toml:"fanout" env:"STREAM_FANOUT" usage:"local or postgres"`
+	// Heartbeat - keeps idle streams from being closed by proxies
+	Heartbeat time.Duration `yaml:"heartbeat" toml:"heartbeat" env:"STREAM_HEARTBEAT" usage:"how often idle streams send a keep-alive"`
+	// ReplayBuffer - events kept so reconnecting clients can resume
+	ReplayBuffer int `yaml:"replay_buffer" toml:"replay_buffer" env:"STREAM_REPLAY_BUFFER" usage:"events kept for Last-Event-ID resume"`
+	// MaxSubscriptions - slugs one websocket may follow at a time
+	MaxSubscriptions int `yaml:"max_subscriptions" toml:"max_subscriptions" env:"STREAM_MAX_SUBSCRIPTIONS" usage:"slugs one websocket may follow"`
+}
+
+// GRPC - the gRPC API for internal services
+type GRPC struct {
+	// Addr - empty disables the gRPC server
+	Addr string `yaml:"addr" toml:"addr" env:"GRPC_ADDR" usage:"address the gRPC API listens on, empty disables it"`
+}
+
+// Default - the values used when nothing else sets them.
+// There is deliberately no default JWT secret.
+func Default() Config {
+	return Config{
+		Environment: EnvProduction,
+		Server: Server{
+			Addr:                ":8080",
+			DrainDelay:          5 * time.Second,
+			ShutdownTimeout:     15 * time.Second,
+			AccessLogSampleRate: 1,
+			ReadTimeout:         15 * time.Second,
+			WriteTimeout:        30 * time.Second,
+		},
+		Database: Database{
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			Name:            "postgres",
+			SSLMode:         "disable",
+			AutoMigrate:     true,
+			ConnectTimeout:  30 * time.Second,
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			Breaker: Breaker{
+				FailureThreshold: 5,
+				OpenTimeout:      10 * time.Second,
+			},
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+		RateLimit: RateLimit{
+			Backend: "memory",
+		},
+		Outbox: Outbox{
+			Publisher:      "log",
+			PollInterval:   time.Second,
+			BatchSize:      100,
+			Retention:      7 * 24 * time.Hour,
+			PublishTimeout: 10 * time.Second,
+			NATSSubject:    "comments",
+		},
+		Webhooks: Webhooks{
+			Enabled:      true,
+			MaxAttempts:  8,
+			MinBackoff:   10 * time.Second,
+			MaxBackoff:   time.Hour,
+			Timeout:      10 * time.Second,
+			PollInterval: time.Second,
+			BatchSize:    50,
+		},
+		Stream: Stream{
+			Fanout:           "local",
+			Heartbeat:        15 * time.Second,
+			ReplayBuffer:     1024,
+			MaxSubscriptions: 20,
+		},
+		GRPC: GRPC{
+			Addr: ":50051",
+		},
+	}
+}
+
+// ValidationError - every problem found, so they can be fixed in one go
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate - checks the resolved config, returns a *ValidationError
+func (c Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !oneOf(c.Environment, EnvProduction, EnvDevelopment) {
+		add("environment %q must be production or development", c.Environment)
+	}
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		add("server.addr %q is not a host:port address", c.Server.Addr)
+	}
+	if c.GRPC.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC.Addr); err != nil {
+			add("grpc.addr %q is not a host:port address", c.GRPC.Addr)
+		}
+	}
+	if c.Server.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Server.AdminAddr); err != nil {
+			add("server.admin_addr %q is not a host:port address", c.Server.AdminAddr)
+		}
+	}
+	if c.Server.DrainDelay < 0 {
+		add("server.drain_delay must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout must be positive")
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 {
+		add("server.read_timeout and write_timeout must not be negative")
+	}
+	if c.Server.AccessLogSampleRate <= 0 || c.Server.AccessLogSampleRate > 1 {
+		add("server.access_log_sample_rate must be in (0, 1], got %v", c.Server.AccessLogSampleRate)
+	}
+
+	if c.Database.Host == "" {
+		add("database.host is required")
+	}
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		add("database.port must be between 1 and 65535, got %d", c.Database.Port)
+	}
+	if c.Database.User == "" {
+		add("database.user is required")
+	}
+	if c.Database.Name == "" {
+		add("database.name is required")
+	}
+	if !oneOf(c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full") {
+		add("database.ssl_mode %q is not a valid sslmode", c.Database.SSLMode)
+	}
+	if c.Database.ConnectTimeout < 0 {
+		add("database.connect_timeout must not be negative")
+	}
+	if c.Database.MaxOpenConns < 1 {
+		add("database.max_open_conns must be at least 1, got %d", c.Database.MaxOpenConns)
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		add("database.max_idle_conns must be between 0 and max_open_conns, got %d", c.Database.MaxIdleConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 || c.Database.ConnMaxIdleTime < 0 {
+		add("database.conn_max_lifetime and conn_max_idle_time must not be negative")
+	}
+	if c.Database.Breaker.FailureThreshold < 1 {
+		add("database.breaker.failure_threshold must be at least 1, got %d", c.Database.Breaker.FailureThreshold)
+	}
+	if c.Database.Breaker.OpenTimeout <= 0 {
+		add("database.breaker.open_timeout must be positive")
+	}
+
+	if c.Auth.JWTSecret == "" {
+		add("auth.jwt_secret is required (set JWT_SECRET)")
+	} else if len(c.Auth.JWTSecret) < MinJWTSecretLength {
+		add("auth.jwt_secret must be at least %d bytes, got %d", MinJWTSecretLength, len(c.Auth.JWTSecret))
+	}
+
+	if !oneOf(c.Log.Level, "debug", "info", "warn", "error") {
+		add("log.level %q must be debug, info, warn or error", c.Log.Level)
+	}
+	if !oneOf(c.Log.Format, "json", "text") {
+		add("log.format %q must be json or text", c.Log.Format)
+	}
+
+	if !oneOf(c.Tracing.Exporter, "none", "otlp", "stdout", "file") {
+		add("tracing.exporter %q must be none, otlp, stdout or file", c.Tracing.Exporter)
+	}
+	if c.Tracing.Exporter == "file" && c.Tracing.File == "" {
+		add("tracing.file is required with the file exporter")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+
+	if !oneOf(c.RateLimit.Backend, "memory", "postgres") {
+		add("rate_limit.backend %q must be memory or postgres", c.RateLimit.Backend)
+	}
+
+	if !oneOf(c.Outbox.Publisher, "none", "log", "webhook", "nats") {
+		add("outbox.publisher %q must be none, log, webhook or nats", c.Outbox.Publisher)
+	}
+	if c.Outbox.PollInterval <= 0 || c.Outbox.PublishTimeout <= 0 {
+		add("outbox.poll_interval and publish_timeout must be positive")
+	}
+	if c.Outbox.BatchSize < 1 {
+		add("outbox.batch_size must be at least 1, got %d", c.Outbox.BatchSize)
+	}
+	if c.Outbox.Retention < 0 {
+		add("outbox.retention must not be negative")
+	}
+	if c.Outbox.Publisher == "webhook" {
+		if u, err := url.Parse(c.Outbox.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("outbox.webhook_url %q must be an http or https url", c.Outbox.WebhookURL)
+		}
+	}
+	if c.Outbox.Publisher == "nats" && (c.Outbox.NATSURL == "" || c.Outbox.NATSSubject == "") {
+		add("outbox.nats_url and nats_subject are required with the nats publisher")
+	}
+
+	if c.Webhooks.Enabled {
+		if c.Outbox.Publisher == "none" {
+			add("webhooks need events, set outbox.publisher or disable webhooks")
+		}
+		if c.Webhooks.MaxAttempts < 1 {
+			add("webhooks.max_attempts must be at least 1, got %d", c.Webhooks.MaxAttempts)
+		}
+		if c.Webhooks.MinBackoff <= 0 || c.Webhooks.MaxBackoff < c.Webhooks.MinBackoff {
+			add("webhooks.min_backoff must be positive and at most max_backoff")
+		}
+		if c.Webhooks.Timeout <= 0 || c.Webhooks.PollInterval <= 0 {
+			add("webhooks.timeout and poll_interval must be positive")
+		}
+		if c.Webhooks.BatchSize < 1 {
+			add("webhooks.batch_size must be at least 1, got %d", c.Webhooks.BatchSize)
+		}
+	}
+
+	switch c.Stream.Fanout {
+	case "local", "postgres":
+	default:
+		add("stream.fanout must be local or postgres, got %q", c.Stream.Fanout)
+	}
+	if c.Stream.Heartbeat <= 0 {
+		add("stream.heartbeat must be positive")
+	}
+	if c.Stream.ReplayBuffer < 1 {
+		add("stream.replay_buffer must be at least 1, got %d", c.Stream.ReplayBuffer)
+	}
+	if c.Stream.MaxSubscriptions < 1 {
+		add("stream.max_subscriptions must be at least 1, got %d", c.Stream.MaxSubscriptions)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func env(vars map[string]string) LookupFunc {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+server:
+  addr: ":7000"
+  drain_delay: 1s
+database:
+  host: file-host
+  name: file-db
+auth:
+  jwt_secret: `+testSecret+`
+`)
+
+	cfg, err := Load(
+		[]string{"-config", path, "-database.host", "flag-host"},
+		env(map[string]string{"DB_HOST": "env-host", "DB_NAME": "env-db"}),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":7000", cfg.Server.Addr, "file overrides defaults")
+	assert.Equal(t, time.Second, cfg.Server.DrainDelay)
+	assert.Equal(t, "env-db", cfg.Database.Name, "env overrides file")
+	assert.Equal(t, "flag-host", cfg.Database.Host, "flags override env")
+	assert.Equal(t, 5432, cfg.Database.Port, "defaults fill the rest")
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+[server]
+addr = ":7001"
+shutdown_timeout = "30s"
+
+[auth]
+jwt_secret = "`+testSecret+`"
+`)
+
+	cfg, err := Load(nil, env(map[string]string{FileEnv: path}))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":7001", cfg.Server.Addr)
+	assert.Equal(t, 30*time.Second, cfg.Server.ShutdownTimeout)
+}
+
+func TestLoadUnknownFileKey(t *testing.T) {
+	path := writeFile(t, "config.yaml", "database:\n  hots: typo\n")
+
+	_, err := Load([]string{"-config", path}, env(nil))
+	assert.Error(t, err)
+}
+
+func TestLegacyEnvNames(t *testing.T) {
+	cfg, err := Load(nil, env(map[string]string{
+		"JWT_SECRET": testSecret,
+		"DB_TABLE":   "legacy",
+		"SSL_MODE":   "require",
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "legacy", cfg.Database.Name)
+	assert.Equal(t, "require", cfg.Database.SSLMode)
+}
+
+func TestValidate(t *testing.T) {
+	_, err := Load(
+		[]string{"-database.port", "0", "-log.level", "loud", "-outbox.publisher", "webhook"},
+		env(map[string]string{"JWT_SECRET": "short"}),
+	)
+
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	assert.Len(t, verr.Problems, 4)
+	assert.Contains(t, err.Error(), "database.port")
+	assert.Contains(t, err.Error(), "log.level")
+	assert.Contains(t, err.Error(), "auth.jwt_secret")
+	assert.Contains(t, err.Error(), "outbox.webhook_url")
+}
+
+func TestInvalidValue(t *testing.T) {
+	_, err := Load(nil, env(map[string]string{"DB_PORT": "five"}))
+	assert.ErrorContains(t, err, "DB_PORT")
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.JWTSecret = testSecret
+	cfg.Database.Password = "hunter2"
+
+	out, err := cfg.Redacted().YAML()
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(out), testSecret)
+	assert.NotContains(t, string(out), "hunter2")
+	assert.Contains(t, string(out), "[REDACTED]")
+	// the original is untouched
+	assert.Equal(t, testSecret, cfg.Auth.JWTSecret)
+}
+
+func TestDSNQuoting(t *testing.T) {
+	d := Default().Database
+	d.Password = `it's a \ secret`
+
+	assert.Contains(t, d.DSN(), `password='it\'s a \\ secret'`)
+}
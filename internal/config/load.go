@@ -0,0 +1,229 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// LookupFunc - how environment variables are read, os.LookupEnv in main
+type LookupFunc func(key string) (string, bool)
+
+// FileEnv - names the config file when -config is not given
+const FileEnv = "CONFIG_FILE"
+
+// Load - resolves the config from defaults, the file named by -config
+// or CONFIG_FILE, the environment and the flags in args, then validates it
+func Load(args []string, lookup LookupFunc) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	file := fs.String("config", "", "path to a YAML or TOML config file")
+
+	// flags are only collected here, they are applied after the file and
+	// the environment so that they always win
+	set := map[string]string{}
+	for _, f := range fields(&cfg) {
+		key := f.key
+		fs.Func(key, f.usage, func(v string) error {
+			set[key] = v
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		var usage bytes.Buffer
+		fs.SetOutput(&usage)
+		fs.PrintDefaults()
+		return Config{}, fmt.Errorf("%w\n\nflags:\n%s", err, usage.String())
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	path := *file
+	if path == "" {
+		path, _ = lookup(FileEnv)
+	}
+	if path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := cfg.ApplyEnv(lookup); err != nil {
+		return Config{}, err
+	}
+
+	for _, f := range fields(&cfg) {
+		if v, ok := set[f.key]; ok {
+			if err := setValue(f.value, v); err != nil {
+				return Config{}, fmt.Errorf("flag -%s: %w", f.key, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// LoadFile - overlays a YAML (.yaml, .yml) or TOML (.toml) file,
+// keys missing from the file keep their current value
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read the config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("could not parse %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("could not parse %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("could not parse %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+
+	return nil
+}
+
+// ApplyEnv - overlays every variable named in an env tag that is set,
+// for tags listing several names the first one set wins
+func (c *Config) ApplyEnv(lookup LookupFunc) error {
+	for _, f := range fields(c) {
+		for _, name := range f.env {
+			v, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if err := setValue(f.value, v); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Redacted - a copy with every secret field masked, safe to print or log
+func (c Config) Redacted() Config {
+	for _, f := range fields(&c) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString("[REDACTED]")
+		}
+	}
+	return c
+}
+
+// YAML - the config as a YAML document, secrets are NOT masked
+func (c Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// field - a leaf setting, key is its dotted file path e.g. database.host
+type field struct {
+	key    string
+	env    []string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// fields - every leaf of the config, in declaration order
+func fields(c *Config) []field {
+	var out []field
+
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			key := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key)
+				continue
+			}
+
+			var env []string
+			if tag := sf.Tag.Get("env"); tag != "" {
+				env = strings.Split(tag, ",")
+			}
+			out = append(out, field{
+				key:    key,
+				env:    env,
+				usage:  sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+
+	walk(reflect.ValueOf(c).Elem(), "")
+	return out
+}
+
+// setValue - parses raw into the field according to its type
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
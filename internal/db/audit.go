@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+)
+
+// auditChainLock - advisory lock key serializing appends to the chain
+const auditChainLock = 7_301_028
+
+// AuditRow - a row in the audit_log table
+type AuditRow struct {
+	ID         int64     `db:"id"`
+	OccurredAt time.Time `db:"occurred_at"`
+	Actor      string    `db:"actor"`
+	RemoteAddr string    `db:"remote_addr"`
+	RequestID  string    `db:"request_id"`
+	Action     string    `db:"action"`
+	Resource   string    `db:"resource"`
+	ResourceID string    `db:"resource_id"`
+	Before     []byte    `db:"before"`
+	After      []byte    `db:"after"`
+	Diff       []byte    `db:"diff"`
+	PrevHash   string    `db:"prev_hash"`
+	Hash       string    `db:"hash"`
+}
+
+func convertAuditRowToEntry(r AuditRow) audit.Entry {
+	return audit.Entry{
+		ID:         r.ID,
+		OccurredAt: r.OccurredAt.UTC(),
+		Actor:      r.Actor,
+		RemoteAddr: r.RemoteAddr,
+		RequestID:  r.RequestID,
+		Action:     r.Action,
+		Resource:   r.Resource,
+		ResourceID: r.ResourceID,
+		Before:     r.Before,
+		After:      r.After,
+		Diff:       r.Diff,
+		PrevHash:   r.PrevHash,
+		Hash:       r.Hash,
+	}
+}
+
+// nullableJSON - empty states are stored as NULL rather than an empty string
+func nullableJSON(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+// AppendAuditEntry - links the entry to the current head of the chain
+// and inserts it. The advisory lock makes concurrent appends line up,
+// otherwise two entries could end up pointing at the same predecessor.
+// Inside a WithTx the entry commits or rolls back with the change it records.
+func (d *Database) AppendAuditEntry(ctx context.Context, e audit.Entry) (audit.Entry, error) {
+	err := d.WithTx(ctx, func(ctx context.Context) error {
+		q := d.q(ctx)
+
+		if _, err := q.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+			return fmt.Errorf("error locking audit chain: %w", err)
+		}
+
+		var prevHash []string
+		if err := q.SelectContext(ctx, &prevHash,
+			`SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`,
+		); err != nil {
+			return fmt.Errorf("error fetching audit chain head: %w", err)
+		}
+
+		e.PrevHash = ""
+		if len(prevHash) == 1 {
+			e.PrevHash = prevHash[0]
+		}
+		e.Hash = e.ComputeHash()
+
+		err := q.GetContext(ctx, &e.ID,
+			`INSERT INTO audit_log
+			 (occurred_at, actor, remote_addr, request_id, action, resource,
+			  resource_id, before, after, diff, prev_hash, hash)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			 RETURNING id`,
+			e.OccurredAt, e.Actor, e.RemoteAddr, e.RequestID, e.Action, e.Resource,
+			e.ResourceID, nullableJSON(e.Before), nullableJSON(e.After), nullableJSON(e.Diff),
+			e.PrevHash, e.Hash,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting audit entry: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return audit.Entry{}, err
+	}
+
+	return e, nil
+}
+
+func (d *Database) QueryAuditLog(ctx context.Context, f audit.Filter) ([]audit.Entry, error) {
+	var (
+		where []string
+		args  []any
+	)
+
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.ResourceID != "" {
+		add("resource_id = $%d", f.ResourceID)
+	}
+	if !f.From.IsZero() {
+		add("occurred_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("occurred_at < $%d", f.To)
+	}
+	if f.AfterID > 0 {
+		add("id > $%d", f.AfterID)
+	}
+
+	query := `SELECT id, occurred_at, actor, remote_addr, request_id, action, resource,
+		 resource_id, before, after, diff, prev_hash, hash
+		 FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id ASC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	var rows []AuditRow
+	if err := d.q(ctx).SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("error querying audit log: %w", err)
+	}
+
+	entries := make([]audit.Entry, 0, len(rows))
+	for _, r := range rows {
+		entries = append(entries, convertAuditRowToEntry(r))
+	}
+
+	return entries, nil
+}
@@ -3,67 +3,62 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
-	uuid "github.com/satori/go.uuid"
 )
 
 // CommentRow is a struct that represents a row in the comments table
 // it is used to scan the rows from the database
 // it is also used to insert rows into the database
+// every column is NOT NULL since migration 0005
 type CommentRow struct {
-	ID     string         `db:"id"`
-	Slug   sql.NullString `db:"slug"`
-	Body   sql.NullString `db:"body"`
-	Author sql.NullString `db:"author"`
+	ID     string `db:"id"`
+	Slug   string `db:"slug"`
+	Body   string `db:"body"`
+	Author string `db:"author"`
 }
 
+// commentColumns - always listed explicitly and scanned by name,
+// so adding a column to the table can't shift the others around
+const commentColumns = `id, slug, author, body`
+
 // ? private function as it start with small letter
 func convertCommentRowToComment(c CommentRow) comment.Comment {
 	return comment.Comment{
 		ID:     c.ID,
-		Slug:   c.Slug.String,
-		Body:   c.Body.String,
-		Author: c.Author.String,
+		Slug:   c.Slug,
+		Body:   c.Body,
+		Author: c.Author,
 	}
 }
 
+// isMissing - no row, or an id that can't be a uuid and so can't match one
+func isMissing(err error) bool {
+	var pqErr *pq.Error
+	return errors.Is(err, sql.ErrNoRows) ||
+		(errors.As(err, &pqErr) && pqErr.Code == "22P02")
+}
+
 // Get multiple comments
 // The method in service layer is calling this method
 // so, that method also a reciver of the Service -> [db.Client] struct
 func (d *Database) GetMultipleComment(ctx context.Context) ([]comment.Comment, error) {
 
 	var cmtRows []CommentRow
-	rows, err := d.Client.QueryContext(ctx,
-		`SELECT * FROM comments`,
+	err := d.q(ctx).SelectContext(ctx, &cmtRows,
+		`SELECT `+commentColumns+` FROM comments`,
 	)
 	if err != nil {
+		d.logQueryError(ctx, "error fetching multiple comments", err)
 		return []comment.Comment{},
 			fmt.Errorf("error fetching multiple comments: %w", err)
 	}
 
-	for rows.Next() {
-		var cmtRow CommentRow
-
-		err := rows.Scan(
-			&cmtRow.ID,
-			&cmtRow.Slug,
-			&cmtRow.Body,
-			&cmtRow.Author,
-		)
-		if err != nil {
-			return []comment.Comment{},
-				fmt.Errorf("error scanning multiple comments: %w", err)
-		}
-
-		cmtRows = append(cmtRows, cmtRow)
-	}
-
-	// these extra steps are taken to convert the CommentRow to Comment
-	// since it is a list of comments but converCommentRowToComment takes a single CommentRow
-	// we have to loop through the list of CommentRow and convert each to a Comment
-	var comments []comment.Comment
+	comments := make([]comment.Comment, 0, len(cmtRows))
 	for _, cmtRow := range cmtRows {
 		comments = append(comments, convertCommentRowToComment(cmtRow))
 	}
@@ -74,102 +69,245 @@ func (d *Database) GetMultipleComment(ctx context.Context) ([]comment.Comment, e
 func (d *Database) GetComment(ctx context.Context, uuid string) (comment.Comment, error) {
 	var cmtRow CommentRow
 
-	row := d.Client.QueryRowContext(ctx,
-		`SELECT * FROM comments
+	err := d.q(ctx).GetContext(ctx, &cmtRow,
+		`SELECT `+commentColumns+` FROM comments
 		 WHERE id = $1`,
 		uuid,
 	)
+	if isMissing(err) {
+		return comment.Comment{}, comment.ErrNotFound
+	}
+	if err != nil {
+		d.logQueryError(ctx, "error featching comment by uuid", err)
+		return comment.Comment{},
+			fmt.Errorf("error featching comment by uuid: %w", err)
+	}
+
+	return convertCommentRowToComment(cmtRow), nil
+}
+
+// GetCommentForUpdate - locks the row until the transaction in ctx ends,
+// outside of one the lock is gone as soon as the row is read
+func (d *Database) GetCommentForUpdate(ctx context.Context, uuid string) (comment.Comment, error) {
+	var cmtRow CommentRow
 
-	err := row.Scan(
-		&cmtRow.ID,
-		&cmtRow.Slug,
-		&cmtRow.Body,
-		&cmtRow.Author,
+	err := d.q(ctx).GetContext(ctx, &cmtRow,
+		`SELECT `+commentColumns+` FROM comments
+		 WHERE id = $1
+		 FOR UPDATE`,
+		uuid,
 	)
+	if isMissing(err) {
+		return comment.Comment{}, comment.ErrNotFound
+	}
 	if err != nil {
+		d.logQueryError(ctx, "error locking comment by uuid", err)
 		return comment.Comment{},
-			fmt.Errorf("error featching comment by uuid: %w", err)
+			fmt.Errorf("error locking comment by uuid: %w", err)
 	}
 
 	return convertCommentRowToComment(cmtRow), nil
 }
 
+// PostComment - the id comes from the column default
 func (d *Database) PostComment(
 	ctx context.Context,
 	c comment.Comment,
 ) (comment.Comment, error) {
 
-	c.ID = uuid.NewV4().String()
-
-	postRow := CommentRow{
-		ID:     c.ID,
-		Slug:   sql.NullString{String: c.Slug, Valid: true},
-		Author: sql.NullString{String: c.Author, Valid: true},
-		Body:   sql.NullString{String: c.Body, Valid: true},
-	}
+	var cmtRow CommentRow
 
-	rows, err := d.Client.NamedQueryContext(ctx,
+	err := d.q(ctx).GetContext(ctx, &cmtRow,
 		`INSERT INTO comments
-		 (id, slug,  author, body)
-		 VALUES (:id, :slug, :author, :body)`,
-		postRow,
+		 (slug, author, body)
+		 VALUES ($1, $2, $3)
+		 RETURNING `+commentColumns,
+		c.Slug, c.Author, c.Body,
 	)
-
 	if err != nil {
+		d.logQueryError(ctx, "error creating comment", err)
 		return comment.Comment{}, fmt.Errorf("error creating comment: %w", err)
 	}
-	if err := rows.Close(); err != nil {
-		return comment.Comment{}, fmt.Errorf("error closing rows: %w", err)
-	}
 
-	return c, nil
+	return convertCommentRowToComment(cmtRow), nil
 }
 
-func (d *Database) DeleteComment(ctx context.Context, uuid string) error {
+// DeleteComment - reports whether a row was actually removed
+func (d *Database) DeleteComment(ctx context.Context, uuid string) (bool, error) {
 
-	_, err := d.Client.ExecContext(ctx,
+	res, err := d.q(ctx).ExecContext(ctx,
 		`DELETE FROM comments
 		 WHERE id = $1`,
 		uuid,
 	)
+	if isMissing(err) {
+		return false, nil
+	}
+	if err != nil {
+		d.logQueryError(ctx, "error deleting comment by uuid", err)
+		return false, fmt.Errorf("error deleting comment by uuid: %w", err)
+	}
 
+	n, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("error deleting comment by uuid: %w", err)
+		return false, fmt.Errorf("error deleting comment by uuid: %w", err)
 	}
 
-	return nil
+	return n > 0, nil
 }
 
+// UpdateComment - returns the row as stored, not the input
 func (d *Database) UpdateComment(
 	ctx context.Context,
 	id string,
 	c comment.Comment,
 ) (comment.Comment, error) {
 
-	updateRow := CommentRow{
-		ID:     id,
-		Slug:   sql.NullString{String: c.Slug, Valid: true},
-		Author: sql.NullString{String: c.Author, Valid: true},
-		Body:   sql.NullString{String: c.Body, Valid: true},
-	}
+	var cmtRow CommentRow
 
-	row, err := d.Client.NamedQueryContext(
-		ctx,
+	err := d.q(ctx).GetContext(ctx, &cmtRow,
 		`UPDATE comments SET
-		slug = :slug,
-		author = :author,
-		body = :body
-		WHERE id = :id`,
-		updateRow,
+		 slug = $2,
+		 author = $3,
+		 body = $4
+		 WHERE id = $1
+		 RETURNING `+commentColumns,
+		id, c.Slug, c.Author, c.Body,
 	)
-
+	if isMissing(err) {
+		return comment.Comment{}, comment.ErrNotFound
+	}
 	if err != nil {
+		d.logQueryError(ctx, "error updating comment", err)
 		return comment.Comment{}, fmt.Errorf("error updating comment: %w", err)
 	}
 
-	if err := row.Close(); err != nil {
-		return comment.Comment{}, fmt.Errorf("error closing rows: %w", err)
+	return convertCommentRowToComment(cmtRow), nil
+}
+
+// ListComments - keyset pagination on id, an After that can't be a
+// uuid matches nothing
+func (d *Database) ListComments(ctx context.Context, page comment.Page) ([]comment.Comment, error) {
+	var (
+		where []string
+		args  []any
+	)
+
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
 	}
 
-	return convertCommentRowToComment(updateRow), nil
+	if page.After != "" {
+		add("id > $%d", page.After)
+	}
+
+	query := `SELECT ` + commentColumns + ` FROM comments`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, page.Limit)
+	query += fmt.Sprintf(" ORDER BY id ASC LIMIT $%d", len(args))
+
+	var cmtRows []CommentRow
+	err := d.q(ctx).SelectContext(ctx, &cmtRows, query, args...)
+	if isMissing(err) {
+		return []comment.Comment{}, nil
+	}
+	if err != nil {
+		d.logQueryError(ctx, "error listing comments", err)
+		return nil, fmt.Errorf("error listing comments: %w", err)
+	}
+
+	comments := make([]comment.Comment, 0, len(cmtRows))
+	for _, row := range cmtRows {
+		comments = append(comments, convertCommentRowToComment(row))
+	}
+	return comments, nil
+}
+
+// ListCommentsBy - the first page.Limit comments of every value in one
+// query. field has been checked by the service, it is a column name.
+func (d *Database) ListCommentsBy(
+	ctx context.Context,
+	field comment.Field,
+	values []string,
+	page comment.Page,
+) (map[string][]comment.Comment, error) {
+	var (
+		where []string
+		args  []any
+	)
+
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	add(string(field)+" = ANY($%d)", pq.Array(values))
+	if page.After != "" {
+		add("id > $%d", page.After)
+	}
+	args = append(args, page.Limit)
+
+	query := fmt.Sprintf(
+		`SELECT `+commentColumns+` FROM (
+		   SELECT `+commentColumns+`, row_number() OVER (PARTITION BY %[1]s ORDER BY id) AS n
+		   FROM comments
+		   WHERE %[2]s
+		 ) c
+		 WHERE n <= $%[3]d
+		 ORDER BY %[1]s, id ASC`,
+		field, strings.Join(where, " AND "), len(args),
+	)
+
+	var cmtRows []CommentRow
+	err := d.q(ctx).SelectContext(ctx, &cmtRows, query, args...)
+	if isMissing(err) {
+		return map[string][]comment.Comment{}, nil
+	}
+	if err != nil {
+		d.logQueryError(ctx, "error listing comments by "+string(field), err)
+		return nil, fmt.Errorf("error listing comments by %s: %w", field, err)
+	}
+
+	comments := make(map[string][]comment.Comment, len(values))
+	for _, row := range cmtRows {
+		c := convertCommentRowToComment(row)
+		key := c.Slug
+		if field == comment.ByAuthor {
+			key = c.Author
+		}
+		comments[key] = append(comments[key], c)
+	}
+	return comments, nil
+}
+
+// CountCommentsBy - field has been checked by the service, it is a column name
+func (d *Database) CountCommentsBy(
+	ctx context.Context,
+	field comment.Field,
+	values []string,
+) (map[string]int, error) {
+	var rows []struct {
+		Value string `db:"value"`
+		Count int    `db:"count"`
+	}
+	err := d.q(ctx).SelectContext(ctx, &rows,
+		fmt.Sprintf(`SELECT %[1]s AS value, count(*) AS count
+		 FROM comments
+		 WHERE %[1]s = ANY($1)
+		 GROUP BY %[1]s`, field),
+		pq.Array(values),
+	)
+	if err != nil {
+		d.logQueryError(ctx, "error counting comments by "+string(field), err)
+		return nil, fmt.Errorf("error counting comments by %s: %w", field, err)
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Value] = row.Count
+	}
+	return counts, nil
 }
@@ -4,15 +4,20 @@ package db
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCommentDatabase(t *testing.T) {
 	t.Run("test create comment", func(t *testing.T) {
-		db, err := NewDatabase()
+		db, err := NewDatabase(testConfig(t), logging.Nop())
 		assert.NoError(t, err)
 
 		cmt, err := db.PostComment(context.Background(), comment.Comment{
@@ -28,7 +33,7 @@ func TestCommentDatabase(t *testing.T) {
 	})
 
 	t.Run("test delete comment", func(t *testing.T) {
-		db, err := NewDatabase()
+		db, err := NewDatabase(testConfig(t), logging.Nop())
 		assert.NoError(t, err)
 
 		cmt, err := db.PostComment(context.Background(), comment.Comment{
@@ -39,11 +44,107 @@ func TestCommentDatabase(t *testing.T) {
 		assert.NoError(t, err)
 
 		// delete comment test
-		err = db.DeleteComment(context.Background(), cmt.ID)
+		deleted, err := db.DeleteComment(context.Background(), cmt.ID)
 		assert.NoError(t, err)
+		assert.True(t, deleted)
 
 		// try to get the deleted comment
 		_, err = db.GetComment(context.Background(), cmt.ID)
+		assert.ErrorIs(t, err, comment.ErrNotFound)
+
+		// a second delete has nothing left to remove
+		deleted, err = db.DeleteComment(context.Background(), cmt.ID)
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("test update returns the stored row", func(t *testing.T) {
+		db, err := NewDatabase(testConfig(t), logging.Nop())
+		assert.NoError(t, err)
+
+		cmt, err := db.PostComment(context.Background(), comment.Comment{
+			Slug:   "update test",
+			Author: "updatetestuser",
+			Body:   "before",
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, cmt.ID)
+
+		updated, err := db.UpdateComment(context.Background(), cmt.ID, comment.Comment{
+			ID:     "ignored",
+			Slug:   "update test",
+			Author: "updatetestuser",
+			Body:   "after",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, cmt.ID, updated.ID)
+		assert.Equal(t, "after", updated.Body)
+		assert.Equal(t, "updatetestuser", updated.Author)
+
+		_, err = db.UpdateComment(context.Background(), "00000000-0000-0000-0000-000000000000", cmt)
+		assert.ErrorIs(t, err, comment.ErrNotFound)
+	})
+	t.Run("test the schema rejects invalid comments", func(t *testing.T) {
+		db, err := NewDatabase(testConfig(t), logging.Nop())
+		assert.NoError(t, err)
+
+		_, err = db.PostComment(context.Background(), comment.Comment{
+			Slug:   "",
+			Author: "schematestuser",
+			Body:   "empty slug",
+		})
 		assert.Error(t, err)
+
+		_, err = db.PostComment(context.Background(), comment.Comment{
+			Slug:   strings.Repeat("s", 256),
+			Author: "schematestuser",
+			Body:   "slug too long",
+		})
+		assert.Error(t, err)
+	})
+	t.Run("test listing and counting by slug", func(t *testing.T) {
+		db, err := NewDatabase(testConfig(t), logging.Nop())
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		// other tests leave comments behind, these slugs are ours alone
+		suffix := time.Now().Format("150405.000000")
+		goSlug, rustSlug := "list go "+suffix, "list rust "+suffix
+		var posted []comment.Comment
+		for _, slug := range []string{goSlug, goSlug, goSlug, rustSlug} {
+			c, err := db.PostComment(ctx, comment.Comment{Slug: slug, Author: "listuser", Body: "body"})
+			require.NoError(t, err)
+			posted = append(posted, c)
+		}
+		t.Cleanup(func() {
+			for _, c := range posted {
+				db.DeleteComment(ctx, c.ID)
+			}
+		})
+
+		byID := func(cmts []comment.Comment) []comment.Comment {
+			sort.Slice(cmts, func(i, j int) bool { return cmts[i].ID < cmts[j].ID })
+			return cmts
+		}
+		goComments := byID(append([]comment.Comment(nil), posted[:3]...))
+
+		lists, err := db.ListCommentsBy(ctx, comment.BySlug, []string{goSlug, rustSlug, "nothing here"}, comment.Page{Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, goComments[:2], lists[goSlug])
+		assert.Equal(t, posted[3:], lists[rustSlug])
+		assert.NotContains(t, lists, "nothing here")
+
+		lists, err = db.ListCommentsBy(ctx, comment.BySlug, []string{goSlug}, comment.Page{After: goComments[1].ID, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, goComments[2:], lists[goSlug])
+
+		counts, err := db.CountCommentsBy(ctx, comment.BySlug, []string{goSlug, rustSlug, "nothing here"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{goSlug: 3, rustSlug: 1}, counts)
+
+		// an after that can't be an id matches nothing
+		cmts, err := db.ListComments(ctx, comment.Page{After: "not a uuid", Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, cmts)
 	})
 }
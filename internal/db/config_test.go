@@ -0,0 +1,21 @@
+//go:build integration
+
+package db
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/config"
+)
+
+// testConfig - the database section from the defaults and the DB_* variables
+func testConfig(t *testing.T) config.Database {
+	t.Helper()
+
+	cfg := config.Default()
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Database
+}
@@ -2,41 +2,157 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"os"
+	"math/rand"
+	"net"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/config"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 type Database struct {
 	Client *sqlx.DB
+	Log    logging.Logger
+
+	// MigrationsSource - golang-migrate source url, e.g. file:///migrations
+	MigrationsSource string
+
+	// dsn - for connections outside the pool, see ListenCommentChanges
+	dsn string
 }
 
-func NewDatabase() (*Database, error) {
+func NewDatabase(cfg config.Database, logger logging.Logger) (*Database, error) {
 
-	connectionString := fmt.Sprintf(
+	connectionString := cfg.DSN()
 
-		"host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USERNAME"),
-		os.Getenv("DB_TABLE"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("SSL_MODE"),
+	// otelsql wraps the driver so every query gets its own span
+	sqlDB, err := otelsql.Open("postgres", connectionString,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true}),
 	)
-	dbConn, err := sqlx.Connect("postgres", connectionString)
-
 	if err != nil {
 		return &Database{},
 			fmt.Errorf("could not connect to the database: %w", err)
 	}
 
-	return &Database{
-		Client: dbConn,
-	}, nil
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	d := &Database{
+		Client:           sqlx.NewDb(sqlDB, "postgres"),
+		Log:              logger.WithFields(logging.Fields{"component": "db"}),
+		MigrationsSource: cfg.MigrationsSource,
+		dsn:              connectionString,
+	}
+
+	// docker-compose starts us alongside postgres, so the first
+	// connection is retried until ConnectTimeout runs out
+	if err := d.connect(cfg.ConnectTimeout); err != nil {
+		d.Client.Close()
+		return &Database{},
+			fmt.Errorf("could not connect to the database: %w", err)
+	}
+
+	return d, nil
+}
+
+const (
+	connectBaseDelay = 250 * time.Millisecond
+	connectMaxDelay  = 5 * time.Second
+	pingTimeout      = 5 * time.Second
+)
+
+// connect - pings with exponential backoff until it succeeds or timeout passes
+func (d *Database) connect(timeout time.Duration) error {
+	if timeout <= 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		defer cancel()
+		return d.Ping(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		pingCtx, cancelPing := context.WithTimeout(ctx, pingTimeout)
+		err := d.Ping(pingCtx)
+		cancelPing()
+		if err == nil {
+			return nil
+		}
+
+		delay := backoff(attempt, connectBaseDelay, connectMaxDelay)
+		d.Log.WithError(err).WithFields(logging.Fields{
+			"attempt":  attempt + 1,
+			"retry_in": delay.String(),
+		}).Warn(ctx, "database not reachable yet")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up after %d attempts: %w", attempt+1, err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff - base doubled per attempt up to ceiling, with the upper half
+// jittered so replicas don't retry in lockstep
+func backoff(attempt int, base, ceiling time.Duration) time.Duration {
+	d := ceiling
+	if attempt < 30 && base<<attempt < ceiling {
+		d = base << attempt
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// IsUnavailable - whether err means the database could not be reached,
+// as opposed to the query itself failing. Feeds the circuit breaker.
+func IsUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		// connection exception, insufficient resources
+		case "08", "53":
+			return true
+		}
+		switch pqErr.Code {
+		// admin_shutdown, crash_shutdown, cannot_connect_now
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+	}
+	return false
 }
 
 func (d *Database) Ping(ctx context.Context) error {
 	return d.Client.DB.PingContext(ctx)
 }
+
+// logQueryError - logs a failed query with the request id from the context
+func (d *Database) logQueryError(ctx context.Context, msg string, err error) {
+	d.Log.WithError(err).Error(ctx, msg)
+}
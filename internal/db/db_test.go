@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	base, ceiling := 100*time.Millisecond, time.Second
+
+	for attempt, want := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		got := backoff(attempt, base, ceiling)
+		assert.GreaterOrEqual(t, got, want/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, got, want, "attempt %d", attempt)
+	}
+
+	// no overflow on absurd attempt counts
+	assert.LessOrEqual(t, backoff(100, base, ceiling), ceiling)
+}
+
+func TestIsUnavailable(t *testing.T) {
+	assert.True(t, IsUnavailable(fmt.Errorf("query: %w", driver.ErrBadConn)))
+	assert.True(t, IsUnavailable(context.DeadlineExceeded))
+	assert.True(t, IsUnavailable(&pq.Error{Code: "08006"}))
+	assert.True(t, IsUnavailable(&pq.Error{Code: "57P01"}))
+
+	assert.False(t, IsUnavailable(nil))
+	assert.False(t, IsUnavailable(context.Canceled))
+	assert.False(t, IsUnavailable(errors.New("some other error")))
+	// unique_violation is the query's fault, not the database's
+	assert.False(t, IsUnavailable(&pq.Error{Code: "23505"}))
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, isRetryable(fmt.Errorf("commit: %w", &pq.Error{Code: "40001"})))
+	assert.True(t, isRetryable(&pq.Error{Code: "40P01"}))
+	assert.False(t, isRetryable(&pq.Error{Code: "23505"}))
+	assert.False(t, isRetryable(errors.New("connection refused")))
+}
@@ -1,42 +1,245 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/migrations"
 )
 
+// migrationLockID - held for the whole migrate run, so replicas starting
+// together wait for each other instead of racing on the same schema
+const migrationLockID = 7_301_038
+
+// MigrationStatus - where the schema is compared to this build
+type MigrationStatus struct {
+	Version uint
+	Dirty   bool
+	// Latest - the newest migration this build ships with
+	Latest  uint
+	Pending []uint
+}
+
+// MigrateDB - applies every pending migration
 func (d *Database) MigrateDB() error {
 
-	fmt.Println("migrating the database")
+	ctx := context.Background()
+	d.Log.Info(ctx, "migrating the database")
 
-	driver, err := postgres.WithInstance(d.Client.DB, &postgres.Config{})
+	err := d.withMigrator(ctx, func(m *migrate.Migrate) error {
+		return m.Up()
+	})
 	if err != nil {
-		return fmt.Errorf("could not create a postgres driver instance: %w", err)
+		return fmt.Errorf("could not run up migrate the database: %w", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file:///migrations",
-		"postgres",
-		driver,
-	)
+	d.Log.Info(ctx, "successfully migrated the database")
 
+	return nil
+}
+
+// MigrateDown - rolls back the last n migrations
+func (d *Database) MigrateDown(ctx context.Context, n int) error {
+	if n < 1 {
+		return fmt.Errorf("number of migrations to roll back must be at least 1, got %d", n)
+	}
+
+	err := d.withMigrator(ctx, func(m *migrate.Migrate) error {
+		return m.Steps(-n)
+	})
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return fmt.Errorf("could not roll back %d migrations: %w", n, err)
+	}
+	return nil
+}
+
+// MigrateTo - migrates up or down to exactly version
+func (d *Database) MigrateTo(ctx context.Context, version uint) error {
+	err := d.withMigrator(ctx, func(m *migrate.Migrate) error {
+		return m.Migrate(version)
+	})
+	if err != nil {
+		return fmt.Errorf("could not migrate to version %d: %w", version, err)
+	}
+	return nil
+}
+
+// ForceMigrationVersion - records version as applied and clears the dirty
+// flag without running anything, for recovering from a failed migration
+func (d *Database) ForceMigrationVersion(ctx context.Context, version int) error {
+	err := d.withMigrator(ctx, func(m *migrate.Migrate) error {
+		return m.Force(version)
+	})
+	if err != nil {
+		return fmt.Errorf("could not force version %d: %w", version, err)
+	}
+	return nil
+}
+
+// MigrationStatus - the current version and what is still to be applied
+func (d *Database) MigrationStatus(ctx context.Context) (MigrationStatus, error) {
+	version, dirty, err := d.MigrationVersion(ctx)
+	if err != nil {
+		return MigrationStatus{}, err
 	}
 
-	if err := m.Up(); err != nil {
-		if !errors.Is(err, migrate.ErrNoChange) {
-			return fmt.Errorf("could not run up migrate the database: %w", err)
+	versions, err := d.migrationVersions()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	status := MigrationStatus{Version: version, Dirty: dirty}
+	for _, v := range versions {
+		status.Latest = v
+		if v > version {
+			status.Pending = append(status.Pending, v)
 		}
 	}
+	return status, nil
+}
+
+// withMigrator - runs fn on a dedicated connection holding migrationLockID.
+// Running into "no change" is not an error.
+func (d *Database) withMigrator(ctx context.Context, fn func(m *migrate.Migrate) error) error {
+	conn, err := d.Client.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get a connection: %w", err)
+	}
+	// closing twice is harmless, the driver closes it too
+	defer conn.Close()
 
-	fmt.Println("successfully migrated the database")
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("could not take the migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(),
+			`SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			d.Log.WithError(err).Warn(ctx, "could not release the migration lock")
+		}
+	}()
+
+	src, err := d.migrationSource()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// WithConnection rather than WithInstance: closing the driver
+	// must not close the shared pool
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		return fmt.Errorf("could not create a postgres driver instance: %w", err)
+	}
 
+	m, err := migrate.NewWithInstance("source", src, "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("could not create the migrate instance: %w", err)
+	}
+	m.Log = migrateLogger{log: d.Log}
+
+	if err := fn(m); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
 	return nil
 }
+
+// migrationSource - the embedded migrations, unless MigrationsSource
+// points somewhere else
+func (d *Database) migrationSource() (source.Driver, error) {
+	if d.MigrationsSource == "" {
+		src, err := iofs.New(migrations.FS, ".")
+		if err != nil {
+			return nil, fmt.Errorf("could not open the embedded migrations: %w", err)
+		}
+		return src, nil
+	}
+
+	src, err := source.Open(d.MigrationsSource)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the migration source: %w", err)
+	}
+	return src, nil
+}
+
+// migrationVersions - every migration version in the source, ascending
+func (d *Database) migrationVersions() ([]uint, error) {
+	src, err := d.migrationSource()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the first migration: %w", err)
+	}
+
+	versions := []uint{version}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration after %d: %w", version, err)
+		}
+		versions = append(versions, next)
+		version = next
+	}
+}
+
+// MigrationVersion - the version recorded in schema_migrations,
+// 0 when no migration has run yet
+func (d *Database) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var row struct {
+		Version int64 `db:"version"`
+		Dirty   bool  `db:"dirty"`
+	}
+
+	err := d.Client.GetContext(ctx, &row,
+		`SELECT version, dirty FROM schema_migrations LIMIT 1`)
+	var pqErr *pq.Error
+	// no rows or no table at all: nothing has been migrated yet
+	if errors.Is(err, sql.ErrNoRows) ||
+		(errors.As(err, &pqErr) && pqErr.Code == "42P01") {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("could not read the migration version: %w", err)
+	}
+
+	return uint(row.Version), row.Dirty, nil
+}
+
+// ExpectedMigrationVersion - the latest migration this build ships with
+func (d *Database) ExpectedMigrationVersion() (uint, error) {
+	versions, err := d.migrationVersions()
+	if err != nil {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// migrateLogger - routes golang-migrate's progress lines to our logger
+type migrateLogger struct {
+	log logging.Logger
+}
+
+func (l migrateLogger) Printf(format string, v ...interface{}) {
+	l.log.Info(context.Background(), strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (l migrateLogger) Verbose() bool {
+	return false
+}
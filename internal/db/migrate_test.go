@@ -0,0 +1,69 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("concurrent replicas don't race", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				db, err := NewDatabase(testConfig(t), logging.Nop())
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				defer db.Client.Close()
+				errs[i] = db.MigrateDB()
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("status is up to date", func(t *testing.T) {
+		db, err := NewDatabase(testConfig(t), logging.Nop())
+		require.NoError(t, err)
+
+		status, err := db.MigrationStatus(ctx)
+		require.NoError(t, err)
+		assert.False(t, status.Dirty)
+		assert.Equal(t, status.Latest, status.Version)
+		assert.Empty(t, status.Pending)
+	})
+
+	t.Run("down and back up", func(t *testing.T) {
+		db, err := NewDatabase(testConfig(t), logging.Nop())
+		require.NoError(t, err)
+
+		before, err := db.MigrationStatus(ctx)
+		require.NoError(t, err)
+
+		require.NoError(t, db.MigrateDown(ctx, 1))
+		status, err := db.MigrationStatus(ctx)
+		require.NoError(t, err)
+		assert.Len(t, status.Pending, 1)
+
+		require.NoError(t, db.MigrateTo(ctx, before.Version))
+		status, err = db.MigrationStatus(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, before.Version, status.Version)
+	})
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+)
+
+const (
+	// commentChangesChannel - what every replica LISTENs on
+	commentChangesChannel = "comment_changes"
+	// notifyPayloadLimit - postgres refuses payloads of 8000 bytes and
+	// more, this leaves room for the id and the jsonb formatting
+	notifyPayloadLimit = 7500
+	// listenerPingInterval - a connection can die without a word,
+	// pinging it is how the listener finds out and reconnects
+	listenerPingInterval = 90 * time.Second
+)
+
+// commentNotification - the payload of a comment_changes notification.
+// Bodies that don't fit are left out: the previous body first, which is
+// dropped for good since it can't be read back, then the comment's own,
+// which the listener reads back when Truncated is set. Listeners only
+// need the previous slug, see stream.Broker.
+type commentNotification struct {
+	ID        uint64          `json:"id"`
+	Type      string          `json:"type"`
+	Comment   comment.Comment `json:"comment"`
+	Previous  comment.Comment `json:"previous"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// NotifyCommentChange - numbers c from comment_change_seq and sends it
+// to every replica listening, see stream.Transport. Inside a WithTx it
+// joins the transaction: postgres only delivers the notification if it
+// commits, and the row lock on the counter is held until then, so ids
+// follow the order of the commits. It runs in a savepoint, a failure
+// here doesn't abort the change.
+func (d *Database) NotifyCommentChange(ctx context.Context, c comment.Change) error {
+	n := commentNotification{Type: c.Type, Comment: c.Comment, Previous: c.Previous}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("error encoding comment notification: %w", err)
+	}
+	if len(payload) > notifyPayloadLimit {
+		n.Previous.Body = ""
+		if payload, err = json.Marshal(n); err != nil {
+			return fmt.Errorf("error encoding comment notification: %w", err)
+		}
+	}
+	if len(payload) > notifyPayloadLimit {
+		n.Comment.Body, n.Truncated = "", true
+		if payload, err = json.Marshal(n); err != nil {
+			return fmt.Errorf("error encoding comment notification: %w", err)
+		}
+	}
+
+	return d.WithTx(ctx, func(ctx context.Context) error {
+		_, err := d.q(ctx).ExecContext(ctx,
+			`WITH s AS (
+			   UPDATE comment_change_seq SET seq = seq + 1 RETURNING seq
+			 )
+			 SELECT pg_notify($1, jsonb_set($2::jsonb, '{id}', to_jsonb(s.seq))::text)
+			 FROM s`,
+			commentChangesChannel, string(payload),
+		)
+		if err != nil {
+			d.logQueryError(ctx, "error notifying comment change", err)
+			return fmt.Errorf("error notifying comment change: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListenCommentChanges - LISTENs on a connection of its own, outside the
+// pool, until ctx is done. See stream.Transport.
+func (d *Database) ListenCommentChanges(
+	ctx context.Context,
+	deliver func(id uint64, c comment.Change),
+	missed func(),
+) error {
+	l := pq.NewListener(d.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			d.Log.WithError(err).Warn(ctx, "comment change listener lost its connection")
+		}
+	})
+	// closing the listener also ends the loop below
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer func() {
+		if stop() {
+			l.Close()
+		}
+	}()
+
+	if err := l.Listen(commentChangesChannel); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("error listening on %s: %w", commentChangesChannel, err)
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n, ok := <-l.Notify:
+			if !ok {
+				return nil
+			}
+			// nil after every reconnect
+			if n == nil {
+				missed()
+				continue
+			}
+
+			id, c, err := d.decodeCommentNotification(ctx, n.Extra)
+			if err != nil {
+				d.Log.WithError(err).Warn(ctx, "dropped a comment change notification")
+				continue
+			}
+			deliver(id, c)
+		case <-ping.C:
+			// only to notice a dead connection, the listener reconnects
+			go l.Ping()
+		}
+	}
+}
+
+// decodeCommentNotification - reads truncated comments back, a comment
+// deleted meanwhile goes out without its body
+func (d *Database) decodeCommentNotification(ctx context.Context, payload string) (uint64, comment.Change, error) {
+	var n commentNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return 0, comment.Change{}, fmt.Errorf("error decoding comment notification: %w", err)
+	}
+
+	if n.Truncated && n.Type != comment.EventCommentDeleted {
+		if current, err := d.GetComment(ctx, n.Comment.ID); err == nil {
+			n.Comment.Body = current.Body
+		}
+	}
+
+	return n.ID, comment.Change{Type: n.Type, Comment: n.Comment, Previous: n.Previous}, nil
+}
@@ -0,0 +1,137 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type notified struct {
+	id uint64
+	c  comment.Change
+}
+
+func TestCommentChangeNotifications(t *testing.T) {
+	db, err := NewDatabase(testConfig(t), logging.Nop())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan notified, 10)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- db.ListenCommentChanges(ctx, func(id uint64, c comment.Change) {
+			received <- notified{id, c}
+		}, func() {})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-stopped)
+	})
+
+	// other tests may be notifying too, only our slug counts
+	slug := "notify-" + time.Now().Format("150405.000000")
+	next := func(t *testing.T) notified {
+		t.Helper()
+		for {
+			select {
+			case n := <-received:
+				if n.c.Comment.Slug == slug {
+					return n
+				}
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "no notification")
+			}
+		}
+	}
+
+	// the listener connects in the background, keep notifying until it hears one
+	require.Eventually(t, func() bool {
+		require.NoError(t, db.NotifyCommentChange(ctx, comment.Change{
+			Type:    comment.EventCommentCreated,
+			Comment: comment.Comment{ID: "warm-up", Slug: slug},
+		}))
+		select {
+		case n := <-received:
+			return n.c.Comment.Slug == slug
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+
+	t.Run("changes arrive numbered one apart", func(t *testing.T) {
+		for _, id := range []string{"1", "2"} {
+			require.NoError(t, db.NotifyCommentChange(ctx, comment.Change{
+				Type:    comment.EventCommentUpdated,
+				Comment: comment.Comment{ID: id, Slug: slug, Body: "new"},
+				// a moved comment
+				Previous: comment.Comment{ID: id, Slug: "elsewhere", Body: "old"},
+			}))
+		}
+
+		first, second := next(t), next(t)
+		assert.Equal(t, first.id+1, second.id)
+		assert.Equal(t, "new", first.c.Comment.Body)
+		assert.Equal(t, "elsewhere", first.c.Previous.Slug)
+	})
+
+	t.Run("long comments are read back", func(t *testing.T) {
+		posted, err := db.PostComment(ctx, comment.Comment{
+			Slug:   slug,
+			Body:   strings.Repeat("x", 10000),
+			Author: "notify",
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { db.DeleteComment(ctx, posted.ID) })
+
+		require.NoError(t, db.NotifyCommentChange(ctx, comment.Change{
+			Type:    comment.EventCommentCreated,
+			Comment: posted,
+		}))
+		assert.Equal(t, posted, next(t).c.Comment)
+	})
+
+	t.Run("the previous body is dropped first", func(t *testing.T) {
+		posted, err := db.PostComment(ctx, comment.Comment{Slug: slug, Body: "short", Author: "notify"})
+		require.NoError(t, err)
+		t.Cleanup(func() { db.DeleteComment(ctx, posted.ID) })
+
+		require.NoError(t, db.NotifyCommentChange(ctx, comment.Change{
+			Type:     comment.EventCommentUpdated,
+			Comment:  posted,
+			Previous: comment.Comment{ID: posted.ID, Slug: "elsewhere", Body: strings.Repeat("x", 10000)},
+		}))
+		n := next(t)
+		assert.Equal(t, "short", n.c.Comment.Body)
+		assert.Equal(t, "elsewhere", n.c.Previous.Slug)
+		assert.Empty(t, n.c.Previous.Body)
+	})
+
+	t.Run("only committed changes are sent", func(t *testing.T) {
+		errRollback := errors.New("roll it back")
+		err := db.WithTx(ctx, func(ctx context.Context) error {
+			require.NoError(t, db.NotifyCommentChange(ctx, comment.Change{
+				Type:    comment.EventCommentCreated,
+				Comment: comment.Comment{ID: "rolled back", Slug: slug},
+			}))
+			return errRollback
+		})
+		require.ErrorIs(t, err, errRollback)
+
+		require.NoError(t, db.WithTx(ctx, func(ctx context.Context) error {
+			return db.NotifyCommentChange(ctx, comment.Change{
+				Type:    comment.EventCommentCreated,
+				Comment: comment.Comment{ID: "committed", Slug: slug},
+			})
+		}))
+		assert.Equal(t, "committed", next(t).c.Comment.ID)
+	})
+}
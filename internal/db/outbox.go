@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/outbox"
+)
+
+// OutboxRow - a row in the outbox table
+type OutboxRow struct {
+	Seq           int64     `db:"seq"`
+	EventID       string    `db:"event_id"`
+	EventType     string    `db:"event_type"`
+	AggregateType string    `db:"aggregate_type"`
+	AggregateID   string    `db:"aggregate_id"`
+	OccurredAt    time.Time `db:"occurred_at"`
+	RequestID     string    `db:"request_id"`
+	Data          []byte    `db:"data"`
+	Attempts      int       `db:"attempts"`
+}
+
+const outboxColumns = `seq, event_id, event_type, aggregate_type, aggregate_id,
+	occurred_at, request_id, data, attempts`
+
+func convertOutboxRowToEvent(r OutboxRow) outbox.Event {
+	return outbox.Event{
+		ID:            r.EventID,
+		Type:          r.EventType,
+		AggregateType: r.AggregateType,
+		AggregateID:   r.AggregateID,
+		OccurredAt:    r.OccurredAt.UTC(),
+		RequestID:     r.RequestID,
+		Data:          r.Data,
+		Seq:           r.Seq,
+		Attempts:      r.Attempts,
+	}
+}
+
+// AppendOutboxEvent - inside a WithTx the event commits or rolls back
+// with the change it describes
+func (d *Database) AppendOutboxEvent(ctx context.Context, e outbox.Event) error {
+	_, err := d.q(ctx).ExecContext(ctx,
+		`INSERT INTO outbox
+		 (event_id, event_type, aggregate_type, aggregate_id, occurred_at, request_id, data)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.ID, e.Type, e.AggregateType, e.AggregateID, e.OccurredAt, e.RequestID, string(e.Data),
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting outbox event: %w", err)
+	}
+	return nil
+}
+
+// ClaimOutboxEvents - pushes available_at past the lease on the oldest
+// due events and returns them. SKIP LOCKED lets relays on other replicas
+// claim the next events instead of waiting on ours. The lease runs on
+// the database clock, replica clocks may drift.
+func (d *Database) ClaimOutboxEvents(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) ([]outbox.Event, error) {
+	var rows []OutboxRow
+	err := d.q(ctx).SelectContext(ctx, &rows,
+		`UPDATE outbox SET
+		 attempts = attempts + 1,
+		 available_at = now() + $2 * interval '1 second'
+		 WHERE seq IN (
+		   SELECT seq FROM outbox
+		   WHERE published_at IS NULL AND available_at <= now()
+		   ORDER BY seq
+		   LIMIT $1
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+outboxColumns,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming outbox events: %w", err)
+	}
+
+	// RETURNING comes back in no particular order
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Seq < rows[j].Seq })
+
+	events := make([]outbox.Event, 0, len(rows))
+	for _, r := range rows {
+		events = append(events, convertOutboxRowToEvent(r))
+	}
+	return events, nil
+}
+
+func (d *Database) MarkOutboxEventPublished(ctx context.Context, seq int64) error {
+	_, err := d.q(ctx).ExecContext(ctx,
+		`UPDATE outbox SET published_at = now(), last_error = NULL
+		 WHERE seq = $1`,
+		seq,
+	)
+	if err != nil {
+		return fmt.Errorf("error marking outbox event as published: %w", err)
+	}
+	return nil
+}
+
+// RescheduleOutboxEvent - an event published in the meantime stays published
+func (d *Database) RescheduleOutboxEvent(
+	ctx context.Context,
+	seq int64,
+	at time.Time,
+	reason string,
+) error {
+	_, err := d.q(ctx).ExecContext(ctx,
+		`UPDATE outbox SET available_at = $2, last_error = $3
+		 WHERE seq = $1 AND published_at IS NULL`,
+		seq, at, reason,
+	)
+	if err != nil {
+		return fmt.Errorf("error rescheduling outbox event: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) PurgePublishedOutboxEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := d.q(ctx).ExecContext(ctx,
+		`DELETE FROM outbox WHERE published_at < $1`,
+		cutoff,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error purging outbox events: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error purging outbox events: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/outbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// claimed - whether the event with id is in events
+func claimed(events []outbox.Event, id string) (outbox.Event, bool) {
+	for _, e := range events {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return outbox.Event{}, false
+}
+
+func TestOutbox(t *testing.T) {
+	db, err := NewDatabase(testConfig(t), logging.Nop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// only our own events matter, others may be sitting in the outbox too
+	var appended []outbox.Event
+	store := &recordingStore{Database: db, appended: &appended}
+	w := outbox.NewWriter(store)
+
+	t.Run("an event rolled back with its transaction is never claimed", func(t *testing.T) {
+		errRollback := errors.New("roll it back")
+		err := db.WithTx(ctx, func(ctx context.Context) error {
+			require.NoError(t, w.Emit(ctx, "comment.created", "comment", "rolled-back", nil))
+			return errRollback
+		})
+		require.ErrorIs(t, err, errRollback)
+
+		events, err := db.ClaimOutboxEvents(ctx, 1000, time.Minute)
+		require.NoError(t, err)
+		_, ok := claimed(events, appended[len(appended)-1].ID)
+		assert.False(t, ok)
+	})
+
+	t.Run("claimed events are leased", func(t *testing.T) {
+		require.NoError(t, w.Emit(ctx, "comment.updated", "comment", "leased", map[string]string{"body": "b"}))
+		id := appended[len(appended)-1].ID
+
+		events, err := db.ClaimOutboxEvents(ctx, 1000, time.Minute)
+		require.NoError(t, err)
+		e, ok := claimed(events, id)
+		require.True(t, ok)
+		assert.Equal(t, 1, e.Attempts)
+		assert.JSONEq(t, `{"body":"b"}`, string(e.Data))
+
+		events, err = db.ClaimOutboxEvents(ctx, 1000, time.Minute)
+		require.NoError(t, err)
+		_, ok = claimed(events, id)
+		assert.False(t, ok, "still leased")
+
+		t.Run("rescheduled events come back", func(t *testing.T) {
+			require.NoError(t, db.RescheduleOutboxEvent(ctx, e.Seq, time.Now().Add(-time.Second), "boom"))
+
+			events, err := db.ClaimOutboxEvents(ctx, 1000, time.Minute)
+			require.NoError(t, err)
+			e, ok := claimed(events, id)
+			require.True(t, ok)
+			assert.Equal(t, 2, e.Attempts)
+
+			t.Run("published events are not claimed again", func(t *testing.T) {
+				require.NoError(t, db.MarkOutboxEventPublished(ctx, e.Seq))
+				require.NoError(t, db.RescheduleOutboxEvent(ctx, e.Seq, time.Now().Add(-time.Second), "late"))
+
+				events, err := db.ClaimOutboxEvents(ctx, 1000, time.Minute)
+				require.NoError(t, err)
+				_, ok := claimed(events, id)
+				assert.False(t, ok)
+			})
+		})
+	})
+}
+
+// recordingStore - remembers what was appended, ids are generated by the writer
+type recordingStore struct {
+	*Database
+	appended *[]outbox.Event
+}
+
+func (s *recordingStore) AppendOutboxEvent(ctx context.Context, e outbox.Event) error {
+	*s.appended = append(*s.appended, e)
+	return s.Database.AppendOutboxEvent(ctx, e)
+}
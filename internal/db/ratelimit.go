@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+)
+
+const (
+	// rateLimitPruneEvery - every n-th Take also deletes idle buckets
+	rateLimitPruneEvery = 1000
+	rateLimitIdleTTL    = time.Hour
+)
+
+// RateLimitStore - a ratelimit.Store backed by postgres,
+// so the limits hold across every replica of the API
+type RateLimitStore struct {
+	db    *Database
+	calls atomic.Uint64
+}
+
+// NewRateLimitStore - returns a postgres backed rate limit store
+func (d *Database) NewRateLimitStore() *RateLimitStore {
+	return &RateLimitStore{db: d}
+}
+
+// Take - locks the bucket row, refills it using the database clock
+// (replica clocks may drift) and writes it back in the same transaction.
+// The clock is read once the lock is held, and never behind updated_at:
+// a transaction that started before the last writer but got the lock
+// after it would otherwise move updated_at back and refill twice.
+func (s *RateLimitStore) Take(
+	ctx context.Context,
+	key string,
+	limit ratelimit.Limit,
+) (ratelimit.Result, error) {
+	if s.calls.Add(1)%rateLimitPruneEvery == 0 {
+		if _, err := s.db.Client.ExecContext(ctx,
+			`DELETE FROM rate_limit_buckets WHERE updated_at < now() - $1 * interval '1 second'`,
+			rateLimitIdleTTL.Seconds(),
+		); err != nil {
+			return ratelimit.Result{}, fmt.Errorf("error pruning rate limit buckets: %w", err)
+		}
+	}
+
+	tx, err := s.db.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("error starting rate limit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// new keys start with a full bucket, the insert is a no-op otherwise
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		 VALUES ($1, $2, clock_timestamp())
+		 ON CONFLICT (key) DO NOTHING`,
+		key, limit.Burst,
+	); err != nil {
+		return ratelimit.Result{}, fmt.Errorf("error creating rate limit bucket: %w", err)
+	}
+
+	var row struct {
+		Tokens    float64   `db:"tokens"`
+		UpdatedAt time.Time `db:"updated_at"`
+		Now       time.Time `db:"now"`
+	}
+
+	err = tx.GetContext(ctx, &row,
+		`SELECT tokens, updated_at, GREATEST(clock_timestamp(), updated_at) AS now
+		 FROM rate_limit_buckets
+		 WHERE key = $1
+		 FOR UPDATE`,
+		key,
+	)
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("error fetching rate limit bucket: %w", err)
+	}
+
+	bucket, res := ratelimit.Take(
+		ratelimit.Bucket{Tokens: row.Tokens, Updated: row.UpdatedAt},
+		limit,
+		row.Now,
+	)
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE rate_limit_buckets
+		 SET tokens = $2, updated_at = $3
+		 WHERE key = $1`,
+		key, bucket.Tokens, bucket.Updated,
+	)
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("error saving rate limit bucket: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ratelimit.Result{}, fmt.Errorf("error committing rate limit bucket: %w", err)
+	}
+
+	return res, nil
+}
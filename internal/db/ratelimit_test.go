@@ -0,0 +1,62 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	db, err := NewDatabase(testConfig(t), logging.Nop())
+	assert.NoError(t, err)
+
+	store := db.NewRateLimitStore()
+	key := "test:" + uuid.NewV4().String()
+	limit := ratelimit.Limit{Rate: 0.001, Burst: 2}
+
+	res, err := store.Take(context.Background(), key, limit)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, err = store.Take(context.Background(), key, limit)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, err = store.Take(context.Background(), key, limit)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestRateLimitStoreClockNeverGoesBack(t *testing.T) {
+	db, err := NewDatabase(testConfig(t), logging.Nop())
+	require.NoError(t, err)
+
+	store := db.NewRateLimitStore()
+	key := "test:" + uuid.NewV4().String()
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+
+	res, err := store.Take(context.Background(), key, limit)
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+
+	// as if a later writer had got there first
+	ahead := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+	_, err = db.Client.Exec(`UPDATE rate_limit_buckets SET updated_at = $2 WHERE key = $1`, key, ahead)
+	require.NoError(t, err)
+
+	res, err = store.Take(context.Background(), key, limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed, "no refill for time that hasn't passed")
+
+	var updated time.Time
+	require.NoError(t, db.Client.Get(&updated, `SELECT updated_at FROM rate_limit_buckets WHERE key = $1`, key))
+	assert.False(t, updated.Before(ahead))
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxTxAttempts - tries a transaction gets when postgres aborts it
+	// with a serialization failure or a deadlock
+	maxTxAttempts = 3
+
+	txRetryBaseDelay = 10 * time.Millisecond
+	txRetryMaxDelay  = 200 * time.Millisecond
+)
+
+// querier - what both *sqlx.DB and *sqlx.Tx can run
+type querier interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+type txKey struct{}
+
+// txState - the open transaction carried in the context
+type txState struct {
+	tx         *sqlx.Tx
+	savepoints int
+}
+
+// q - the transaction in ctx if there is one, the pool otherwise.
+// Every query goes through here so it joins an enclosing WithTx.
+func (d *Database) q(ctx context.Context) querier {
+	if st, ok := ctx.Value(txKey{}).(*txState); ok {
+		return st.tx
+	}
+	return d.Client
+}
+
+// WithTx - runs fn in a transaction, committed when fn returns nil and
+// rolled back when it returns an error or panics. Store calls made with
+// the ctx handed to fn run inside the transaction; calls made with any
+// other context do not.
+//
+// Nested calls run in a savepoint, so an inner failure only undoes the
+// inner work. The outermost call retries fn from scratch on serialization
+// failures and deadlocks, so fn must not have side effects outside the
+// database. The transaction is not safe for concurrent use.
+//
+// Isolation is read committed: audit appends lock the chain head and then
+// read it, which needs a fresh snapshot per statement.
+func (d *Database) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if st, ok := ctx.Value(txKey{}).(*txState); ok {
+		return d.savepoint(ctx, st, fn)
+	}
+
+	var err error
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff(attempt-1, txRetryBaseDelay, txRetryMaxDelay)):
+			}
+		}
+
+		err = d.runTx(ctx, fn)
+		if !isRetryable(err) {
+			return err
+		}
+		d.Log.WithError(err).Warn(ctx, "transaction aborted by postgres, retrying")
+	}
+	return err
+}
+
+func (d *Database) runTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	tx, err := d.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, &txState{tx: tx})); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			d.Log.WithError(rbErr).Error(ctx, "error rolling back transaction")
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) savepoint(ctx context.Context, st *txState, fn func(ctx context.Context) error) error {
+	st.savepoints++
+	name := fmt.Sprintf("sp_%d", st.savepoints)
+
+	if _, err := st.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("error creating savepoint: %w", err)
+	}
+
+	// a panic unwinds to runTx, which rolls back everything
+	if err := fn(ctx); err != nil {
+		if _, rbErr := st.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			d.Log.WithError(rbErr).Error(ctx, "error rolling back to savepoint")
+		}
+		return err
+	}
+
+	if _, err := st.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("error releasing savepoint: %w", err)
+	}
+	return nil
+}
+
+// isRetryable - serialization_failure and deadlock_detected
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) &&
+		(pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
@@ -0,0 +1,138 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTx(t *testing.T) {
+	db, err := NewDatabase(testConfig(t), logging.Nop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	newComment := comment.Comment{Slug: "tx test", Author: "txtestuser", Body: "body"}
+	errRollback := errors.New("roll it back")
+
+	t.Run("commits when fn succeeds", func(t *testing.T) {
+		var cmt comment.Comment
+		err := db.WithTx(ctx, func(ctx context.Context) error {
+			cmt, err = db.PostComment(ctx, newComment)
+			return err
+		})
+		require.NoError(t, err)
+
+		_, err = db.GetComment(ctx, cmt.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("rolls back when fn fails", func(t *testing.T) {
+		var cmt comment.Comment
+		err := db.WithTx(ctx, func(ctx context.Context) error {
+			cmt, err = db.PostComment(ctx, newComment)
+			require.NoError(t, err)
+			return errRollback
+		})
+		assert.ErrorIs(t, err, errRollback)
+
+		_, err = db.GetComment(ctx, cmt.ID)
+		assert.ErrorIs(t, err, comment.ErrNotFound)
+	})
+
+	t.Run("rolls back when fn panics", func(t *testing.T) {
+		var cmt comment.Comment
+		assert.Panics(t, func() {
+			db.WithTx(ctx, func(ctx context.Context) error {
+				cmt, _ = db.PostComment(ctx, newComment)
+				panic("boom")
+			})
+		})
+
+		_, err = db.GetComment(ctx, cmt.ID)
+		assert.ErrorIs(t, err, comment.ErrNotFound)
+	})
+
+	t.Run("a failed savepoint only undoes the inner work", func(t *testing.T) {
+		var outer, inner comment.Comment
+		err := db.WithTx(ctx, func(ctx context.Context) error {
+			outer, err = db.PostComment(ctx, newComment)
+			require.NoError(t, err)
+
+			innerErr := db.WithTx(ctx, func(ctx context.Context) error {
+				inner, err = db.PostComment(ctx, newComment)
+				require.NoError(t, err)
+				return errRollback
+			})
+			assert.ErrorIs(t, innerErr, errRollback)
+			return nil
+		})
+		require.NoError(t, err)
+
+		_, err = db.GetComment(ctx, outer.ID)
+		assert.NoError(t, err)
+		_, err = db.GetComment(ctx, inner.ID)
+		assert.ErrorIs(t, err, comment.ErrNotFound)
+	})
+
+	t.Run("retries serialization failures", func(t *testing.T) {
+		attempts := 0
+		err := db.WithTx(ctx, func(ctx context.Context) error {
+			attempts++
+			if attempts == 1 {
+				_, err := db.q(ctx).ExecContext(ctx,
+					`DO $$ BEGIN RAISE EXCEPTION 'conflict' USING ERRCODE = '40001'; END $$`)
+				return err
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("a row read for update waits out other writers", func(t *testing.T) {
+		cmt, err := db.PostComment(ctx, newComment)
+		require.NoError(t, err)
+
+		locked := make(chan struct{})
+		updated := make(chan error, 1)
+		err = db.WithTx(ctx, func(ctx context.Context) error {
+			if _, err := db.GetCommentForUpdate(ctx, cmt.ID); err != nil {
+				return err
+			}
+			close(locked)
+
+			go func() {
+				<-locked
+				c := newComment
+				c.Body = "from outside"
+				_, err := db.UpdateComment(context.Background(), cmt.ID, c)
+				updated <- err
+			}()
+
+			select {
+			case err := <-updated:
+				t.Errorf("the update went through while the row was locked: %v", err)
+			case <-time.After(200 * time.Millisecond):
+			}
+			c := newComment
+			c.Body = "from inside"
+			_, err := db.UpdateComment(ctx, cmt.ID, c)
+			return err
+		})
+		require.NoError(t, err)
+		require.NoError(t, <-updated)
+
+		// the outside writer went second
+		got, err := db.GetComment(ctx, cmt.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "from outside", got.Body)
+	})
+}
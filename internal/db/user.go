@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	uuid "github.com/satori/go.uuid"
+)
+
+// UserRow - a row in the users table
+type UserRow struct {
+	ID           string    `db:"id"`
+	Username     string    `db:"username"`
+	PasswordHash string    `db:"password_hash"`
+	Role         string    `db:"role"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// RefreshTokenRow - a row in the refresh_tokens table
+type RefreshTokenRow struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	FamilyID  string       `db:"family_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+func convertUserRowToUser(u UserRow) auth.User {
+	return auth.User{
+		ID:           u.ID,
+		Username:     u.Username,
+		PasswordHash: u.PasswordHash,
+		Role:         u.Role,
+		CreatedAt:    u.CreatedAt,
+	}
+}
+
+func convertRefreshTokenRow(r RefreshTokenRow) auth.RefreshToken {
+	rt := auth.RefreshToken{
+		ID:        r.ID,
+		UserID:    r.UserID,
+		FamilyID:  r.FamilyID,
+		TokenHash: r.TokenHash,
+		ExpiresAt: r.ExpiresAt,
+	}
+	if r.RevokedAt.Valid {
+		rt.RevokedAt = &r.RevokedAt.Time
+	}
+	return rt
+}
+
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (auth.User, error) {
+	var row UserRow
+
+	err := d.q(ctx).GetContext(ctx, &row,
+		`SELECT id, username, password_hash, role, created_at
+		 FROM users
+		 WHERE username = $1`,
+		username,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.User{}, auth.ErrNotFound
+	}
+	if err != nil {
+		return auth.User{}, fmt.Errorf("error fetching user by username: %w", err)
+	}
+
+	return convertUserRowToUser(row), nil
+}
+
+func (d *Database) GetUserByID(ctx context.Context, id string) (auth.User, error) {
+	var row UserRow
+
+	err := d.q(ctx).GetContext(ctx, &row,
+		`SELECT id, username, password_hash, role, created_at
+		 FROM users
+		 WHERE id = $1`,
+		id,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.User{}, auth.ErrNotFound
+	}
+	if err != nil {
+		return auth.User{}, fmt.Errorf("error fetching user by id: %w", err)
+	}
+
+	return convertUserRowToUser(row), nil
+}
+
+func (d *Database) CreateUser(ctx context.Context, u auth.User) (auth.User, error) {
+	row := UserRow{
+		ID:           uuid.NewV4().String(),
+		Username:     u.Username,
+		PasswordHash: u.PasswordHash,
+		Role:         u.Role,
+	}
+
+	err := d.q(ctx).GetContext(ctx, &row.CreatedAt,
+		`INSERT INTO users (id, username, password_hash, role)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING created_at`,
+		row.ID, row.Username, row.PasswordHash, row.Role,
+	)
+	if err != nil {
+		return auth.User{}, fmt.Errorf("error creating user: %w", err)
+	}
+
+	return convertUserRowToUser(row), nil
+}
+
+func (d *Database) CreateRefreshToken(ctx context.Context, rt auth.RefreshToken) error {
+	_, err := d.q(ctx).ExecContext(ctx,
+		`INSERT INTO refresh_tokens
+		 (id, user_id, family_id, token_hash, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		rt.ID, rt.UserID, rt.FamilyID, rt.TokenHash, rt.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Database) GetRefreshToken(ctx context.Context, tokenHash string) (auth.RefreshToken, error) {
+	var row RefreshTokenRow
+
+	err := d.q(ctx).GetContext(ctx, &row,
+		`SELECT id, user_id, family_id, token_hash, expires_at, revoked_at
+		 FROM refresh_tokens
+		 WHERE token_hash = $1`,
+		tokenHash,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.RefreshToken{}, auth.ErrNotFound
+	}
+	if err != nil {
+		return auth.RefreshToken{}, fmt.Errorf("error fetching refresh token: %w", err)
+	}
+
+	return convertRefreshTokenRow(row), nil
+}
+
+func (d *Database) ConsumeRefreshToken(ctx context.Context, id string) (bool, error) {
+	res, err := d.q(ctx).ExecContext(ctx,
+		`UPDATE refresh_tokens
+		 SET revoked_at = now()
+		 WHERE id = $1 AND revoked_at IS NULL`,
+		id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error consuming refresh token: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error consuming refresh token: %w", err)
+	}
+
+	return n == 1, nil
+}
+
+func (d *Database) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := d.q(ctx).ExecContext(ctx,
+		`UPDATE refresh_tokens
+		 SET revoked_at = now()
+		 WHERE family_id = $1 AND revoked_at IS NULL`,
+		familyID,
+	)
+	if err != nil {
+		return fmt.Errorf("error revoking refresh token family: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeToken - puts an access token jti on the denylist.
+// Entries past their expiry are pruned on the way in,
+// since an expired token is rejected anyway.
+func (d *Database) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := d.q(ctx).ExecContext(ctx,
+		`DELETE FROM revoked_tokens WHERE expires_at < now()`,
+	); err != nil {
+		return fmt.Errorf("error pruning revoked tokens: %w", err)
+	}
+
+	_, err := d.q(ctx).ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at)
+		 VALUES ($1, $2)
+		 ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error revoking token: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Database) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+
+	err := d.q(ctx).GetContext(ctx, &revoked,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`,
+		jti,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error checking revoked token: %w", err)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,59 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthDatabase(t *testing.T) {
+	db, err := NewDatabase(testConfig(t), logging.Nop())
+	assert.NoError(t, err)
+
+	svc := auth.NewService(db, []byte("integration"))
+
+	t.Run("login, rotate and detect refresh token reuse", func(t *testing.T) {
+		username := "user-" + uuid.NewV4().String()
+		_, err := svc.CreateUser(context.Background(), username, "s3cret", auth.RoleUser)
+		assert.NoError(t, err)
+
+		_, err = svc.Login(context.Background(), username, "wrong")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+		pair, err := svc.Login(context.Background(), username, "s3cret")
+		assert.NoError(t, err)
+
+		rotated, err := svc.Refresh(context.Background(), pair.RefreshToken)
+		assert.NoError(t, err)
+
+		// the first refresh token was consumed, using it again revokes the family
+		_, err = svc.Refresh(context.Background(), pair.RefreshToken)
+		assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+
+		_, err = svc.Refresh(context.Background(), rotated.RefreshToken)
+		assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+	})
+
+	t.Run("revoked jti is rejected", func(t *testing.T) {
+		username := "user-" + uuid.NewV4().String()
+		_, err := svc.CreateUser(context.Background(), username, "s3cret", auth.RoleUser)
+		assert.NoError(t, err)
+
+		pair, err := svc.Login(context.Background(), username, "s3cret")
+		assert.NoError(t, err)
+
+		claims, err := svc.ValidateToken(context.Background(), pair.AccessToken)
+		assert.NoError(t, err)
+
+		assert.NoError(t, svc.Revoke(context.Background(), claims, ""))
+
+		_, err = svc.ValidateToken(context.Background(), pair.AccessToken)
+		assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+	})
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/webhook"
+)
+
+// WebhookRow - a row in the webhooks table
+type WebhookRow struct {
+	ID          string         `db:"id"`
+	URL         string         `db:"url"`
+	Secret      string         `db:"secret"`
+	Events      pq.StringArray `db:"events"`
+	Description string         `db:"description"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
+
+const webhookColumns = `id, url, secret, events, description, created_at`
+
+func convertWebhookRowToWebhook(r WebhookRow) webhook.Webhook {
+	return webhook.Webhook{
+		ID:          r.ID,
+		URL:         r.URL,
+		Secret:      r.Secret,
+		Events:      r.Events,
+		Description: r.Description,
+		CreatedAt:   r.CreatedAt.UTC(),
+	}
+}
+
+// WebhookDeliveryRow - a row in the webhook_deliveries table, URL and
+// Secret are only selected when claiming
+type WebhookDeliveryRow struct {
+	ID             int64          `db:"id"`
+	WebhookID      string         `db:"webhook_id"`
+	EventID        string         `db:"event_id"`
+	EventType      string         `db:"event_type"`
+	Payload        []byte         `db:"payload"`
+	Status         string         `db:"status"`
+	Attempts       int            `db:"attempts"`
+	NextAttemptAt  time.Time      `db:"next_attempt_at"`
+	LastStatusCode sql.NullInt64  `db:"last_status_code"`
+	LastError      sql.NullString `db:"last_error"`
+	CreatedAt      time.Time      `db:"created_at"`
+	DeliveredAt    sql.NullTime   `db:"delivered_at"`
+	URL            string         `db:"url"`
+	Secret         string         `db:"secret"`
+}
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, d.event_id, d.event_type, d.payload,
+	d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error,
+	d.created_at, d.delivered_at`
+
+func convertWebhookDeliveryRowToDelivery(r WebhookDeliveryRow) webhook.Delivery {
+	d := webhook.Delivery{
+		ID:             r.ID,
+		WebhookID:      r.WebhookID,
+		EventID:        r.EventID,
+		EventType:      r.EventType,
+		Payload:        r.Payload,
+		Status:         r.Status,
+		Attempts:       r.Attempts,
+		NextAttemptAt:  r.NextAttemptAt.UTC(),
+		LastStatusCode: int(r.LastStatusCode.Int64),
+		LastError:      r.LastError.String,
+		CreatedAt:      r.CreatedAt.UTC(),
+		URL:            r.URL,
+		Secret:         r.Secret,
+	}
+	if r.DeliveredAt.Valid {
+		at := r.DeliveredAt.Time.UTC()
+		d.DeliveredAt = &at
+	}
+	return d
+}
+
+func (d *Database) CreateWebhook(ctx context.Context, w webhook.Webhook) (webhook.Webhook, error) {
+	var row WebhookRow
+	err := d.q(ctx).GetContext(ctx, &row,
+		`INSERT INTO webhooks (id, url, secret, events, description)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING `+webhookColumns,
+		w.ID, w.URL, w.Secret, pq.StringArray(w.Events), w.Description,
+	)
+	if err != nil {
+		return webhook.Webhook{}, fmt.Errorf("error creating webhook: %w", err)
+	}
+	return convertWebhookRowToWebhook(row), nil
+}
+
+func (d *Database) ListWebhooks(ctx context.Context) ([]webhook.Webhook, error) {
+	var rows []WebhookRow
+	if err := d.q(ctx).SelectContext(ctx, &rows,
+		`SELECT `+webhookColumns+` FROM webhooks ORDER BY created_at, id`,
+	); err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+
+	ws := make([]webhook.Webhook, 0, len(rows))
+	for _, r := range rows {
+		ws = append(ws, convertWebhookRowToWebhook(r))
+	}
+	return ws, nil
+}
+
+func (d *Database) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	res, err := d.q(ctx).ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if isMissing(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error deleting webhook: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error deleting webhook: %w", err)
+	}
+	return n > 0, nil
+}
+
+// EnqueueWebhookDeliveries - one statement for the whole fan-out
+func (d *Database) EnqueueWebhookDeliveries(ctx context.Context, ds []webhook.Delivery) error {
+	var (
+		values []string
+		args   []any
+	)
+	for _, dl := range ds {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+		args = append(args, dl.WebhookID, dl.EventID, dl.EventType, string(dl.Payload))
+	}
+
+	_, err := d.q(ctx).ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		 VALUES `+strings.Join(values, ", ")+`
+		 ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("error enqueueing webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries - works like ClaimOutboxEvents
+func (d *Database) ClaimWebhookDeliveries(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) ([]webhook.Delivery, error) {
+	var rows []WebhookDeliveryRow
+	err := d.q(ctx).SelectContext(ctx, &rows,
+		`UPDATE webhook_deliveries d SET
+		 attempts = d.attempts + 1,
+		 next_attempt_at = now() + $2 * interval '1 second'
+		 FROM webhooks w
+		 WHERE w.id = d.webhook_id AND d.id IN (
+		   SELECT id FROM webhook_deliveries
+		   WHERE status = 'pending' AND next_attempt_at <= now()
+		   ORDER BY next_attempt_at, id
+		   LIMIT $1
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+webhookDeliveryColumns+`, w.url, w.secret`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	ds := make([]webhook.Delivery, 0, len(rows))
+	for _, r := range rows {
+		ds = append(ds, convertWebhookDeliveryRowToDelivery(r))
+	}
+	return ds, nil
+}
+
+// RecordWebhookAttempt - a retry keeps next_attempt_at from the attempt,
+// success and dead deliveries are never claimed again
+func (d *Database) RecordWebhookAttempt(ctx context.Context, id int64, a webhook.Attempt) error {
+	var nextAttemptAt any
+	if !a.NextAttemptAt.IsZero() {
+		nextAttemptAt = a.NextAttemptAt
+	}
+
+	_, err := d.q(ctx).ExecContext(ctx,
+		`UPDATE webhook_deliveries SET
+		 status = $2,
+		 last_status_code = NULLIF($3, 0),
+		 last_error = NULLIF($4, ''),
+		 next_attempt_at = COALESCE($5, next_attempt_at),
+		 delivered_at = CASE WHEN $2 = 'succeeded' THEN now() ELSE delivered_at END
+		 WHERE id = $1`,
+		id, a.Status, a.StatusCode, a.Error, nextAttemptAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording webhook attempt: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) ListWebhookDeliveries(
+	ctx context.Context,
+	f webhook.DeliveryFilter,
+) ([]webhook.Delivery, error) {
+	var (
+		where []string
+		args  []any
+	)
+
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.WebhookID != "" {
+		add("d.webhook_id = $%d", f.WebhookID)
+	}
+	if f.Status != "" {
+		add("d.status = $%d", f.Status)
+	}
+	if f.AfterID > 0 {
+		add("d.id > $%d", f.AfterID)
+	}
+
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries d`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY d.id ASC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	var rows []WebhookDeliveryRow
+	err := d.q(ctx).SelectContext(ctx, &rows, query, args...)
+	if isMissing(err) {
+		return []webhook.Delivery{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook deliveries: %w", err)
+	}
+
+	ds := make([]webhook.Delivery, 0, len(rows))
+	for _, r := range rows {
+		ds = append(ds, convertWebhookDeliveryRowToDelivery(r))
+	}
+	return ds, nil
+}
+
+func (d *Database) ReplayWebhookDelivery(
+	ctx context.Context,
+	webhookID string,
+	id int64,
+) (webhook.Delivery, error) {
+	var row WebhookDeliveryRow
+	err := d.q(ctx).GetContext(ctx, &row,
+		`UPDATE webhook_deliveries d SET
+		 status = 'pending',
+		 attempts = 0,
+		 next_attempt_at = now(),
+		 delivered_at = NULL
+		 WHERE d.id = $1 AND d.webhook_id = $2
+		 RETURNING `+webhookDeliveryColumns,
+		id, webhookID,
+	)
+	if isMissing(err) {
+		return webhook.Delivery{}, webhook.ErrNotFound
+	}
+	if err != nil {
+		return webhook.Delivery{}, fmt.Errorf("error replaying webhook delivery: %w", err)
+	}
+	return convertWebhookDeliveryRowToDelivery(row), nil
+}
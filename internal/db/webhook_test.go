@@ -0,0 +1,93 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/webhook"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookDatabase(t *testing.T) {
+	db, err := NewDatabase(testConfig(t), logging.Nop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	w, err := db.CreateWebhook(ctx, webhook.Webhook{
+		ID:     uuid.NewV4().String(),
+		URL:    "https://example.com/hook",
+		Secret: "whsec_test",
+		Events: []string{"comment.created", "comment.*"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"comment.created", "comment.*"}, w.Events)
+	t.Cleanup(func() { db.DeleteWebhook(ctx, w.ID) })
+
+	delivery := webhook.Delivery{
+		WebhookID: w.ID,
+		EventID:   uuid.NewV4().String(),
+		EventType: "comment.created",
+		Payload:   json.RawMessage(`{"id":"1"}`),
+	}
+	require.NoError(t, db.EnqueueWebhookDeliveries(ctx, []webhook.Delivery{delivery}))
+	require.NoError(t, db.EnqueueWebhookDeliveries(ctx, []webhook.Delivery{delivery}), "duplicates are skipped")
+
+	ds, err := db.ListWebhookDeliveries(ctx, webhook.DeliveryFilter{WebhookID: w.ID})
+	require.NoError(t, err)
+	require.Len(t, ds, 1)
+	id := ds[0].ID
+
+	t.Run("claims carry the url and secret", func(t *testing.T) {
+		claimed, err := db.ClaimWebhookDeliveries(ctx, 1000, time.Minute)
+		require.NoError(t, err)
+
+		var found bool
+		for _, d := range claimed {
+			if d.ID == id {
+				found = true
+				assert.Equal(t, w.URL, d.URL)
+				assert.Equal(t, "whsec_test", d.Secret)
+				assert.Equal(t, 1, d.Attempts)
+			}
+		}
+		assert.True(t, found)
+	})
+
+	t.Run("a dead delivery can be replayed", func(t *testing.T) {
+		require.NoError(t, db.RecordWebhookAttempt(ctx, id, webhook.Attempt{
+			Status:     webhook.StatusDead,
+			StatusCode: 500,
+			Error:      "receiver responded with 500",
+		}))
+
+		dead, err := db.ListWebhookDeliveries(ctx, webhook.DeliveryFilter{WebhookID: w.ID, Status: webhook.StatusDead})
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+		assert.Equal(t, 500, dead[0].LastStatusCode)
+
+		d, err := db.ReplayWebhookDelivery(ctx, w.ID, id)
+		require.NoError(t, err)
+		assert.Equal(t, webhook.StatusPending, d.Status)
+		assert.Zero(t, d.Attempts)
+
+		_, err = db.ReplayWebhookDelivery(ctx, uuid.NewV4().String(), id)
+		assert.ErrorIs(t, err, webhook.ErrNotFound)
+	})
+
+	t.Run("deleting the webhook drops its deliveries", func(t *testing.T) {
+		deleted, err := db.DeleteWebhook(ctx, w.ID)
+		require.NoError(t, err)
+		assert.True(t, deleted)
+
+		ds, err := db.ListWebhookDeliveries(ctx, webhook.DeliveryFilter{WebhookID: w.ID})
+		require.NoError(t, err)
+		assert.Empty(t, ds)
+	})
+}
@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"fmt"
+)
+
+// MigrationVersioner - reports the schema version the database is at
+// and the one the binary ships with
+type MigrationVersioner interface {
+	MigrationVersion(ctx context.Context) (version uint, dirty bool, err error)
+	ExpectedMigrationVersion() (uint, error)
+}
+
+// MigrationCheck - fails when the schema is dirty or behind the version
+// this build expects. A newer schema passes: migrations have to stay
+// compatible with the previous release anyway, so during a rolling
+// deploy the old replicas keep serving while the new ones migrate.
+func MigrationCheck(m MigrationVersioner) CheckFunc {
+	return func(ctx context.Context) error {
+		want, err := m.ExpectedMigrationVersion()
+		if err != nil {
+			return fmt.Errorf("could not determine expected migration version: %w", err)
+		}
+
+		got, dirty, err := m.MigrationVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("schema is dirty at version %d", got)
+		}
+		if got < want {
+			return fmt.Errorf("schema is at version %d, expected at least %d", got, want)
+		}
+		return nil
+	}
+}
+
+// MinLengthCheck - config sanity, e.g. a signing secret that is too short
+func MinLengthCheck(what string, value []byte, min int) CheckFunc {
+	return func(context.Context) error {
+		if len(value) < min {
+			return fmt.Errorf("%s must be at least %d bytes, got %d", what, min, len(value))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package health
+
+import "context"
+
+// DiskSpaceCheck - not supported on this platform, always passes
+func DiskSpaceCheck(path string, minFree uint64) CheckFunc {
+	return func(context.Context) error {
+		return nil
+	}
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+)
+
+// DiskSpaceCheck - fails when the filesystem holding path has
+// less than minFree bytes available
+func DiskSpaceCheck(path string, minFree uint64) CheckFunc {
+	return func(context.Context) error {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(path, &st); err != nil {
+			return fmt.Errorf("could not stat %s: %w", path, err)
+		}
+
+		free := st.Bavail * uint64(st.Bsize)
+		if free < minFree {
+			return fmt.Errorf("only %d bytes free on %s, need %d", free, path, minFree)
+		}
+		return nil
+	}
+}
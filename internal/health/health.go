@@ -0,0 +1,176 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+
+	// DefaultTimeout - per check, unless Register is given one
+	DefaultTimeout = 2 * time.Second
+)
+
+var ErrDraining = errors.New("server is shutting down")
+
+// CheckFunc - returns nil when the dependency is healthy
+type CheckFunc func(ctx context.Context) error
+
+// CheckResult - outcome of a single check
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Report - what /healthz and /readyz return
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Healthy - whether the report should be served with a 200
+func (r Report) Healthy() bool {
+	return r.Status == StatusOK
+}
+
+type check struct {
+	name    string
+	fn      CheckFunc
+	timeout time.Duration
+}
+
+// Registry - the checks behind liveness and readiness.
+// Liveness should only hold checks that a restart would fix,
+// anything about dependencies belongs to readiness.
+type Registry struct {
+	mu        sync.RWMutex
+	liveness  []check
+	readiness []check
+	draining  atomic.Bool
+	// onDraining - told about every change of draining
+	onDraining []func(draining bool)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// RegisterLiveness - adds a check to /healthz
+func (r *Registry) RegisterLiveness(name string, timeout time.Duration, fn CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness = append(r.liveness, check{name: name, fn: fn, timeout: timeout})
+}
+
+// RegisterReadiness - adds a check to /readyz
+func (r *Registry) RegisterReadiness(name string, timeout time.Duration, fn CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness = append(r.readiness, check{name: name, fn: fn, timeout: timeout})
+}
+
+// SetDraining - once set, readiness fails so load balancers stop
+// sending traffic while in-flight requests finish
+func (r *Registry) SetDraining(draining bool) {
+	if r.draining.Swap(draining) == draining {
+		return
+	}
+
+	r.mu.RLock()
+	hooks := slices.Clone(r.onDraining)
+	r.mu.RUnlock()
+	for _, fn := range hooks {
+		fn(draining)
+	}
+}
+
+// OnDraining - calls fn whenever draining is set or cleared, for servers
+// that report readiness their own way like gRPC health checking
+func (r *Registry) OnDraining(fn func(draining bool)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onDraining = append(r.onDraining, fn)
+}
+
+func (r *Registry) Draining() bool {
+	return r.draining.Load()
+}
+
+// Liveness - runs the liveness checks
+func (r *Registry) Liveness(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := append([]check(nil), r.liveness...)
+	r.mu.RUnlock()
+
+	return run(ctx, checks)
+}
+
+// Readiness - runs the readiness checks, failing while draining
+func (r *Registry) Readiness(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := append([]check(nil), r.readiness...)
+	r.mu.RUnlock()
+
+	report := run(ctx, checks)
+	if r.Draining() {
+		report.Status = StatusDraining
+		report.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: ErrDraining.Error()}
+	}
+	return report
+}
+
+// run - checks run concurrently, each under its own timeout
+func run(ctx context.Context, checks []check) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c check) {
+			defer wg.Done()
+
+			timeout := c.timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.fn(cctx)
+			res := CheckResult{
+				Status:     StatusOK,
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				res.Status = StatusFail
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[c.name] = res
+			if err != nil {
+				report.Status = StatusFail
+			}
+			mu.Unlock()
+		}(c)
+	}
+
+	wg.Wait()
+	return report
+}
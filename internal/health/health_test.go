@@ -0,0 +1,97 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeVersioner struct {
+	version, expected uint
+	dirty             bool
+}
+
+func (f fakeVersioner) MigrationVersion(context.Context) (uint, bool, error) {
+	return f.version, f.dirty, nil
+}
+
+func (f fakeVersioner) ExpectedMigrationVersion() (uint, error) {
+	return f.expected, nil
+}
+
+func TestRegistry(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	broken := func(context.Context) error { return errors.New("connection refused") }
+
+	t.Run("all checks passing", func(t *testing.T) {
+		r := NewRegistry()
+		r.RegisterReadiness("database", time.Second, ok)
+
+		report := r.Readiness(context.Background())
+		assert.True(t, report.Healthy())
+		assert.Equal(t, StatusOK, report.Checks["database"].Status)
+	})
+
+	t.Run("one failing check fails the report", func(t *testing.T) {
+		r := NewRegistry()
+		r.RegisterReadiness("database", time.Second, broken)
+		r.RegisterReadiness("disk", time.Second, ok)
+
+		report := r.Readiness(context.Background())
+		assert.False(t, report.Healthy())
+		assert.Equal(t, StatusFail, report.Checks["database"].Status)
+		assert.Equal(t, "connection refused", report.Checks["database"].Error)
+		assert.Equal(t, StatusOK, report.Checks["disk"].Status)
+	})
+
+	t.Run("checks are cut off at their timeout", func(t *testing.T) {
+		r := NewRegistry()
+		r.RegisterReadiness("slow", 10*time.Millisecond, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		report := r.Readiness(context.Background())
+		assert.Equal(t, StatusFail, report.Checks["slow"].Status)
+	})
+
+	t.Run("draining fails readiness but not liveness", func(t *testing.T) {
+		r := NewRegistry()
+		r.RegisterReadiness("database", time.Second, ok)
+		r.SetDraining(true)
+
+		assert.Equal(t, StatusDraining, r.Readiness(context.Background()).Status)
+		assert.True(t, r.Liveness(context.Background()).Healthy())
+	})
+
+	t.Run("changes of draining are announced once", func(t *testing.T) {
+		r := NewRegistry()
+		var seen []bool
+		r.OnDraining(func(draining bool) { seen = append(seen, draining) })
+
+		r.SetDraining(true)
+		r.SetDraining(true)
+		r.SetDraining(false)
+
+		assert.Equal(t, []bool{true, false}, seen)
+	})
+}
+
+func TestMigrationCheck(t *testing.T) {
+	ctx := context.Background()
+
+	assert.NoError(t, MigrationCheck(fakeVersioner{version: 4, expected: 4})(ctx))
+	assert.Error(t, MigrationCheck(fakeVersioner{version: 3, expected: 4})(ctx))
+	// a newer binary has migrated ahead of this one
+	assert.NoError(t, MigrationCheck(fakeVersioner{version: 5, expected: 4})(ctx))
+	assert.Error(t, MigrationCheck(fakeVersioner{version: 5, expected: 4, dirty: true})(ctx))
+	assert.Error(t, MigrationCheck(fakeVersioner{version: 4, expected: 4, dirty: true})(ctx))
+}
+
+func TestMinLengthCheck(t *testing.T) {
+	assert.NoError(t, MinLengthCheck("key", []byte("0123456789abcdef"), 16)(context.Background()))
+	assert.Error(t, MinLengthCheck("key", []byte("short"), 16)(context.Background()))
+}
@@ -0,0 +1,216 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/requestid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Fields - structured key/value pairs attached to a log entry
+type Fields map[string]any
+
+// Logger - the logging contract every layer depends on.
+// The context passed to the level methods is used to pull
+// request scoped fields such as the request id.
+type Logger interface {
+	WithFields(fields Fields) Logger
+	WithError(err error) Logger
+
+	Debug(ctx context.Context, msg string)
+	Info(ctx context.Context, msg string)
+	Warn(ctx context.Context, msg string)
+	Error(ctx context.Context, msg string)
+}
+
+// Redactor - rewrites a field value before it is written.
+// It gets the field key so it can decide by name.
+type Redactor func(key string, value any) any
+
+// ContextExtractor - pulls fields out of a request context
+type ContextExtractor func(ctx context.Context) Fields
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys - fields that are never written as-is.
+// Comment bodies are user content and tokens are credentials.
+var sensitiveKeys = map[string]bool{
+	"body":          true,
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"authorization": true,
+	"secret":        true,
+}
+
+// jwtPattern - anything that looks like a compact JWT
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+// DefaultRedactor - masks sensitive keys and JWTs embedded in strings
+func DefaultRedactor(key string, value any) any {
+	if sensitiveKeys[strings.ToLower(key)] {
+		return redacted
+	}
+	if s, ok := value.(string); ok {
+		return RedactString(s)
+	}
+	return value
+}
+
+// RedactString - masks JWTs inside free text such as error messages
+func RedactString(s string) string {
+	return jwtPattern.ReplaceAllString(s, redacted)
+}
+
+// RequestIDExtractor - adds the request_id field
+func RequestIDExtractor(ctx context.Context) Fields {
+	if id := requestid.FromContext(ctx); id != "" {
+		return Fields{"request_id": id}
+	}
+	return nil
+}
+
+// TraceExtractor - adds trace_id and span_id when the context
+// carries a valid span, so log lines can be joined with traces
+func TraceExtractor(ctx context.Context) Fields {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return Fields{
+		"trace_id": sc.TraceID().String(),
+		"span_id":  sc.SpanID().String(),
+	}
+}
+
+// Options - how New builds the logger
+type Options struct {
+	// Level - debug, info, warn or error, defaults to info
+	Level string
+	// Format - json or text, defaults to json
+	Format string
+	Output io.Writer
+	// Redact - defaults to DefaultRedactor
+	Redact Redactor
+	// Extractors - defaults to RequestIDExtractor and TraceExtractor
+	Extractors []ContextExtractor
+}
+
+// New - returns a logrus backed Logger
+func New(opts Options) (Logger, error) {
+	l := logrus.New()
+
+	level := logrus.InfoLevel
+	if opts.Level != "" {
+		parsed, err := logrus.ParseLevel(opts.Level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level: %w", err)
+		}
+		level = parsed
+	}
+	l.SetLevel(level)
+
+	switch opts.Format {
+	case "", "json":
+		l.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be json or text", opts.Format)
+	}
+
+	l.SetOutput(os.Stdout)
+	if opts.Output != nil {
+		l.SetOutput(opts.Output)
+	}
+
+	redact := opts.Redact
+	if redact == nil {
+		redact = DefaultRedactor
+	}
+
+	extractors := opts.Extractors
+	if extractors == nil {
+		extractors = []ContextExtractor{RequestIDExtractor, TraceExtractor}
+	}
+
+	return &logrusLogger{
+		entry:      logrus.NewEntry(l),
+		redact:     redact,
+		extractors: extractors,
+	}, nil
+}
+
+// Nop - a logger that discards everything, handy in tests
+func Nop() Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return &logrusLogger{entry: logrus.NewEntry(l), redact: DefaultRedactor}
+}
+
+type logrusLogger struct {
+	entry      *logrus.Entry
+	redact     Redactor
+	extractors []ContextExtractor
+}
+
+func (l *logrusLogger) WithFields(fields Fields) Logger {
+	clean := make(logrus.Fields, len(fields))
+	for k, v := range fields {
+		clean[k] = l.redact(k, v)
+	}
+	return &logrusLogger{
+		entry:      l.entry.WithFields(clean),
+		redact:     l.redact,
+		extractors: l.extractors,
+	}
+}
+
+func (l *logrusLogger) WithError(err error) Logger {
+	if err == nil {
+		return l
+	}
+	// errors are flattened to text so they can be redacted too
+	return l.WithFields(Fields{logrus.ErrorKey: err.Error()})
+}
+
+func (l *logrusLogger) Debug(ctx context.Context, msg string) {
+	l.log(ctx, logrus.DebugLevel, msg)
+}
+
+func (l *logrusLogger) Info(ctx context.Context, msg string) {
+	l.log(ctx, logrus.InfoLevel, msg)
+}
+
+func (l *logrusLogger) Warn(ctx context.Context, msg string) {
+	l.log(ctx, logrus.WarnLevel, msg)
+}
+
+func (l *logrusLogger) Error(ctx context.Context, msg string) {
+	l.log(ctx, logrus.ErrorLevel, msg)
+}
+
+func (l *logrusLogger) log(ctx context.Context, level logrus.Level, msg string) {
+	if !l.entry.Logger.IsLevelEnabled(level) {
+		return
+	}
+
+	entry := l.entry
+	if ctx != nil {
+		for _, extract := range l.extractors {
+			for k, v := range extract(ctx) {
+				entry = entry.WithField(k, l.redact(k, v))
+			}
+		}
+		entry = entry.WithContext(ctx)
+	}
+
+	entry.Log(level, RedactString(msg))
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+)
+
+const namespace = "comments_api"
+
+// Metrics - every collector the API exposes, on its own registry
+// so tests can create as many instances as they like
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	HTTPRequests    *prometheus.CounterVec
+	HTTPDuration    *prometheus.HistogramVec
+	HTTPInFlight    prometheus.Gauge
+	StoreDuration   *prometheus.HistogramVec
+	CommentsCreated prometheus.Counter
+	CommentsDeleted prometheus.Counter
+	CommentsUpdated prometheus.Counter
+	// StoreBreakerState - 0 closed, 1 open, 2 half-open
+	StoreBreakerState prometheus.Gauge
+}
+
+// New - creates and registers the collectors, together with the
+// standard go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route template, method and status code.",
+		}, []string{"route", "method", "status"}),
+
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency, by route template, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+
+		HTTPInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+
+		StoreDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Latency of comment store operations, by method and outcome.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"method", "outcome"}),
+
+		CommentsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "comments_created_total",
+			Help:      "Comments created.",
+		}),
+
+		CommentsUpdated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "comments_updated_total",
+			Help:      "Comments updated.",
+		}),
+
+		CommentsDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "comments_deleted_total",
+			Help:      "Comments deleted.",
+		}),
+
+		StoreBreakerState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "store_breaker_state",
+			Help:      "State of the comment store circuit breaker: 0 closed, 1 open, 2 half-open.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		m.HTTPRequests,
+		m.HTTPDuration,
+		m.HTTPInFlight,
+		m.StoreDuration,
+		m.CommentsCreated,
+		m.CommentsUpdated,
+		m.CommentsDeleted,
+		m.StoreBreakerState,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// RegisterDBStats - exposes the sql.DB connection pool stats
+// (open, in use, idle, wait count and duration, ...)
+func (m *Metrics) RegisterDBStats(db *sql.DB, name string) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler - the /metrics endpoint
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// CommentChanged - counts a committed comment change, the
+// comment.Counter of the comment.Service
+func (m *Metrics) CommentChanged(eventType string) {
+	switch eventType {
+	case comment.EventCommentCreated:
+		m.CommentsCreated.Inc()
+	case comment.EventCommentUpdated:
+		m.CommentsUpdated.Inc()
+	case comment.EventCommentDeleted:
+		m.CommentsDeleted.Inc()
+	}
+}
+
+// ObserveStore - records how long a store method took
+func (m *Metrics) ObserveStore(method string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	m.StoreDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
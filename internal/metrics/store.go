@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+)
+
+// Store - wraps a comment.Store, timing every call. Changes are counted
+// by the comment.Service once they commit, see Metrics.CommentChanged.
+type Store struct {
+	next    comment.Store
+	metrics *Metrics
+}
+
+// InstrumentStore - returns next wrapped with metrics
+func InstrumentStore(next comment.Store, m *Metrics) *Store {
+	return &Store{next: next, metrics: m}
+}
+
+func (s *Store) GetComment(ctx context.Context, id string) (comment.Comment, error) {
+	start := time.Now()
+	cmt, err := s.next.GetComment(ctx, id)
+	s.metrics.ObserveStore("GetComment", start, err)
+	return cmt, err
+}
+
+func (s *Store) GetCommentForUpdate(ctx context.Context, id string) (comment.Comment, error) {
+	start := time.Now()
+	cmt, err := s.next.GetCommentForUpdate(ctx, id)
+	s.metrics.ObserveStore("GetCommentForUpdate", start, err)
+	return cmt, err
+}
+
+func (s *Store) PostComment(ctx context.Context, c comment.Comment) (comment.Comment, error) {
+	start := time.Now()
+	cmt, err := s.next.PostComment(ctx, c)
+	s.metrics.ObserveStore("PostComment", start, err)
+	return cmt, err
+}
+
+func (s *Store) DeleteComment(ctx context.Context, id string) (bool, error) {
+	start := time.Now()
+	deleted, err := s.next.DeleteComment(ctx, id)
+	s.metrics.ObserveStore("DeleteComment", start, err)
+	return deleted, err
+}
+
+func (s *Store) UpdateComment(ctx context.Context, id string, c comment.Comment) (comment.Comment, error) {
+	start := time.Now()
+	cmt, err := s.next.UpdateComment(ctx, id, c)
+	s.metrics.ObserveStore("UpdateComment", start, err)
+	return cmt, err
+}
+
+func (s *Store) GetMultipleComment(ctx context.Context) ([]comment.Comment, error) {
+	start := time.Now()
+	cmts, err := s.next.GetMultipleComment(ctx)
+	s.metrics.ObserveStore("GetMultipleComment", start, err)
+	return cmts, err
+}
+
+func (s *Store) ListComments(ctx context.Context, page comment.Page) ([]comment.Comment, error) {
+	start := time.Now()
+	cmts, err := s.next.ListComments(ctx, page)
+	s.metrics.ObserveStore("ListComments", start, err)
+	return cmts, err
+}
+
+func (s *Store) ListCommentsBy(
+	ctx context.Context,
+	field comment.Field,
+	values []string,
+	page comment.Page,
+) (map[string][]comment.Comment, error) {
+	start := time.Now()
+	cmts, err := s.next.ListCommentsBy(ctx, field, values, page)
+	s.metrics.ObserveStore("ListCommentsBy", start, err)
+	return cmts, err
+}
+
+func (s *Store) CountCommentsBy(ctx context.Context, field comment.Field, values []string) (map[string]int, error) {
+	start := time.Now()
+	counts, err := s.next.CountCommentsBy(ctx, field, values)
+	s.metrics.ObserveStore("CountCommentsBy", start, err)
+	return counts, err
+}
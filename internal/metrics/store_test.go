@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	comment.Store
+	err error
+}
+
+func (f fakeStore) PostComment(_ context.Context, c comment.Comment) (comment.Comment, error) {
+	return c, f.err
+}
+
+func TestInstrumentStore(t *testing.T) {
+	m := New()
+
+	_, err := InstrumentStore(fakeStore{}, m).PostComment(context.Background(), comment.Comment{})
+	assert.NoError(t, err)
+
+	_, err = InstrumentStore(fakeStore{err: errors.New("boom")}, m).PostComment(context.Background(), comment.Comment{})
+	assert.Error(t, err)
+
+	// the store may be inside a transaction that rolls back, it doesn't count
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.CommentsCreated))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.StoreDuration))
+}
+
+func TestCommentChanged(t *testing.T) {
+	m := New()
+
+	m.CommentChanged(comment.EventCommentCreated)
+	m.CommentChanged(comment.EventCommentCreated)
+	m.CommentChanged(comment.EventCommentDeleted)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.CommentsCreated))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.CommentsUpdated))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.CommentsDeleted))
+}
@@ -0,0 +1,86 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Event - a domain event waiting in the outbox.
+// ID is generated once when the event is written and travels with every
+// delivery attempt, so consumers can drop the duplicates that
+// at-least-once delivery produces.
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	RequestID     string          `json:"request_id,omitempty"`
+	Data          json.RawMessage `json:"data"`
+
+	// Seq - the outbox row id, only used to mark the row as published
+	Seq int64 `json:"-"`
+	// Attempts - publish attempts so far, including the current one
+	Attempts int `json:"-"`
+}
+
+// Store - persistence for the outbox.
+// AppendOutboxEvent must join the transaction in ctx, that is what makes
+// an event commit or roll back together with the change it describes.
+type Store interface {
+	AppendOutboxEvent(ctx context.Context, e Event) error
+	// ClaimOutboxEvents - leases up to limit due events for lease,
+	// other relays skip them until the lease runs out
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]Event, error)
+	MarkOutboxEventPublished(ctx context.Context, seq int64) error
+	// RescheduleOutboxEvent - releases the lease, the event is due again at
+	RescheduleOutboxEvent(ctx context.Context, seq int64, at time.Time, reason string) error
+	// PurgePublishedOutboxEvents - deletes events published before cutoff
+	PurgePublishedOutboxEvents(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Writer - turns domain events into outbox rows
+type Writer struct {
+	Store Store
+	now   func() time.Time
+}
+
+// NewWriter - returns a writer appending to the given store
+func NewWriter(store Store) *Writer {
+	return &Writer{
+		Store: store,
+		now:   time.Now,
+	}
+}
+
+// Emit - appends an event about the aggregate, data is stored as json
+func (w *Writer) Emit(
+	ctx context.Context,
+	eventType, aggregateType, aggregateID string,
+	data any,
+) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not encode %s event: %w", eventType, err)
+	}
+
+	err = w.Store.AppendOutboxEvent(ctx, Event{
+		ID:            uuid.NewV4().String(),
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		// postgres keeps microseconds, truncate so a round trip compares equal
+		OccurredAt: w.now().UTC().Truncate(time.Microsecond),
+		RequestID:  audit.RequestInfoFromContext(ctx).RequestID,
+		Data:       raw,
+	})
+	if err != nil {
+		return fmt.Errorf("could not append %s event: %w", eventType, err)
+	}
+	return nil
+}
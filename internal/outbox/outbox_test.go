@@ -0,0 +1,227 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore - leases events the same way the postgres store does
+type memStore struct {
+	now       func() time.Time
+	events    []Event
+	available map[int64]time.Time
+	published map[int64]bool
+	errors    map[int64]string
+}
+
+func newMemStore(now func() time.Time) *memStore {
+	return &memStore{
+		now:       now,
+		available: map[int64]time.Time{},
+		published: map[int64]bool{},
+		errors:    map[int64]string{},
+	}
+}
+
+func (m *memStore) AppendOutboxEvent(_ context.Context, e Event) error {
+	e.Seq = int64(len(m.events) + 1)
+	m.events = append(m.events, e)
+	m.available[e.Seq] = m.now()
+	return nil
+}
+
+func (m *memStore) ClaimOutboxEvents(_ context.Context, limit int, lease time.Duration) ([]Event, error) {
+	var out []Event
+	for i := range m.events {
+		e := &m.events[i]
+		if len(out) == limit || m.published[e.Seq] || m.available[e.Seq].After(m.now()) {
+			continue
+		}
+		e.Attempts++
+		m.available[e.Seq] = m.now().Add(lease)
+		out = append(out, *e)
+	}
+	return out, nil
+}
+
+func (m *memStore) MarkOutboxEventPublished(_ context.Context, seq int64) error {
+	m.published[seq] = true
+	return nil
+}
+
+func (m *memStore) RescheduleOutboxEvent(_ context.Context, seq int64, at time.Time, reason string) error {
+	m.available[seq] = at
+	m.errors[seq] = reason
+	return nil
+}
+
+func (m *memStore) PurgePublishedOutboxEvents(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+// flakyPublisher - fails every event listed in failing
+type flakyPublisher struct {
+	failing   map[string]bool
+	published []string
+}
+
+func (p *flakyPublisher) Publish(_ context.Context, e Event) error {
+	if p.failing[e.ID] {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, e.ID)
+	return nil
+}
+
+func TestWriterEmit(t *testing.T) {
+	store := newMemStore(time.Now)
+	ctx := audit.WithRequestInfo(context.Background(), audit.RequestInfo{RequestID: "req-1"})
+
+	err := NewWriter(store).Emit(ctx, "comment.created", "comment", "42", map[string]string{"body": "hi"})
+	require.NoError(t, err)
+
+	require.Len(t, store.events, 1)
+	e := store.events[0]
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, "comment.created", e.Type)
+	assert.Equal(t, "42", e.AggregateID)
+	assert.Equal(t, "req-1", e.RequestID)
+	assert.JSONEq(t, `{"body":"hi"}`, string(e.Data))
+}
+
+func TestRelay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store := newMemStore(clock)
+	w := NewWriter(store)
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.Emit(context.Background(), "comment.created", "comment", id, nil))
+	}
+	failing := store.events[1].ID
+
+	publisher := &flakyPublisher{failing: map[string]bool{failing: true}}
+	relay := NewRelay(store, publisher, RelayOptions{MinBackoff: time.Second}, logging.Nop())
+	relay.now = clock
+
+	n, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{store.events[0].ID, store.events[2].ID}, publisher.published)
+	assert.Equal(t, "broker unavailable", store.errors[2])
+
+	t.Run("a failed event waits for its backoff", func(t *testing.T) {
+		n, err := relay.RelayOnce(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("and is delivered again with the same id", func(t *testing.T) {
+		delete(publisher.failing, failing)
+		now = now.Add(2 * time.Second)
+
+		n, err := relay.RelayOnce(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, failing, publisher.published[2])
+		assert.Equal(t, 2, store.events[1].Attempts)
+	})
+}
+
+// cancellingPublisher - publishes, then cancels the relay's context
+// like a shutdown arriving mid-batch
+type cancellingPublisher struct {
+	flakyPublisher
+	cancel context.CancelFunc
+}
+
+func (p *cancellingPublisher) Publish(ctx context.Context, e Event) error {
+	p.cancel()
+	return p.flakyPublisher.Publish(ctx, e)
+}
+
+func TestRelayShutdown(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store := newMemStore(clock)
+	w := NewWriter(store)
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.Emit(context.Background(), "comment.created", "comment", id, nil))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	publisher := &cancellingPublisher{cancel: cancel}
+	relay := NewRelay(store, publisher, RelayOptions{}, logging.Nop())
+	relay.now = clock
+
+	n, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// the event in flight went out, the rest are due again straight away
+	assert.Equal(t, []string{store.events[0].ID}, publisher.published)
+	assert.Equal(t, now, store.available[2])
+	assert.Equal(t, now, store.available[3])
+
+	events, err := store.ClaimOutboxEvents(context.Background(), 10, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+}
+
+func TestRelayLease(t *testing.T) {
+	relay := NewRelay(nil, nil, RelayOptions{BatchSize: 10, PublishTimeout: 5 * time.Second}, logging.Nop())
+	assert.Equal(t, 50*time.Second, relay.opts.Lease)
+
+	relay = NewRelay(nil, nil, RelayOptions{}, logging.Nop())
+	assert.Equal(t, 100*10*time.Second, relay.opts.Lease)
+}
+
+func TestBackoff(t *testing.T) {
+	// doubling per attempt up to the cap, plus at most 20% jitter
+	for attempts, base := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		got := Backoff(attempts, time.Second, 5*time.Second)
+		assert.GreaterOrEqual(t, got, base)
+		assert.LessOrEqual(t, got, base+base/5)
+	}
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	var (
+		received Event
+		key      string
+		status   = http.StatusAccepted
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p := NewWebhookPublisher(srv.URL, time.Second)
+	e := Event{ID: "evt-1", Type: "comment.deleted", AggregateID: "42", Data: json.RawMessage(`{}`)}
+
+	require.NoError(t, p.Publish(context.Background(), e))
+	assert.Equal(t, "evt-1", key)
+	assert.Equal(t, "comment.deleted", received.Type)
+
+	status = http.StatusServiceUnavailable
+	assert.ErrorContains(t, p.Publish(context.Background(), e), "503")
+}
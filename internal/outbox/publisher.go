@@ -0,0 +1,129 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+)
+
+// LogPublisher - writes every event to the log, for local development
+// and for deployments with no consumers yet
+type LogPublisher struct {
+	Log logging.Logger
+}
+
+func (p LogPublisher) Publish(ctx context.Context, e Event) error {
+	p.Log.WithFields(logging.Fields{
+		"event_id":     e.ID,
+		"event_type":   e.Type,
+		"aggregate_id": e.AggregateID,
+	}).Info(ctx, "domain event")
+	return nil
+}
+
+// WebhookPublisher - POSTs each event as json to a fixed URL.
+// Any 2xx response counts as delivered. The event id is sent as
+// Idempotency-Key so the receiver can drop redeliveries.
+type WebhookPublisher struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookPublisher - timeout bounds each delivery attempt
+func NewWebhookPublisher(url string, timeout time.Duration) *WebhookPublisher {
+	return &WebhookPublisher{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", e.ID)
+	req.Header.Set("X-Event-Type", e.Type)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	// drain so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// NATSPublisher - publishes to JetStream on <subject prefix>.<event type>.
+// The event id goes out as Nats-Msg-Id, so the stream's duplicate window
+// drops redeliveries, and the publish only succeeds once the stream has
+// stored the message.
+type NATSPublisher struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+	timeout time.Duration
+}
+
+// NewNATSPublisher - connects to url, reconnecting forever on its own.
+// A stream covering "<subjectPrefix>.>" must already exist,
+// timeout bounds the wait for its acknowledgement.
+func NewNATSPublisher(url, subjectPrefix string, timeout time.Duration) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("comments-api outbox"),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to nats: %w", err)
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not open jetstream: %w", err)
+	}
+
+	return &NATSPublisher{conn: conn, js: js, subject: subjectPrefix, timeout: timeout}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+
+	msg := nats.NewMsg(p.subject + "." + e.Type)
+	msg.Data = body
+	msg.Header.Set(nats.MsgIdHdr, e.ID)
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	if _, err := p.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("could not publish to nats: %w", err)
+	}
+	return nil
+}
+
+// Close - flushes and closes the connection
+func (p *NATSPublisher) Close() {
+	p.conn.Drain()
+}
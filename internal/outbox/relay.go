@@ -0,0 +1,211 @@
+package outbox
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+)
+
+// Publisher - delivers one event downstream. A nil error means the event
+// was accepted and won't be sent again, anything else schedules a retry.
+// Publishers may see the same event more than once.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Publishers - sends every event to each publisher in turn. A failure
+// retries the whole event, so the ones before it see it again.
+type Publishers []Publisher
+
+func (ps Publishers) Publish(ctx context.Context, e Event) error {
+	for _, p := range ps {
+		if err := p.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RelayOptions - how often the relay polls and how it retries
+type RelayOptions struct {
+	// PollInterval - pause between polls when the outbox was drained
+	PollInterval time.Duration
+	// BatchSize - events claimed per poll
+	BatchSize int
+	// PublishTimeout - how long publishing one event may take
+	PublishTimeout time.Duration
+	// Lease - how long a claimed event is hidden from other relays,
+	// must cover publishing a whole batch. BatchSize × PublishTimeout
+	// by default.
+	Lease time.Duration
+	// MinBackoff, MaxBackoff - retry delay after a failed publish,
+	// doubled per attempt
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Retention - how long published events are kept, 0 keeps them forever
+	Retention time.Duration
+}
+
+// Relay - moves events from the outbox to a Publisher.
+// Several relays, one per replica, can share an outbox: claims are leased,
+// so each event is normally published once, and a relay dying mid-batch
+// only delays its events until the lease expires. Events are claimed in
+// the order they were written, but a failed event is retried later while
+// the ones after it go out, so consumers must not rely on ordering.
+type Relay struct {
+	Store     Store
+	Publisher Publisher
+	Log       logging.Logger
+	opts      RelayOptions
+	now       func() time.Time
+}
+
+// NewRelay - fills in defaults for every option left at zero
+func NewRelay(store Store, publisher Publisher, opts RelayOptions, logger logging.Logger) *Relay {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.PublishTimeout <= 0 {
+		opts.PublishTimeout = 10 * time.Second
+	}
+	if opts.Lease <= 0 {
+		// events in a batch are published one after another
+		opts.Lease = time.Duration(opts.BatchSize) * opts.PublishTimeout
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 10 * time.Minute
+	}
+
+	return &Relay{
+		Store:     store,
+		Publisher: publisher,
+		Log:       logger.WithFields(logging.Fields{"component": "outbox"}),
+		opts:      opts,
+		now:       time.Now,
+	}
+}
+
+// Run - polls until ctx is cancelled. A full batch is followed by another
+// poll straight away, so a backlog drains as fast as the publisher allows.
+func (r *Relay) Run(ctx context.Context) {
+	r.Log.Info(ctx, "outbox relay started")
+	defer r.Log.Info(ctx, "outbox relay stopped")
+
+	var lastPurge time.Time
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.Log.WithError(err).Error(ctx, "failed to relay outbox events")
+		}
+
+		if r.opts.Retention > 0 && r.now().Sub(lastPurge) >= time.Hour {
+			lastPurge = r.now()
+			r.purge(ctx)
+		}
+
+		wait := r.opts.PollInterval
+		if err == nil && n == r.opts.BatchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayOnce - claims one batch and publishes it,
+// returns how many events were claimed
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	events, err := r.Store.ClaimOutboxEvents(ctx, r.opts.BatchSize, r.opts.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, e := range events {
+		if ctx.Err() != nil {
+			// shutting down, hand the rest back instead of holding
+			// them, or shutdown, up for the whole lease
+			r.release(context.WithoutCancel(ctx), events[i:])
+			break
+		}
+		// the event being published is finished, it is already out
+		r.publish(context.WithoutCancel(ctx), e)
+	}
+	return len(events), nil
+}
+
+// release - makes events due again straight away, for other relays
+func (r *Relay) release(ctx context.Context, events []Event) {
+	for _, e := range events {
+		if err := r.Store.RescheduleOutboxEvent(ctx, e.Seq, r.now(), "relay stopped before publishing"); err != nil {
+			// the lease runs out on its own, the event is retried after that
+			r.Log.WithError(err).WithFields(logging.Fields{"event_id": e.ID}).
+				Error(ctx, "failed to release outbox event")
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, e Event) {
+	log := r.Log.WithFields(logging.Fields{
+		"event_id":   e.ID,
+		"event_type": e.Type,
+		"attempts":   e.Attempts,
+	})
+
+	pubCtx, cancel := context.WithTimeout(ctx, r.opts.PublishTimeout)
+	defer cancel()
+
+	if err := r.Publisher.Publish(pubCtx, e); err != nil {
+		retryAt := r.now().Add(Backoff(e.Attempts, r.opts.MinBackoff, r.opts.MaxBackoff))
+		log.WithError(err).Warn(ctx, "failed to publish outbox event, will retry")
+
+		if err := r.Store.RescheduleOutboxEvent(ctx, e.Seq, retryAt, err.Error()); err != nil {
+			// the lease runs out on its own, the event is retried after that
+			log.WithError(err).Error(ctx, "failed to reschedule outbox event")
+		}
+		return
+	}
+
+	if err := r.Store.MarkOutboxEventPublished(ctx, e.Seq); err != nil {
+		// published but not marked: it goes out again once the lease runs out,
+		// which the event id lets consumers detect
+		log.WithError(err).Error(ctx, "failed to mark outbox event as published")
+		return
+	}
+	log.Debug(ctx, "published outbox event")
+}
+
+// Backoff - min doubled per failed attempt, capped at max,
+// plus up to 20% jitter so retries of a burst spread out
+func Backoff(attempts int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+func (r *Relay) purge(ctx context.Context) {
+	n, err := r.Store.PurgePublishedOutboxEvents(ctx, r.now().Add(-r.opts.Retention))
+	if err != nil {
+		r.Log.WithError(err).Error(ctx, "failed to purge published outbox events")
+		return
+	}
+	if n > 0 {
+		r.Log.WithFields(logging.Fields{"count": n}).Info(ctx, "purged published outbox events")
+	}
+}
@@ -0,0 +1,24 @@
+package ratelimit
+
+import "context"
+
+type writeTakerKey struct{}
+
+// WriteTaker - charges the caller of a request one write, for handlers
+// that only learn whether a request writes once they have parsed it
+type WriteTaker func(ctx context.Context) (Result, error)
+
+// WithWriteTaker - stores take in the context for TakeWrite
+func WithWriteTaker(ctx context.Context, take WriteTaker) context.Context {
+	return context.WithValue(ctx, writeTakerKey{}, take)
+}
+
+// TakeWrite - charges one write through the WriteTaker in ctx, without
+// one there is no limit to charge and the write is allowed
+func TakeWrite(ctx context.Context) (Result, error) {
+	take, ok := ctx.Value(writeTakerKey{}).(WriteTaker)
+	if !ok {
+		return Result{Allowed: true}, nil
+	}
+	return take(ctx)
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit - a token bucket: refills at Rate tokens per second
+// and holds at most Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result - outcome of taking a token from a bucket
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset - time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter - time until the next token, zero when allowed
+	RetryAfter time.Duration
+}
+
+// Bucket - the persisted state of a token bucket
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Store - where buckets live. Take has to be atomic per key,
+// implementations shared across replicas make the limits global.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Take - refills the bucket up to now and tries to take one token.
+// A zero bucket is treated as a full one.
+func Take(b Bucket, l Limit, now time.Time) (Bucket, Result) {
+	burst := float64(l.Burst)
+
+	if b.Updated.IsZero() {
+		b = Bucket{Tokens: burst, Updated: now}
+	}
+
+	if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+elapsed*l.Rate)
+	}
+	b.Updated = now
+
+	res := Result{Limit: l.Burst}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else if l.Rate > 0 {
+		res.RetryAfter = seconds((1 - b.Tokens) / l.Rate)
+	} else {
+		res.RetryAfter = time.Hour
+	}
+
+	res.Remaining = int(math.Floor(b.Tokens))
+	if l.Rate > 0 {
+		res.Reset = seconds((burst - b.Tokens) / l.Rate)
+	}
+
+	return b, res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// MemoryStore - keeps buckets in process, limits are per replica
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]Bucket
+	now       func() time.Time
+	idleTTL   time.Duration
+	lastSweep time.Time
+}
+
+// NewMemoryStore - buckets untouched for idleTTL are dropped,
+// by then they would have refilled completely anyway
+func NewMemoryStore(idleTTL time.Duration) *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]Bucket),
+		now:     time.Now,
+		idleTTL: idleTTL,
+	}
+}
+
+func (m *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, res := Take(m.buckets[key], limit, now)
+	m.buckets[key] = b
+
+	return res, nil
+}
+
+// sweep - drops idle buckets, at most once per idleTTL
+func (m *MemoryStore) sweep(now time.Time) {
+	if m.idleTTL <= 0 || now.Sub(m.lastSweep) < m.idleTTL {
+		return
+	}
+	m.lastSweep = now
+
+	for k, b := range m.buckets {
+		if now.Sub(b.Updated) > m.idleTTL {
+			delete(m.buckets, k)
+		}
+	}
+}
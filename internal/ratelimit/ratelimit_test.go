@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := Limit{Rate: 1, Burst: 2}
+
+	b, res := Take(Bucket{}, limit, start)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	b, res = Take(b, limit, start)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 2*time.Second, res.Reset)
+
+	b, res = Take(b, limit, start.Add(500*time.Millisecond))
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	// a full second later one token has been refilled
+	_, res = Take(b, limit, start.Add(1500*time.Millisecond))
+	assert.True(t, res.Allowed)
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	limit := Limit{Rate: 1, Burst: 1}
+
+	res, err := store.Take(context.Background(), "a", limit)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, _ = store.Take(context.Background(), "a", limit)
+	assert.False(t, res.Allowed)
+
+	// other keys have their own bucket
+	res, _ = store.Take(context.Background(), "b", limit)
+	assert.True(t, res.Allowed)
+
+	now = now.Add(2 * time.Minute)
+	_, _ = store.Take(context.Background(), "c", limit)
+	assert.NotContains(t, store.buckets, "a")
+}
+
+func TestTakeWrite(t *testing.T) {
+	// nothing to charge, the write goes ahead
+	res, err := TakeWrite(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	ctx := WithWriteTaker(context.Background(), func(context.Context) (Result, error) {
+		return Result{RetryAfter: time.Second}, nil
+	})
+	res, err = TakeWrite(ctx)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
@@ -0,0 +1,47 @@
+package requestid
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Header - the header a request id is accepted from and echoed in
+const Header = "X-Request-ID"
+
+// maxLength - longer incoming ids are replaced with a generated one
+const maxLength = 128
+
+type key struct{}
+
+// New - generates a fresh request id
+func New() string {
+	return uuid.NewV4().String()
+}
+
+// WithContext - stores the request id in the context
+func WithContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext - returns the request id, empty when there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Valid - whether an id supplied by a client is safe to reuse.
+// Only visible ASCII without spaces or quotes is accepted, so the
+// value can't be used to inject anything into logs or headers.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package stream
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+)
+
+// EventTyping - someone is writing a comment under Slug. Typing events
+// have no ID, they are neither buffered for replay nor worth dropping a
+// slow subscriber over.
+const EventTyping = "typing"
+
+// Event - one comment change as pushed to subscribers. IDs increase by
+// one per event, within this process or, behind a Fanout, across replicas.
+type Event struct {
+	ID      uint64
+	Type    string
+	Comment comment.Comment
+	// Slug and User - set on typing events only
+	Slug string
+	User string
+	// slugs - who gets it, an update that moves a comment goes to both
+	slugs []string
+}
+
+// Options - how much the broker keeps around
+type Options struct {
+	// ReplayBuffer - the latest events kept for resuming subscribers
+	ReplayBuffer int
+	// SubscriberBuffer - events queued per subscriber, one that falls
+	// further behind is dropped and has to resume
+	SubscriberBuffer int
+}
+
+// Broker - fans committed comment changes out to live subscribers in this
+// process. It is a comment.Notifier, so it never blocks the service: slow
+// subscribers are dropped rather than waited for.
+type Broker struct {
+	opts Options
+
+	mu  sync.Mutex
+	seq uint64
+	// floor - the newest event no longer in replay, or never seen,
+	// subscribers resuming from before it have missed something
+	floor  uint64
+	replay []Event
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBroker - fills in defaults for every option left at zero
+func NewBroker(opts Options) *Broker {
+	if opts.ReplayBuffer <= 0 {
+		opts.ReplayBuffer = 1024
+	}
+	if opts.SubscriberBuffer <= 0 {
+		opts.SubscriberBuffer = 64
+	}
+	return &Broker{
+		opts: opts,
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription - events for a set of slugs. C is closed when the
+// subscriber was dropped for falling behind or the broker closed.
+type Subscription struct {
+	C <-chan Event
+
+	ch     chan Event
+	slugs  map[string]bool
+	broker *Broker
+}
+
+// Close - stops delivery, safe to call more than once
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s)
+}
+
+// Follow - adds slug to the subscription, later events under it are delivered
+func (s *Subscription) Follow(slug string) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.slugs[slug] = true
+}
+
+// Unfollow - stops delivering events under slug
+func (s *Subscription) Unfollow(slug string) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	delete(s.slugs, slug)
+}
+
+// Typing - tells the other subscribers of slug that user is writing.
+// Subscribers without room for it simply miss it.
+func (s *Subscription) Typing(slug, user string) {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e := Event{Type: EventTyping, Slug: slug, User: user, slugs: []string{slug}}
+	for sub := range b.subs {
+		// not echoed back to the sender
+		if sub == s || !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
+
+func (s *Subscription) wants(e Event) bool {
+	for _, slug := range e.slugs {
+		if s.slugs[slug] {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify - publishes the change, see comment.Notifier
+func (b *Broker) Notify(_ context.Context, c comment.Change) {
+	b.Publish(c)
+}
+
+// Publish - numbers the change, keeps it for replay and hands it to
+// every subscriber of its slug
+func (b *Broker) Publish(c comment.Change) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.publish(b.seq+1, c)
+}
+
+// PublishAt - Publish for changes numbered elsewhere, see Fanout. IDs
+// are expected one apart: an id further ahead means events were missed,
+// so subscribers can't resume from before it. An id already seen is
+// dropped and ok is false.
+func (b *Broker) PublishAt(id uint64, c comment.Change) (e Event, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if id <= b.seq {
+		return Event{}, false
+	}
+	if id != b.seq+1 {
+		b.floor = id - 1
+	}
+	return b.publish(id, c), true
+}
+
+// publish - must be called with the lock held
+func (b *Broker) publish(id uint64, c comment.Change) Event {
+	slugs := []string{c.Comment.Slug}
+	if c.Previous.Slug != "" && c.Previous.Slug != c.Comment.Slug {
+		slugs = append(slugs, c.Previous.Slug)
+	}
+
+	b.seq = id
+	e := Event{ID: id, Type: c.Type, Comment: c.Comment, slugs: slugs}
+
+	b.replay = append(b.replay, e)
+	if len(b.replay) > b.opts.ReplayBuffer {
+		evicted := len(b.replay) - b.opts.ReplayBuffer
+		b.floor = b.replay[evicted-1].ID
+		b.replay = b.replay[evicted:]
+	}
+
+	for s := range b.subs {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			// it resumes from the replay buffer when it reconnects
+			b.drop(s)
+		}
+	}
+	return e
+}
+
+// Reset - forgets every event and drops every subscriber, for when
+// events may have been missed. Their clients reconnect and, unless
+// they can resume from what comes next, reload.
+func (b *Broker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq, b.floor, b.replay = 0, 0, nil
+	for s := range b.subs {
+		b.drop(s)
+	}
+}
+
+// Subscribe - starts delivering events for slugs. With a lastEventID the
+// buffered events after it are returned to be sent first; complete is
+// false when some of them are no longer buffered, or the id is from
+// before a restart, and the subscriber should reload instead.
+func (b *Broker) Subscribe(lastEventID uint64, slugs ...string) (sub *Subscription, replay []Event, complete bool) {
+	ch := make(chan Event, b.opts.SubscriberBuffer)
+	sub = &Subscription{C: ch, ch: ch, slugs: map[string]bool{}, broker: b}
+	for _, slug := range slugs {
+		sub.slugs[slug] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return sub, nil, true
+	}
+	// registered under the same lock the replay is read with,
+	// so nothing falls between the two
+	b.subs[sub] = struct{}{}
+
+	if lastEventID == 0 {
+		return sub, nil, true
+	}
+	if lastEventID > b.seq {
+		return sub, nil, false
+	}
+
+	for _, e := range b.replay {
+		if e.ID > lastEventID && sub.wants(e) {
+			replay = append(replay, e)
+		}
+	}
+	return sub, replay, lastEventID >= b.floor
+}
+
+// Close - ends every subscription, later ones are closed straight away
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.drop(s)
+	}
+}
+
+// drop - must be called with the lock held
+func (b *Broker) drop(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,167 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func created(slug, id string) comment.Change {
+	return comment.Change{
+		Type:    comment.EventCommentCreated,
+		Comment: comment.Comment{ID: id, Slug: slug},
+	}
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("subscribers only get their slug", func(t *testing.T) {
+		b := NewBroker(Options{})
+		sub, _, _ := b.Subscribe(0, "go")
+		defer sub.Close()
+
+		b.Publish(created("rust", "1"))
+		b.Publish(created("go", "2"))
+
+		e := <-sub.C
+		assert.Equal(t, "2", e.Comment.ID)
+		assert.Equal(t, uint64(2), e.ID)
+		assert.Empty(t, sub.C)
+	})
+
+	t.Run("a comment moved to another slug reaches both", func(t *testing.T) {
+		b := NewBroker(Options{})
+		sub, _, _ := b.Subscribe(0, "old")
+		defer sub.Close()
+
+		b.Publish(comment.Change{
+			Type:     comment.EventCommentUpdated,
+			Comment:  comment.Comment{ID: "1", Slug: "new"},
+			Previous: comment.Comment{ID: "1", Slug: "old"},
+		})
+		assert.Len(t, sub.C, 1)
+	})
+
+	t.Run("resume replays what was missed", func(t *testing.T) {
+		b := NewBroker(Options{ReplayBuffer: 3})
+		for _, id := range []string{"1", "2", "3", "4"} {
+			b.Publish(created("go", id))
+		}
+
+		sub, replay, complete := b.Subscribe(2, "go")
+		defer sub.Close()
+		assert.True(t, complete)
+		require.Len(t, replay, 2)
+		assert.Equal(t, "3", replay[0].Comment.ID)
+		assert.Equal(t, "4", replay[1].Comment.ID)
+	})
+
+	t.Run("resume past the buffer asks for a reload", func(t *testing.T) {
+		b := NewBroker(Options{ReplayBuffer: 2})
+		for _, id := range []string{"1", "2", "3", "4"} {
+			b.Publish(created("go", id))
+		}
+
+		_, replay, complete := b.Subscribe(1, "go")
+		assert.False(t, complete)
+		assert.Len(t, replay, 2)
+
+		_, _, complete = b.Subscribe(99, "go")
+		assert.False(t, complete, "an id from before a restart")
+	})
+
+	t.Run("a subscriber that falls behind is dropped", func(t *testing.T) {
+		b := NewBroker(Options{SubscriberBuffer: 1})
+		sub, _, _ := b.Subscribe(0, "go")
+
+		b.Publish(created("go", "1"))
+		b.Publish(created("go", "2"))
+
+		<-sub.C
+		_, ok := <-sub.C
+		assert.False(t, ok)
+		sub.Close()
+	})
+
+	t.Run("follow and unfollow change what is delivered", func(t *testing.T) {
+		b := NewBroker(Options{})
+		sub, _, _ := b.Subscribe(0)
+		defer sub.Close()
+
+		sub.Follow("go")
+		b.Publish(created("go", "1"))
+		sub.Unfollow("go")
+		b.Publish(created("go", "2"))
+
+		require.Len(t, sub.C, 1)
+		assert.Equal(t, "1", (<-sub.C).Comment.ID)
+	})
+
+	t.Run("typing reaches the others but never drops them", func(t *testing.T) {
+		b := NewBroker(Options{SubscriberBuffer: 1})
+		alice, _, _ := b.Subscribe(0, "go")
+		defer alice.Close()
+		bob, _, _ := b.Subscribe(0, "go")
+		defer bob.Close()
+
+		alice.Typing("go", "alice")
+		alice.Typing("go", "alice")
+
+		assert.Empty(t, alice.C, "not echoed")
+		e, ok := <-bob.C
+		require.True(t, ok)
+		assert.Equal(t, EventTyping, e.Type)
+		assert.Equal(t, "alice", e.User)
+		assert.Zero(t, e.ID)
+
+		b.Publish(created("go", "1"))
+		assert.Len(t, bob.C, 1, "still subscribed")
+	})
+
+	t.Run("ids from elsewhere mark what was missed", func(t *testing.T) {
+		b := NewBroker(Options{})
+
+		_, ok := b.PublishAt(10, created("go", "1"))
+		assert.True(t, ok)
+		_, ok = b.PublishAt(10, created("go", "1"))
+		assert.False(t, ok, "seen already")
+
+		_, _, complete := b.Subscribe(9, "go")
+		assert.True(t, complete)
+		_, _, complete = b.Subscribe(8, "go")
+		assert.False(t, complete, "from before the first event we heard of")
+
+		b.PublishAt(12, created("go", "2"))
+		_, replay, complete := b.Subscribe(10, "go")
+		assert.False(t, complete, "11 never arrived")
+		assert.Len(t, replay, 1)
+	})
+
+	t.Run("reset drops subscribers and forgets events", func(t *testing.T) {
+		b := NewBroker(Options{})
+		sub, _, _ := b.Subscribe(0, "go")
+		b.PublishAt(5, created("go", "1"))
+		<-sub.C
+
+		b.Reset()
+		_, ok := <-sub.C
+		assert.False(t, ok)
+
+		_, _, complete := b.Subscribe(5, "go")
+		assert.False(t, complete)
+	})
+
+	t.Run("close ends every subscription", func(t *testing.T) {
+		b := NewBroker(Options{})
+		sub, _, _ := b.Subscribe(0, "go")
+		b.Close()
+
+		_, ok := <-sub.C
+		assert.False(t, ok)
+
+		late, _, _ := b.Subscribe(0, "go")
+		_, ok = <-late.C
+		assert.False(t, ok)
+	})
+}
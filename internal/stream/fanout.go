@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"context"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+)
+
+// Transport - carries committed changes to every replica, internal/db
+// does it with LISTEN/NOTIFY
+type Transport interface {
+	// NotifyCommentChange - numbers c and sends it to every listener,
+	// the sending replica included, once the transaction in ctx commits
+	NotifyCommentChange(ctx context.Context, c comment.Change) error
+	// ListenCommentChanges - hands every change to deliver until ctx is
+	// done, reconnecting as needed. missed is called after a reconnect,
+	// changes sent meanwhile are lost.
+	ListenCommentChanges(
+		ctx context.Context,
+		deliver func(id uint64, c comment.Change),
+		missed func(),
+	) error
+}
+
+// Fanout - the comment.Notifier when several replicas serve streams.
+// Changes aren't published to the local broker directly but go through
+// the Transport, which brings them back to every replica's broker with
+// the same ids, so a client can resume on any of them.
+type Fanout struct {
+	Transport Transport
+	Broker    *Broker
+	Log       logging.Logger
+}
+
+func NewFanout(t Transport, b *Broker, logger logging.Logger) *Fanout {
+	return &Fanout{
+		Transport: t,
+		Broker:    b,
+		Log:       logger.WithFields(logging.Fields{"component": "stream"}),
+	}
+}
+
+// NotifyInTx - see comment.TxNotifier. The notification is sent in the
+// transaction of the change, so replicas hear of it if and only if it
+// commits, and in the order of the commits.
+func (f *Fanout) NotifyInTx(ctx context.Context, c comment.Change) error {
+	return f.Transport.NotifyCommentChange(ctx, c)
+}
+
+// Notify - see comment.Notifier, for changes committed without a
+// transaction to join
+func (f *Fanout) Notify(ctx context.Context, c comment.Change) {
+	// the change is committed already, only live clients miss out
+	if err := f.Transport.NotifyCommentChange(context.WithoutCancel(ctx), c); err != nil {
+		f.Log.WithError(err).Warn(ctx, "failed to notify replicas of a comment change")
+	}
+}
+
+// Run - feeds the broker until ctx is done
+func (f *Fanout) Run(ctx context.Context) {
+	deliver := func(id uint64, c comment.Change) {
+		f.Broker.PublishAt(id, c)
+	}
+	missed := func() {
+		f.Log.Warn(ctx, "comment change listener reconnected, live clients have to catch up")
+		f.Broker.Reset()
+	}
+
+	if err := f.Transport.ListenCommentChanges(ctx, deliver, missed); err != nil && ctx.Err() == nil {
+		f.Log.WithError(err).Error(ctx, "stopped listening for comment changes")
+	}
+}
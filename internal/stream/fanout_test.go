@@ -0,0 +1,71 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loopback - numbers changes and hands them to every listener, like
+// LISTEN/NOTIFY does for every replica
+type loopback struct {
+	seq     uint64
+	changes chan comment.Change
+	ids     chan uint64
+}
+
+func (l *loopback) NotifyCommentChange(_ context.Context, c comment.Change) error {
+	l.seq++
+	l.ids <- l.seq
+	l.changes <- c
+	return nil
+}
+
+func (l *loopback) ListenCommentChanges(ctx context.Context, deliver func(uint64, comment.Change), missed func()) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case id := <-l.ids:
+			deliver(id, <-l.changes)
+		}
+	}
+}
+
+func TestFanout(t *testing.T) {
+	transport := &loopback{seq: 41, changes: make(chan comment.Change, 1), ids: make(chan uint64, 1)}
+	broker := NewBroker(Options{})
+	fanout := NewFanout(transport, broker, logging.Nop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go fanout.Run(ctx)
+
+	sub, _, _ := broker.Subscribe(0, "go")
+	defer sub.Close()
+
+	fanout.Notify(ctx, created("go", "1"))
+
+	select {
+	case e := <-sub.C:
+		assert.Equal(t, uint64(42), e.ID, "numbered by the transport")
+		assert.Equal(t, "1", e.Comment.ID)
+	case <-time.After(time.Second):
+		require.Fail(t, "the change never came back")
+	}
+
+	require.NoError(t, fanout.NotifyInTx(ctx, created("go", "2")))
+
+	select {
+	case e := <-sub.C:
+		assert.Equal(t, uint64(43), e.ID)
+		assert.Equal(t, "2", e.Comment.ID)
+	case <-time.After(time.Second):
+		require.Fail(t, "the change never came back")
+	}
+}
@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+
+	shutdown, err := Setup(context.Background(), Config{
+		ServiceName: "test",
+		Exporter:    ExporterFile,
+		FilePath:    path,
+	})
+	assert.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "offline-span")
+	span.End()
+
+	assert.NoError(t, shutdown(context.Background()))
+
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(written), `"Name":"offline-span"`)
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Config{Exporter: "carrier-pigeon"})
+	assert.Error(t, err)
+
+	_, err = Setup(context.Background(), Config{Exporter: ExporterFile})
+	assert.Error(t, err)
+}
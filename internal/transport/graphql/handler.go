@@ -0,0 +1,183 @@
+package graphql
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	gqllog "github.com/graph-gophers/graphql-go/log"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+)
+
+//go:embed schema.graphql
+var schema string
+
+const (
+	// maxRequestBytes - a mutation with the longest allowed body fits easily
+	maxRequestBytes = 64 << 10
+	// maxDepth - comment { thread { comments { edges { node { thread ... } } } } }
+	// can be nested forever, every level is another batch of queries
+	maxDepth = 10
+)
+
+// CommentService - the same comment.Service the REST and gRPC transports use
+type CommentService interface {
+	GetComment(ctx context.Context, ID string) (comment.Comment, error)
+	PostComment(context.Context, comment.Comment) (comment.Comment, error)
+	UpdateComment(ctx context.Context, ID string, newCmt comment.Comment) (comment.Comment, error)
+	DeleteComment(ctx context.Context, ID string) error
+	ListComments(ctx context.Context, page comment.Page) ([]comment.Comment, error)
+	ListCommentsBy(ctx context.Context, field comment.Field, values []string, page comment.Page) (map[string][]comment.Comment, error)
+	CountCommentsBy(ctx context.Context, field comment.Field, values []string) (map[string]int, error)
+}
+
+// Handler - serves the schema over POST, and over websockets speaking
+// graphql-transport-ws for subscriptions. It leaves authentication to
+// whatever it is mounted behind: mutations need auth.Claims in the
+// request context, queries and subscriptions are open.
+type Handler struct {
+	Schema  *graphql.Schema
+	Service CommentService
+	Log     logging.Logger
+
+	// Broker - optional, without it subscriptions fail
+	Broker *stream.Broker
+	// Heartbeat - how often an idle socket is pinged
+	Heartbeat time.Duration
+	// MaxSubscriptions - operations one socket may run at a time
+	MaxSubscriptions int
+
+	// closing - closed by Shutdown, so sockets end instead of holding it up
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// Option - optional Handler settings
+type Option func(*Handler)
+
+// WithBroker - enables subscriptions, fed by b
+func WithBroker(b *stream.Broker) Option {
+	return func(h *Handler) {
+		h.Broker = b
+	}
+}
+
+// WithHeartbeat - see Handler.Heartbeat
+func WithHeartbeat(d time.Duration) Option {
+	return func(h *Handler) {
+		h.Heartbeat = d
+	}
+}
+
+// WithMaxSubscriptions - see Handler.MaxSubscriptions
+func WithMaxSubscriptions(n int) Option {
+	return func(h *Handler) {
+		h.MaxSubscriptions = n
+	}
+}
+
+func NewHandler(
+	service CommentService,
+	logger logging.Logger,
+	opts ...Option,
+) *Handler {
+	h := &Handler{
+		Service:          service,
+		Log:              logger.WithFields(logging.Fields{"component": "graphql"}),
+		Heartbeat:        15 * time.Second,
+		MaxSubscriptions: 20,
+		closing:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.Schema = graphql.MustParseSchema(schema,
+		&resolver{service: h.Service, broker: h.Broker, log: h.Log},
+		graphql.UseStringDescriptions(),
+		graphql.MaxDepth(maxDepth),
+		// list items resolve in parallel, each waiting on the loaders,
+		// a full page has to fit or it is split over several batches
+		graphql.MaxParallelism(4*maxFirst),
+		// an event waits this long for a slow socket before it is dropped,
+		// the socket's own write deadline goes first
+		graphql.SubscribeResolverTimeout(3*h.Heartbeat),
+		graphql.Logger(gqllog.LoggerFunc(func(ctx context.Context, p any) {
+			h.Log.WithFields(logging.Fields{"panic": p}).Error(ctx, "graphql resolver panicked")
+		})),
+		graphql.PanicHandler(panicHandler{}),
+	)
+
+	return h
+}
+
+// panicHandler - the panic itself is only logged
+type panicHandler struct{}
+
+func (panicHandler) MakePanicError(context.Context, any) *gqlerrors.QueryError {
+	return &gqlerrors.QueryError{
+		Message:    "something went wrong while processing the request",
+		Extensions: map[string]any{"code": CodeInternal},
+	}
+}
+
+// Shutdown - closes every socket, safe to call more than once
+func (h *Handler) Shutdown() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+// Request - a GraphQL request as POSTed, and as sent in a subscribe message
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// ServeHTTP - POST /graphql, or GET /graphql to open a socket. Errors in
+// the query are reported in the body with a 200 like any GraphQL server,
+// only a body that isn't a request at all is a 400.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		h.serveSocket(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeErrors(w, http.StatusMethodNotAllowed, "queries and mutations are POSTed, subscriptions need a websocket")
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil || req.Query == "" {
+		writeErrors(w, http.StatusBadRequest, "the body must be a JSON object with a query")
+		return
+	}
+
+	ctx := withLoaders(r.Context(), newLoaders(h.Service))
+	resp := h.Schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.Log.WithError(err).Error(r.Context(), "failed to write graphql response")
+	}
+}
+
+// writeErrors - a response with just an error, in the GraphQL shape
+func writeErrors(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(graphql.Response{
+		Errors: []*gqlerrors.QueryError{{
+			Message:    msg,
+			Extensions: map[string]any{"code": CodeBadUserInput},
+		}},
+	})
+}
@@ -0,0 +1,315 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeService - an in-memory store that counts the batched calls
+type fakeService struct {
+	CommentService
+
+	mu       sync.Mutex
+	comments []comment.Comment
+	lists    [][]string
+	counts   [][]string
+}
+
+func newFakeService() *fakeService {
+	s := &fakeService{}
+	for i, slug := range []string{"go", "go", "go", "rust", "zig"} {
+		s.comments = append(s.comments, comment.Comment{
+			ID:     fmt.Sprintf("c%d", i),
+			Slug:   slug,
+			Body:   "body",
+			Author: []string{"ann", "bob"}[i%2],
+		})
+	}
+	return s
+}
+
+func (s *fakeService) GetComment(_ context.Context, id string) (comment.Comment, error) {
+	for _, c := range s.comments {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return comment.Comment{}, comment.ErrNotFound
+}
+
+func (s *fakeService) PostComment(_ context.Context, c comment.Comment) (comment.Comment, error) {
+	c.ID = "new"
+	return c, nil
+}
+
+func (s *fakeService) DeleteComment(context.Context, string) error {
+	return comment.ErrUnavailable
+}
+
+func (s *fakeService) ListComments(_ context.Context, page comment.Page) ([]comment.Comment, error) {
+	var cmts []comment.Comment
+	for _, c := range s.comments {
+		if c.ID > page.After && len(cmts) < page.Limit {
+			cmts = append(cmts, c)
+		}
+	}
+	return cmts, nil
+}
+
+func (s *fakeService) ListCommentsBy(
+	_ context.Context,
+	field comment.Field,
+	values []string,
+	page comment.Page,
+) (map[string][]comment.Comment, error) {
+	s.mu.Lock()
+	s.lists = append(s.lists, sorted(values))
+	s.mu.Unlock()
+
+	cmts := map[string][]comment.Comment{}
+	for _, v := range values {
+		for _, c := range s.comments {
+			key := c.Slug
+			if field == comment.ByAuthor {
+				key = c.Author
+			}
+			if key == v && c.ID > page.After && len(cmts[v]) < page.Limit {
+				cmts[v] = append(cmts[v], c)
+			}
+		}
+	}
+	return cmts, nil
+}
+
+func (s *fakeService) CountCommentsBy(
+	_ context.Context,
+	field comment.Field,
+	values []string,
+) (map[string]int, error) {
+	s.mu.Lock()
+	s.counts = append(s.counts, sorted(values))
+	s.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, c := range s.comments {
+		if field == comment.BySlug {
+			counts[c.Slug]++
+		} else {
+			counts[c.Author]++
+		}
+	}
+	return counts, nil
+}
+
+func sorted(values []string) []string {
+	values = append([]string(nil), values...)
+	sort.Strings(values)
+	return values
+}
+
+type response struct {
+	Data   json.RawMessage
+	Errors []struct {
+		Message    string
+		Extensions struct{ Code string }
+	}
+}
+
+func exec(t *testing.T, h http.Handler, ctx context.Context, query string, vars map[string]any) response {
+	t.Helper()
+	body, err := json.Marshal(Request{Query: query, Variables: vars})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestQueries(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("threads are loaded in one batch", func(t *testing.T) {
+		service := newFakeService()
+		h := NewHandler(service, logging.Nop())
+
+		resp := exec(t, h, ctx, `{
+			threads(slugs: ["go", "rust", "zig"]) {
+				slug
+				commentCount
+				comments(first: 2) { edges { node { id } } pageInfo { hasNextPage } }
+			}
+		}`, nil)
+		require.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"threads": [
+			{"slug": "go", "commentCount": 3, "comments": {
+				"edges": [{"node": {"id": "c0"}}, {"node": {"id": "c1"}}], "pageInfo": {"hasNextPage": true}}},
+			{"slug": "rust", "commentCount": 1, "comments": {
+				"edges": [{"node": {"id": "c3"}}], "pageInfo": {"hasNextPage": false}}},
+			{"slug": "zig", "commentCount": 1, "comments": {
+				"edges": [{"node": {"id": "c4"}}], "pageInfo": {"hasNextPage": false}}}
+		]}`, string(resp.Data))
+
+		assert.Equal(t, [][]string{{"go", "rust", "zig"}}, service.lists)
+		assert.Equal(t, [][]string{{"go", "rust", "zig"}}, service.counts)
+	})
+
+	t.Run("the thread of every comment is loaded in one batch", func(t *testing.T) {
+		service := newFakeService()
+		h := NewHandler(service, logging.Nop())
+
+		resp := exec(t, h, ctx, `{ comments { edges { node { thread { commentCount } } } } }`, nil)
+		require.Empty(t, resp.Errors)
+		// the same thread is only asked for once
+		assert.Equal(t, [][]string{{"go", "rust", "zig"}}, service.counts)
+	})
+
+	t.Run("pages follow on from a cursor", func(t *testing.T) {
+		h := NewHandler(newFakeService(), logging.Nop())
+		query := `query($after: String) {
+			author(name: "ann") {
+				comments(first: 2, after: $after) { edges { node { id } } pageInfo { hasNextPage endCursor } }
+			}
+		}`
+
+		var page struct {
+			Author struct {
+				Comments struct {
+					Edges []struct {
+						Node struct{ ID string }
+					}
+					PageInfo struct {
+						HasNextPage bool
+						EndCursor   *string
+					}
+				}
+			}
+		}
+		resp := exec(t, h, ctx, query, nil)
+		require.Empty(t, resp.Errors)
+		require.NoError(t, json.Unmarshal(resp.Data, &page))
+		assert.Len(t, page.Author.Comments.Edges, 2)
+		assert.True(t, page.Author.Comments.PageInfo.HasNextPage)
+
+		resp = exec(t, h, ctx, query, map[string]any{"after": *page.Author.Comments.PageInfo.EndCursor})
+		require.Empty(t, resp.Errors)
+		require.NoError(t, json.Unmarshal(resp.Data, &page))
+		require.Len(t, page.Author.Comments.Edges, 1)
+		assert.Equal(t, "c4", page.Author.Comments.Edges[0].Node.ID)
+		assert.False(t, page.Author.Comments.PageInfo.HasNextPage)
+	})
+
+	t.Run("arguments are checked", func(t *testing.T) {
+		h := NewHandler(newFakeService(), logging.Nop())
+
+		resp := exec(t, h, ctx, `{ comments(first: 500) { edges { cursor } } }`, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, CodeBadUserInput, resp.Errors[0].Extensions.Code)
+
+		resp = exec(t, h, ctx, `{ comments(after: "%%%") { edges { cursor } } }`, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, CodeBadUserInput, resp.Errors[0].Extensions.Code)
+	})
+
+	t.Run("a missing comment is null", func(t *testing.T) {
+		h := NewHandler(newFakeService(), logging.Nop())
+
+		resp := exec(t, h, ctx, `{ comment(id: "nope") { id } }`, nil)
+		require.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"comment": null}`, string(resp.Data))
+	})
+}
+
+func TestMutations(t *testing.T) {
+	h := NewHandler(newFakeService(), logging.Nop())
+	claims := auth.Claims{}
+	claims.Subject = "user-1"
+	authed := auth.WithClaims(context.Background(), claims)
+	create := `mutation { createComment(input: {slug: "go", body: "hi", author: "ann"}) { id slug } }`
+
+	t.Run("a token is required", func(t *testing.T) {
+		resp := exec(t, h, context.Background(), create, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, CodeUnauthenticated, resp.Errors[0].Extensions.Code)
+	})
+
+	t.Run("an expired token is refused", func(t *testing.T) {
+		expired := claims
+		expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second))
+		resp := exec(t, h, auth.WithClaims(context.Background(), expired), create, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, CodeUnauthenticated, resp.Errors[0].Extensions.Code)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		resp := exec(t, h, authed, create, nil)
+		require.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"createComment": {"id": "new", "slug": "go"}}`, string(resp.Data))
+	})
+
+	t.Run("input is validated", func(t *testing.T) {
+		resp := exec(t, h, authed, `mutation { createComment(input: {slug: "go", body: "", author: "ann"}) { id } }`, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, CodeBadUserInput, resp.Errors[0].Extensions.Code)
+	})
+
+	t.Run("a store outage is retryable", func(t *testing.T) {
+		resp := exec(t, h, authed, `mutation { deleteComment(id: "c0") }`, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, CodeUnavailable, resp.Errors[0].Extensions.Code)
+	})
+
+	t.Run("every mutation takes a write", func(t *testing.T) {
+		var taken int
+		ctx := ratelimit.WithWriteTaker(authed, func(context.Context) (ratelimit.Result, error) {
+			taken++
+			return ratelimit.Result{Allowed: taken == 1, RetryAfter: 1500 * time.Millisecond}, nil
+		})
+
+		resp := exec(t, h, ctx, create, nil)
+		require.Empty(t, resp.Errors)
+
+		resp = exec(t, h, ctx, create, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, CodeRateLimited, resp.Errors[0].Extensions.Code)
+		assert.Equal(t, "rate limit exceeded, retry in 2 seconds", resp.Errors[0].Message)
+		assert.Equal(t, 2, taken)
+
+		// queries are left to the read limit
+		resp = exec(t, h, ctx, `{ comment(id: "c1") { id } }`, nil)
+		require.Empty(t, resp.Errors)
+		assert.Equal(t, 2, taken)
+	})
+}
+
+func TestBadRequests(t *testing.T) {
+	h := NewHandler(newFakeService(), logging.Nop())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader([]byte("not json"))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/graphql?query={comments{edges{cursor}}}", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,101 @@
+package graphql
+
+import (
+	"context"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+)
+
+// listKey - one page of one thread's or author's comments
+type listKey struct {
+	Field comment.Field
+	Value string
+	Page  comment.Page
+}
+
+// countKey - one thread's or author's comment count
+type countKey struct {
+	Field comment.Field
+	Value string
+}
+
+// loaders - batch the per-thread and per-author lookups of one request,
+// so resolving fifty threads costs one query rather than fifty. Results
+// are only shared within a batch, a subscription sees fresh counts
+// with every event.
+type loaders struct {
+	lists  *dataloader.Loader[listKey, []comment.Comment]
+	counts *dataloader.Loader[countKey, int]
+}
+
+func newLoaders(service CommentService) *loaders {
+	return &loaders{
+		lists: dataloader.NewBatchedLoader(listBatch(service),
+			dataloader.WithClearCacheOnBatch[listKey, []comment.Comment]()),
+		counts: dataloader.NewBatchedLoader(countBatch(service),
+			dataloader.WithClearCacheOnBatch[countKey, int]()),
+	}
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+// loadersFrom - every request gets its loaders in ServeHTTP or serveSocket
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// listBatch - one ListCommentsBy per field and page, the keys of a batch
+// usually all share both
+func listBatch(service CommentService) dataloader.BatchFunc[listKey, []comment.Comment] {
+	return func(ctx context.Context, keys []listKey) []*dataloader.Result[[]comment.Comment] {
+		type group struct {
+			field comment.Field
+			page  comment.Page
+		}
+		values := map[group][]string{}
+		for _, k := range keys {
+			g := group{k.Field, k.Page}
+			values[g] = append(values[g], k.Value)
+		}
+
+		found := map[group]map[string][]comment.Comment{}
+		failed := map[group]error{}
+		for g, vs := range values {
+			found[g], failed[g] = service.ListCommentsBy(ctx, g.field, vs, g.page)
+		}
+
+		results := make([]*dataloader.Result[[]comment.Comment], len(keys))
+		for i, k := range keys {
+			g := group{k.Field, k.Page}
+			results[i] = &dataloader.Result[[]comment.Comment]{Data: found[g][k.Value], Error: failed[g]}
+		}
+		return results
+	}
+}
+
+// countBatch - one CountCommentsBy per field
+func countBatch(service CommentService) dataloader.BatchFunc[countKey, int] {
+	return func(ctx context.Context, keys []countKey) []*dataloader.Result[int] {
+		values := map[comment.Field][]string{}
+		for _, k := range keys {
+			values[k.Field] = append(values[k.Field], k.Value)
+		}
+
+		found := map[comment.Field]map[string]int{}
+		failed := map[comment.Field]error{}
+		for f, vs := range values {
+			found[f], failed[f] = service.CountCommentsBy(ctx, f, vs)
+		}
+
+		results := make([]*dataloader.Result[int], len(keys))
+		for i, k := range keys {
+			results[i] = &dataloader.Result[int]{Data: found[k.Field][k.Value], Error: failed[k.Field]}
+		}
+		return results
+	}
+}
@@ -0,0 +1,431 @@
+package graphql
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+)
+
+const (
+	// defaultFirst and maxFirst - comments per page, one more than asked
+	// for is loaded to tell whether there is a next page
+	defaultFirst = 20
+	maxFirst     = 50
+	// maxSlugs - threads one query or subscription may ask for
+	maxSlugs = 50
+)
+
+// error codes, sent as extensions.code
+const (
+	CodeBadUserInput    = "BAD_USER_INPUT"
+	CodeUnauthenticated = "UNAUTHENTICATED"
+	CodeNotFound        = "NOT_FOUND"
+	CodeUnavailable     = "UNAVAILABLE"
+	CodeInternal        = "INTERNAL"
+	CodeRateLimited     = "RATE_LIMITED"
+)
+
+// Error - a resolver error whose message is fit for clients
+type Error struct {
+	Message string
+	Code    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Extensions - picked up by graphql-go for the error's extensions
+func (e *Error) Extensions() map[string]any {
+	return map[string]any{"code": e.Code}
+}
+
+func badInput(format string, args ...any) error {
+	return &Error{Message: fmt.Sprintf(format, args...), Code: CodeBadUserInput}
+}
+
+// resolver - the root of the schema, for queries, mutations and subscriptions
+type resolver struct {
+	service CommentService
+	broker  *stream.Broker
+	log     logging.Logger
+}
+
+// fail - logs err and returns what the client gets to see of it, the
+// same messages the REST API uses
+func (r *resolver) fail(ctx context.Context, err error, msg string) error {
+	r.log.WithError(err).Error(ctx, msg)
+	switch {
+	case errors.Is(err, comment.ErrUnavailable):
+		return &Error{Message: "the database is unavailable, try again shortly", Code: CodeUnavailable}
+	case errors.Is(err, comment.ErrNotFound):
+		return &Error{Message: "not found for the given id", Code: CodeNotFound}
+	default:
+		return &Error{Message: "something went wrong while processing the request", Code: CodeInternal}
+	}
+}
+
+// requireAuth - mutations need the claims put there by OptionalAuth
+func requireAuth(ctx context.Context) error {
+	claims, ok := auth.ClaimsFromContext(ctx)
+	if !ok {
+		return &Error{Message: "a bearer token is required", Code: CodeUnauthenticated}
+	}
+	// a socket outlives the token it was opened with, until it is closed
+	// for that a mutation may still come in
+	if claims.ExpiresAt != nil && !time.Now().Before(claims.ExpiresAt.Time) {
+		return &Error{Message: "the bearer token has expired", Code: CodeUnauthenticated}
+	}
+	return nil
+}
+
+// takeWrite - charges a mutation to the write limit of whatever the
+// handler is mounted behind, see ratelimit.TakeWrite
+func (r *resolver) takeWrite(ctx context.Context) error {
+	res, err := ratelimit.TakeWrite(ctx)
+	if err != nil {
+		// fail open, like the REST routes
+		r.log.WithError(err).Warn(ctx, "rate limiter unavailable")
+		return nil
+	}
+	if !res.Allowed {
+		return &Error{
+			Message: fmt.Sprintf("rate limit exceeded, retry in %d seconds", int(math.Ceil(res.RetryAfter.Seconds()))),
+			Code:    CodeRateLimited,
+		}
+	}
+	return nil
+}
+
+// pageArgs - the arguments of every connection field
+type pageArgs struct {
+	First *int32
+	After *string
+}
+
+// page - what the store is asked for, one more than requested
+func (a pageArgs) page() (comment.Page, error) {
+	first := int32(defaultFirst)
+	if a.First != nil {
+		first = *a.First
+	}
+	if first < 1 || first > maxFirst {
+		return comment.Page{}, badInput("first must be between 1 and %d", maxFirst)
+	}
+
+	p := comment.Page{Limit: int(first) + 1}
+	if a.After != nil {
+		id, err := base64.RawURLEncoding.DecodeString(*a.After)
+		if err != nil || len(id) == 0 {
+			return comment.Page{}, badInput("after is not a cursor from this API")
+		}
+		p.After = string(id)
+	}
+	return p, nil
+}
+
+func cursor(c comment.Comment) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.ID))
+}
+
+func checkSlugs(slugs []string) error {
+	if len(slugs) == 0 || len(slugs) > maxSlugs {
+		return badInput("between 1 and %d slugs are allowed", maxSlugs)
+	}
+	return nil
+}
+
+func (r *resolver) Comment(ctx context.Context, args struct{ ID graphql.ID }) (*commentResolver, error) {
+	c, err := r.service.GetComment(ctx, string(args.ID))
+	if errors.Is(err, comment.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, r.fail(ctx, err, "failed to get comment from service layer")
+	}
+	return &commentResolver{r, c}, nil
+}
+
+func (r *resolver) Comments(ctx context.Context, args pageArgs) (*connectionResolver, error) {
+	page, err := args.page()
+	if err != nil {
+		return nil, err
+	}
+
+	cmts, err := r.service.ListComments(ctx, page)
+	if err != nil {
+		return nil, r.fail(ctx, err, "failed to list comments")
+	}
+	return newConnection(r, cmts, page), nil
+}
+
+func (r *resolver) Thread(args struct{ Slug string }) *threadResolver {
+	return &threadResolver{group{r, comment.BySlug, args.Slug}}
+}
+
+func (r *resolver) Threads(args struct{ Slugs []string }) ([]*threadResolver, error) {
+	if err := checkSlugs(args.Slugs); err != nil {
+		return nil, err
+	}
+
+	threads := make([]*threadResolver, 0, len(args.Slugs))
+	for _, slug := range args.Slugs {
+		threads = append(threads, r.Thread(struct{ Slug string }{slug}))
+	}
+	return threads, nil
+}
+
+func (r *resolver) Author(args struct{ Name string }) *authorResolver {
+	return &authorResolver{group{r, comment.ByAuthor, args.Name}}
+}
+
+// validate - shared, it caches what it learns about commentInput
+var validate = validator.New()
+
+// commentInput - the limits match PostCommentRequest and the checks
+// on the comments table
+type commentInput struct {
+	Slug   string `validate:"required,max=255"`
+	Body   string `validate:"required,max=10000"`
+	Author string `validate:"required,max=255"`
+}
+
+func (in commentInput) comment() (comment.Comment, error) {
+	if err := validate.Struct(in); err != nil {
+		return comment.Comment{}, badInput("slug, body and author are required")
+	}
+	return comment.Comment{Slug: in.Slug, Body: in.Body, Author: in.Author}, nil
+}
+
+func (r *resolver) CreateComment(ctx context.Context, args struct{ Input commentInput }) (*commentResolver, error) {
+	if err := requireAuth(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.takeWrite(ctx); err != nil {
+		return nil, err
+	}
+	c, err := args.Input.comment()
+	if err != nil {
+		return nil, err
+	}
+
+	posted, err := r.service.PostComment(ctx, c)
+	if err != nil {
+		return nil, r.fail(ctx, err, "failed to post comment")
+	}
+	return &commentResolver{r, posted}, nil
+}
+
+func (r *resolver) UpdateComment(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input commentInput
+}) (*commentResolver, error) {
+	if err := requireAuth(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.takeWrite(ctx); err != nil {
+		return nil, err
+	}
+	c, err := args.Input.comment()
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := r.service.UpdateComment(ctx, string(args.ID), c)
+	if err != nil {
+		return nil, r.fail(ctx, err, "failed to update comment")
+	}
+	return &commentResolver{r, updated}, nil
+}
+
+func (r *resolver) DeleteComment(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	if err := requireAuth(ctx); err != nil {
+		return "", err
+	}
+	if err := r.takeWrite(ctx); err != nil {
+		return "", err
+	}
+
+	if err := r.service.DeleteComment(ctx, string(args.ID)); err != nil {
+		return "", r.fail(ctx, err, "failed to delete comment")
+	}
+	return args.ID, nil
+}
+
+// CommentAdded - fed by the same broker as the SSE stream and the
+// websocket. A subscriber that falls too far behind is dropped by the
+// broker and its subscription completes, it should reload and subscribe again.
+func (r *resolver) CommentAdded(ctx context.Context, args struct{ Slugs []string }) (<-chan *commentResolver, error) {
+	if r.broker == nil {
+		return nil, &Error{Message: "subscriptions are not available", Code: CodeInternal}
+	}
+	if err := checkSlugs(args.Slugs); err != nil {
+		return nil, err
+	}
+
+	sub, _, _ := r.broker.Subscribe(0, args.Slugs...)
+	added := make(chan *commentResolver)
+	go func() {
+		defer close(added)
+		defer sub.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				if e.Type != comment.EventCommentCreated {
+					continue
+				}
+				select {
+				case added <- &commentResolver{r, e.Comment}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return added, nil
+}
+
+type commentResolver struct {
+	r *resolver
+	c comment.Comment
+}
+
+func (c *commentResolver) ID() graphql.ID {
+	return graphql.ID(c.c.ID)
+}
+
+func (c *commentResolver) Slug() string {
+	return c.c.Slug
+}
+
+func (c *commentResolver) Body() string {
+	return c.c.Body
+}
+
+func (c *commentResolver) Author() string {
+	return c.c.Author
+}
+
+func (c *commentResolver) Thread() *threadResolver {
+	return c.r.Thread(struct{ Slug string }{c.c.Slug})
+}
+
+// group - what threads and authors have in common, their comments
+// go through the request's loaders
+type group struct {
+	r     *resolver
+	field comment.Field
+	value string
+}
+
+func (g group) CommentCount(ctx context.Context) (int32, error) {
+	n, err := loadersFrom(ctx).counts.Load(ctx, countKey{g.field, g.value})()
+	if err != nil {
+		return 0, g.r.fail(ctx, err, "failed to count comments")
+	}
+	return int32(n), nil
+}
+
+func (g group) Comments(ctx context.Context, args pageArgs) (*connectionResolver, error) {
+	page, err := args.page()
+	if err != nil {
+		return nil, err
+	}
+
+	cmts, err := loadersFrom(ctx).lists.Load(ctx, listKey{g.field, g.value, page})()
+	if err != nil {
+		return nil, g.r.fail(ctx, err, "failed to list comments")
+	}
+	return newConnection(g.r, cmts, page), nil
+}
+
+type threadResolver struct {
+	group
+}
+
+func (t *threadResolver) Slug() string {
+	return t.value
+}
+
+type authorResolver struct {
+	group
+}
+
+func (a *authorResolver) Name() string {
+	return a.value
+}
+
+type connectionResolver struct {
+	r       *resolver
+	cmts    []comment.Comment
+	hasNext bool
+}
+
+// newConnection - cmts was loaded with page, one longer than asked for
+// when there are more
+func newConnection(r *resolver, cmts []comment.Comment, page comment.Page) *connectionResolver {
+	conn := &connectionResolver{r: r, cmts: cmts}
+	if len(cmts) >= page.Limit {
+		conn.cmts, conn.hasNext = cmts[:page.Limit-1], true
+	}
+	return conn
+}
+
+func (c *connectionResolver) Edges() []*edgeResolver {
+	edges := make([]*edgeResolver, 0, len(c.cmts))
+	for _, cmt := range c.cmts {
+		edges = append(edges, &edgeResolver{&commentResolver{c.r, cmt}})
+	}
+	return edges
+}
+
+func (c *connectionResolver) PageInfo() *pageInfoResolver {
+	info := &pageInfoResolver{hasNext: c.hasNext}
+	if len(c.cmts) > 0 {
+		end := cursor(c.cmts[len(c.cmts)-1])
+		info.endCursor = &end
+	}
+	return info
+}
+
+type edgeResolver struct {
+	node *commentResolver
+}
+
+func (e *edgeResolver) Cursor() string {
+	return cursor(e.node.c)
+}
+
+func (e *edgeResolver) Node() *commentResolver {
+	return e.node
+}
+
+type pageInfoResolver struct {
+	hasNext   bool
+	endCursor *string
+}
+
+func (p *pageInfoResolver) HasNextPage() bool {
+	return p.hasNext
+}
+
+func (p *pageInfoResolver) EndCursor() *string {
+	return p.endCursor
+}
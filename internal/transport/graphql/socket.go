@@ -0,0 +1,356 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+)
+
+// Protocol - the websocket subprotocol spoken, see
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const Protocol = "graphql-transport-ws"
+
+// message types of the protocol
+const (
+	MessageConnectionInit = "connection_init"
+	MessageConnectionAck  = "connection_ack"
+	MessagePing           = "ping"
+	MessagePong           = "pong"
+	MessageSubscribe      = "subscribe"
+	MessageNext           = "next"
+	MessageError          = "error"
+	MessageComplete       = "complete"
+)
+
+// close codes of the protocol
+const (
+	closeBadRequest         = 4400
+	closeUnauthorized       = 4401
+	closeNotAcceptable      = 4406
+	closeInitTimeout        = 4408
+	closeSubscriberExists   = 4409
+	closeTooManyInitRequest = 4429
+)
+
+const (
+	// socketInitTimeout - how long a client has for connection_init
+	socketInitTimeout = 10 * time.Second
+	// socketSendBuffer - messages queued for a client before operations
+	// wait for it to catch up
+	socketSendBuffer = 16
+)
+
+// upgrader - CheckOrigin is left nil, so only pages from our own origin
+// can open a socket with a user's token
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{Protocol},
+}
+
+// Message - one protocol message, in either direction
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// serveSocket - runs any operation, though it is meant for
+// subscriptions. The caller's claims come from the handshake, the socket
+// is closed with 4401 when their token expires.
+func (h *Handler) serveSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has answered the request already
+		return
+	}
+	defer conn.Close()
+
+	if conn.Subprotocol() != Protocol {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(closeNotAcceptable, "Subprotocol not acceptable"), time.Now().Add(time.Second))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(withLoaders(r.Context(), newLoaders(h.Service)))
+	s := &socket{
+		h:          h,
+		ctx:        ctx,
+		conn:       conn,
+		ops:        map[string]*operation{},
+		send:       make(chan Message, socketSendBuffer),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.ExpiresAt != nil {
+		s.expires = claims.ExpiresAt.Time
+	}
+
+	go s.writeLoop()
+	s.readLoop()
+
+	cancel()
+	close(s.done)
+	<-s.writerDone
+	s.running.Wait()
+}
+
+// socket - one connection. readLoop runs on the request goroutine,
+// writeLoop is the only one writing messages to conn, every operation
+// has a goroutine of its own.
+type socket struct {
+	h    *Handler
+	ctx  context.Context
+	conn *websocket.Conn
+	// expires - when the token of the handshake runs out and the
+	// connection with it, zero without one
+	expires time.Time
+
+	// acked - only touched by readLoop
+	acked bool
+
+	mu  sync.Mutex
+	ops map[string]*operation
+	// running - the operation goroutines
+	running sync.WaitGroup
+
+	send chan Message
+	// done - readLoop is over, writerDone - writeLoop is
+	done       chan struct{}
+	writerDone chan struct{}
+}
+
+// deadline - how long a peer gets to answer a ping or take a write
+func (s *socket) deadline() time.Time {
+	return time.Now().Add(2 * s.h.Heartbeat)
+}
+
+// closeWith - safe to call next to writeLoop, the close is a control frame
+func (s *socket) closeWith(code int, text string) {
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
+
+func (s *socket) readLoop() {
+	s.conn.SetReadLimit(maxRequestBytes)
+	// replaces whatever read timeout the server had set before the hijack
+	s.conn.SetReadDeadline(time.Now().Add(socketInitTimeout))
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if !s.acked && errors.As(err, &netErr) && netErr.Timeout() {
+				s.closeWith(closeInitTimeout, "Connection initialisation timeout")
+			}
+			return
+		}
+		if s.acked {
+			s.conn.SetReadDeadline(s.deadline())
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.closeWith(closeBadRequest, "Invalid message received")
+			return
+		}
+		if !s.handle(msg) {
+			return
+		}
+	}
+}
+
+// handle - false ends the connection
+func (s *socket) handle(msg Message) bool {
+	switch msg.Type {
+	case MessageConnectionInit:
+		if s.acked {
+			s.closeWith(closeTooManyInitRequest, "Too many initialisation requests")
+			return false
+		}
+		s.acked = true
+		s.conn.SetReadDeadline(s.deadline())
+		s.conn.SetPongHandler(func(string) error {
+			return s.conn.SetReadDeadline(s.deadline())
+		})
+		return s.write(Message{Type: MessageConnectionAck})
+
+	case MessagePing:
+		return s.write(Message{Type: MessagePong})
+
+	case MessagePong:
+		return true
+
+	case MessageSubscribe:
+		if !s.acked {
+			s.closeWith(closeUnauthorized, "Unauthorized")
+			return false
+		}
+		var req Request
+		if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil || req.Query == "" {
+			s.closeWith(closeBadRequest, "Invalid message received")
+			return false
+		}
+		return s.start(msg.ID, req)
+
+	case MessageComplete:
+		s.mu.Lock()
+		if op, ok := s.ops[msg.ID]; ok {
+			delete(s.ops, msg.ID)
+			op.cancel()
+		}
+		s.mu.Unlock()
+		return true
+
+	default:
+		s.closeWith(closeBadRequest, "Invalid message received")
+		return false
+	}
+}
+
+// start - runs req in the background, its results are sent as next
+// messages and a complete once it is over
+func (s *socket) start(id string, req Request) bool {
+	s.mu.Lock()
+	if _, ok := s.ops[id]; ok {
+		s.mu.Unlock()
+		s.closeWith(closeSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", id))
+		return false
+	}
+	if len(s.ops) >= s.h.MaxSubscriptions {
+		s.mu.Unlock()
+		return s.writeErrors(id, fmt.Sprintf("at most %d operations per connection", s.h.MaxSubscriptions))
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	op := &operation{cancel: cancel}
+	s.ops[id] = op
+	s.mu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer cancel()
+
+		responses, err := s.h.Schema.Subscribe(ctx, req.Query, req.OperationName, req.Variables)
+		if err != nil {
+			s.finish(id, op, func() { s.writeErrors(id, err.Error()) })
+			return
+		}
+
+		first := true
+		for r := range responses {
+			resp := r.(*graphql.Response)
+			// a query that never got to run is an error message, not a result
+			if first && resp.Data == nil && len(resp.Errors) > 0 {
+				s.finish(id, op, func() { s.write(Message{ID: id, Type: MessageError, Payload: mustMarshal(resp.Errors)}) })
+				return
+			}
+			first = false
+
+			if !s.write(Message{ID: id, Type: MessageNext, Payload: mustMarshal(resp)}) {
+				return
+			}
+		}
+
+		s.finish(id, op, func() { s.write(Message{ID: id, Type: MessageComplete}) })
+	}()
+	return true
+}
+
+// operation - a running subscribe, ids can be reused once it is over
+type operation struct {
+	cancel context.CancelFunc
+}
+
+// finish - forgets op and runs last, unless the client has completed
+// it already and so wants nothing more about it
+func (s *socket) finish(id string, op *operation, last func()) {
+	s.mu.Lock()
+	ok := s.ops[id] == op
+	if ok {
+		delete(s.ops, id)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		last()
+	}
+}
+
+// write - queues msg for the writer, waiting while the client is behind.
+// False means the writer is gone and the connection with it.
+func (s *socket) write(msg Message) bool {
+	select {
+	case s.send <- msg:
+		return true
+	case <-s.writerDone:
+		return false
+	}
+}
+
+func (s *socket) writeErrors(id, msg string) bool {
+	return s.write(Message{ID: id, Type: MessageError, Payload: mustMarshal([]*gqlerrors.QueryError{{
+		Message:    msg,
+		Extensions: map[string]any{"code": CodeBadUserInput},
+	}})})
+}
+
+func (s *socket) writeLoop() {
+	defer close(s.writerDone)
+	// wakes up a readLoop still waiting on the client
+	defer s.conn.Close()
+
+	ping := time.NewTicker(s.h.Heartbeat)
+	defer ping.Stop()
+
+	var expired <-chan time.Time
+	if !s.expires.IsZero() {
+		t := time.NewTimer(time.Until(s.expires))
+		defer t.Stop()
+		expired = t.C
+	}
+
+	for {
+		var msg Message
+		select {
+		case <-s.done:
+			return
+		case <-s.h.closing:
+			s.closeWith(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-expired:
+			// clients reconnect with a fresh token
+			s.closeWith(closeUnauthorized, "Token expired")
+			return
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, s.deadline()); err != nil {
+				return
+			}
+			continue
+		case msg = <-s.send:
+		}
+
+		s.conn.SetWriteDeadline(s.deadline())
+		if err := s.conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// mustMarshal - for values that always marshal
+func mustMarshal(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
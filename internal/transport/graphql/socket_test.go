@@ -0,0 +1,167 @@
+package graphql
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptions(t *testing.T) {
+	broker := stream.NewBroker(stream.Options{})
+	h := NewHandler(newFakeService(), logging.Nop(),
+		WithBroker(broker),
+		WithMaxSubscriptions(1),
+	)
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	dial := func(t *testing.T) *websocket.Conn {
+		dialer := websocket.Dialer{Subprotocols: []string{Protocol}}
+		conn, resp, err := dialer.Dial(url, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	send := func(t *testing.T, conn *websocket.Conn, msg Message) {
+		require.NoError(t, conn.WriteJSON(msg))
+	}
+	receive := func(t *testing.T, conn *websocket.Conn) Message {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg Message
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+	subscribe := func(id string) Message {
+		payload, _ := json.Marshal(Request{Query: `subscription { commentAdded(slugs: ["go"]) { id body thread { commentCount } } }`})
+		return Message{ID: id, Type: MessageSubscribe, Payload: payload}
+	}
+
+	// subscriptions start in the background, publishUntilReceived
+	// keeps publishing until one of them has picked something up
+	publishUntilReceived := func(t *testing.T, conn *websocket.Conn) Message {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			tick := time.NewTicker(10 * time.Millisecond)
+			defer tick.Stop()
+			for {
+				// other slugs and other changes are left out
+				broker.Publish(comment.Change{Type: comment.EventCommentCreated, Comment: comment.Comment{ID: "r", Slug: "rust"}})
+				broker.Publish(comment.Change{Type: comment.EventCommentDeleted, Comment: comment.Comment{ID: "c0", Slug: "go"}})
+				broker.Publish(comment.Change{Type: comment.EventCommentCreated, Comment: comment.Comment{ID: "c9", Slug: "go", Body: "hi"}})
+				select {
+				case <-stop:
+					return
+				case <-tick.C:
+				}
+			}
+		}()
+		return receive(t, conn)
+	}
+
+	t.Run("subscribing needs connection_init first", func(t *testing.T) {
+		conn := dial(t)
+		send(t, conn, subscribe("1"))
+
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, closeUnauthorized), err)
+	})
+
+	t.Run("new comments are delivered", func(t *testing.T) {
+		conn := dial(t)
+		send(t, conn, Message{Type: MessageConnectionInit})
+		assert.Equal(t, MessageConnectionAck, receive(t, conn).Type)
+
+		send(t, conn, subscribe("1"))
+		// the subscription is running once a second one is turned away
+		send(t, conn, subscribe("2"))
+		refused := receive(t, conn)
+		assert.Equal(t, Message{ID: "2", Type: MessageError}, Message{ID: refused.ID, Type: refused.Type})
+
+		next := publishUntilReceived(t, conn)
+		assert.Equal(t, "1", next.ID)
+		assert.Equal(t, MessageNext, next.Type)
+		assert.JSONEq(t, `{"data": {"commentAdded": {"id": "c9", "body": "hi", "thread": {"commentCount": 3}}}}`, string(next.Payload))
+
+		send(t, conn, Message{Type: MessagePing})
+		for msg := receive(t, conn); msg.Type != MessagePong; msg = receive(t, conn) {
+			assert.Equal(t, MessageNext, msg.Type)
+		}
+	})
+
+	t.Run("completed subscriptions free their slot", func(t *testing.T) {
+		conn := dial(t)
+		send(t, conn, Message{Type: MessageConnectionInit})
+		receive(t, conn)
+
+		// messages are handled in order, the slot is free by the time "2" comes
+		send(t, conn, subscribe("1"))
+		send(t, conn, Message{ID: "1", Type: MessageComplete})
+		send(t, conn, subscribe("2"))
+
+		next := publishUntilReceived(t, conn)
+		assert.Equal(t, "2", next.ID)
+		assert.Equal(t, MessageNext, next.Type)
+	})
+
+	t.Run("invalid queries get an error", func(t *testing.T) {
+		conn := dial(t)
+		send(t, conn, Message{Type: MessageConnectionInit})
+		receive(t, conn)
+
+		payload, _ := json.Marshal(Request{Query: `subscription { nope }`})
+		send(t, conn, Message{ID: "1", Type: MessageSubscribe, Payload: payload})
+		assert.Equal(t, MessageError, receive(t, conn).Type)
+	})
+
+	t.Run("shutdown closes the socket", func(t *testing.T) {
+		conn := dial(t)
+		h.Shutdown()
+
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+	})
+}
+
+func TestSocketTokenExpiry(t *testing.T) {
+	h := NewHandler(newFakeService(), logging.Nop())
+
+	// what OptionalAuth leaves for a token that runs out in a second
+	claims := auth.Claims{}
+	claims.Subject = "user-1"
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Second))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	}))
+	t.Cleanup(srv.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{Protocol}}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	t.Cleanup(func() { conn.Close() })
+
+	require.NoError(t, conn.WriteJSON(Message{Type: MessageConnectionInit}))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var ack Message
+	require.NoError(t, conn.ReadJSON(&ack))
+	assert.Equal(t, MessageConnectionAck, ack.Type)
+
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, closeUnauthorized), err)
+}
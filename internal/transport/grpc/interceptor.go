@@ -0,0 +1,188 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	commentv1 "github.com/ridwanulhoquejr/go-rest-api-v2/api/comment/v1"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey - metadata keys are lower case
+const requestIDKey = "x-request-id"
+
+// public - the methods open to anonymous callers: health checks,
+// reflection and the reads that are open on the REST API too. Every
+// other method needs an access token, including ones added later.
+var public = map[string]bool{
+	healthpb.Health_Check_FullMethodName:                                   true,
+	healthpb.Health_Watch_FullMethodName:                                   true,
+	reflectionv1.ServerReflection_ServerReflectionInfo_FullMethodName:      true,
+	reflectionv1alpha.ServerReflection_ServerReflectionInfo_FullMethodName: true,
+	commentv1.CommentService_GetComment_FullMethodName:                     true,
+	commentv1.CommentService_ListComments_FullMethodName:                   true,
+}
+
+// requestContextInterceptor - RequestIDMiddleware and
+// AuditContextMiddleware in one: reuses the caller's x-request-id when
+// it is sane, sends it back as a header and records where the call came from
+func requestContextInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var id string
+	if ids := md.Get(requestIDKey); len(ids) == 1 && requestid.Valid(ids[0]) {
+		id = ids[0]
+	} else {
+		id = requestid.New()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+	ctx = requestid.WithContext(ctx, id)
+
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = audit.RemoteIP(p.Addr.String())
+	}
+	ctx = audit.WithRequestInfo(ctx, audit.RequestInfo{RemoteAddr: remote, RequestID: id})
+
+	return handler(ctx, req)
+}
+
+// loggingInterceptor - one access log entry per call, like
+// LoggingMiddleware. A panicking handler is logged and answered with
+// Internal instead of taking the process down.
+func (s *Server) loggingInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (resp any, err error) {
+	start := time.Now()
+	// authInterceptor runs further down, it reports the caller back here
+	ctx, principal := withPrincipalHolder(ctx)
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.Log.WithFields(logging.Fields{"panic": p}).Error(ctx, "grpc handler panicked")
+			resp, err = nil, status.Error(codes.Internal, "something went wrong while processing the request")
+		}
+
+		subject := principal.subject
+		if subject == "" {
+			subject = "anonymous"
+		}
+		code := status.Code(err)
+
+		entry := s.Log.WithFields(logging.Fields{
+			"method":      info.FullMethod,
+			"code":        code.String(),
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_ip":   audit.RequestInfoFromContext(ctx).RemoteAddr,
+			"principal":   subject,
+		})
+		switch code {
+		case codes.OK:
+			entry.Info(ctx, "handled call")
+		case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss:
+			entry.Error(ctx, "handled call")
+		default:
+			entry.Warn(ctx, "handled call")
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// authInterceptor - JWTAuth for every method that isn't public
+func (s *Server) authInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamAuthInterceptor - the same for streaming methods
+func (s *Server) streamAuthInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate - exactly one `authorization: Bearer <token>`, validated
+// by the same auth.Service, unless method is public
+func (s *Server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if public[method] {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	token, err := auth.BearerToken(md.Get("authorization"))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "a bearer token is required")
+	}
+
+	// validation also checks the jti against the revocation denylist
+	claims, err := s.Auth.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+	}
+	setPrincipal(ctx, claims.Subject)
+
+	return auth.WithClaims(ctx, claims), nil
+}
+
+// authenticatedStream - hands the claims on to a streaming handler
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// principalHolder - see loggingInterceptor
+type principalHolder struct {
+	subject string
+}
+
+type principalKey struct{}
+
+func withPrincipalHolder(ctx context.Context) (context.Context, *principalHolder) {
+	p := &principalHolder{}
+	return context.WithValue(ctx, principalKey{}, p), p
+}
+
+func setPrincipal(ctx context.Context, subject string) {
+	if p, ok := ctx.Value(principalKey{}).(*principalHolder); ok {
+		p.subject = subject
+	}
+}
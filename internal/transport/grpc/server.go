@@ -0,0 +1,319 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	commentv1 "github.com/ridwanulhoquejr/go-rest-api-v2/api/comment/v1"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	apphealth "github.com/ridwanulhoquejr/go-rest-api-v2/internal/health"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// CommentService - the same comment.Service the HTTP handlers use
+type CommentService interface {
+	GetComment(ctx context.Context, ID string) (comment.Comment, error)
+	PostComment(context.Context, comment.Comment) (comment.Comment, error)
+	UpdateComment(ctx context.Context, ID string, newCmt comment.Comment) (comment.Comment, error)
+	DeleteComment(ctx context.Context, ID string) error
+	GetMultipleComment(ctx context.Context) ([]comment.Comment, error)
+}
+
+type AuthService interface {
+	ValidateToken(ctx context.Context, accessToken string) (auth.Claims, error)
+}
+
+// Server - serves commentv1.CommentService, the health checking
+// protocol and server reflection on one port
+type Server struct {
+	commentv1.UnimplementedCommentServiceServer
+
+	Service CommentService
+	Auth    AuthService
+	Log     logging.Logger
+	Health  *health.Server
+	GRPC    *grpc.Server
+	Addr    string
+
+	// ShutdownTimeout - how long in-flight calls get before they are cut off
+	ShutdownTimeout time.Duration
+
+	// Readiness - optional, the checks behind /readyz. Health reports
+	// NOT_SERVING while they fail and as soon as draining starts.
+	Readiness *apphealth.Registry
+	// ReadinessInterval - how often Readiness is checked
+	ReadinessInterval time.Duration
+
+	lis net.Listener
+}
+
+// Option - optional Server settings
+type Option func(*Server)
+
+// WithAddr - the address the gRPC server listens on, :50051 by default
+func WithAddr(addr string) Option {
+	return func(s *Server) {
+		s.Addr = addr
+	}
+}
+
+// WithShutdownTimeout - see Server.ShutdownTimeout
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.ShutdownTimeout = d
+	}
+}
+
+// WithReadiness - see Server.Readiness
+func WithReadiness(r *apphealth.Registry, interval time.Duration) Option {
+	return func(s *Server) {
+		s.Readiness = r
+		s.ReadinessInterval = interval
+	}
+}
+
+func NewServer(
+	service CommentService,
+	authService AuthService,
+	logger logging.Logger,
+	opts ...Option,
+) *Server {
+	s := &Server{
+		Service:         service,
+		Auth:            authService,
+		Log:             logger.WithFields(logging.Fields{"component": "grpc"}),
+		Health:          health.NewServer(),
+		Addr:            ":50051",
+		ShutdownTimeout: 15 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// the same order as the HTTP middleware: tracing, request id and
+	// origin, the access log, then authentication
+	s.GRPC = grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(
+			requestContextInterceptor,
+			s.loggingInterceptor,
+			s.authInterceptor,
+		),
+		grpc.ChainStreamInterceptor(s.streamAuthInterceptor),
+	)
+	commentv1.RegisterCommentServiceServer(s.GRPC, s)
+	healthpb.RegisterHealthServer(s.GRPC, s.Health)
+	reflection.Register(s.GRPC)
+
+	if s.Readiness != nil {
+		if s.ReadinessInterval <= 0 {
+			s.ReadinessInterval = 5 * time.Second
+		}
+		// not serving until the first check says otherwise
+		s.Health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		s.Readiness.OnDraining(func(draining bool) {
+			// clearing it waits for the next check
+			if draining {
+				s.Health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			}
+		})
+	}
+
+	return s
+}
+
+// Listen - binds Addr, so a port that is taken fails startup instead of
+// a goroutine further down
+func (s *Server) Listen() error {
+	lis, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening for grpc on %s: %w", s.Addr, err)
+	}
+	s.lis = lis
+	return nil
+}
+
+// Run - serves until ctx is done, then stops gracefully. It listens
+// itself unless Listen was called first. The error is why it couldn't
+// listen or stopped serving before ctx was done.
+func (s *Server) Run(ctx context.Context) error {
+	if s.lis == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.GRPC.Serve(s.lis)
+	}()
+	if s.Readiness != nil {
+		go s.watchReadiness(ctx)
+	}
+
+	select {
+	case <-ctx.Done():
+	case err := <-done:
+		return err
+	}
+
+	// health checks fail first so clients move on to other replicas
+	s.Health.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.GRPC.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(s.ShutdownTimeout):
+		s.GRPC.Stop()
+	}
+	<-done
+	s.Log.Info(context.Background(), "grpc server stopped gracefully")
+	return nil
+}
+
+// watchReadiness - keeps Health in line with Readiness until ctx is done
+func (s *Server) watchReadiness(ctx context.Context) {
+	tick := time.NewTicker(s.ReadinessInterval)
+	defer tick.Stop()
+
+	for {
+		st := healthpb.HealthCheckResponse_NOT_SERVING
+		if s.Readiness.Readiness(ctx).Healthy() {
+			st = healthpb.HealthCheckResponse_SERVING
+		}
+		// ignored once Run has shut Health down
+		s.Health.SetServingStatus("", st)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// validate - shared, it caches what it learns about commentRequest
+var validate = validator.New()
+
+// commentRequest - the limits match PostCommentRequest and the checks
+// on the comments table
+type commentRequest struct {
+	Slug   string `validate:"required,max=255"`
+	Body   string `validate:"required,max=10000"`
+	Author string `validate:"required,max=255"`
+}
+
+func validateComment(slug, body, author string) error {
+	if err := validate.Struct(commentRequest{Slug: slug, Body: body, Author: author}); err != nil {
+		return status.Error(codes.InvalidArgument, "slug, body and author are required")
+	}
+	return nil
+}
+
+// serviceError - a store outage is Unavailable so clients retry, a
+// missing comment NotFound, anything else fallback
+func serviceError(err error, fallback codes.Code) error {
+	switch {
+	case errors.Is(err, comment.ErrUnavailable):
+		return status.Error(codes.Unavailable, "the database is unavailable, try again shortly")
+	case errors.Is(err, comment.ErrNotFound), fallback == codes.NotFound:
+		return status.Error(codes.NotFound, "not found for the given id")
+	default:
+		return status.Error(fallback, "something went wrong while processing the request")
+	}
+}
+
+func toProto(c comment.Comment) *commentv1.Comment {
+	return &commentv1.Comment{Id: c.ID, Slug: c.Slug, Body: c.Body, Author: c.Author}
+}
+
+func (s *Server) GetComment(ctx context.Context, req *commentv1.GetCommentRequest) (*commentv1.Comment, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	c, err := s.Service.GetComment(ctx, req.GetId())
+	if err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to get comment from service layer")
+		return nil, serviceError(err, codes.NotFound)
+	}
+	return toProto(c), nil
+}
+
+func (s *Server) ListComments(ctx context.Context, _ *commentv1.ListCommentsRequest) (*commentv1.ListCommentsResponse, error) {
+	cmts, err := s.Service.GetMultipleComment(ctx)
+	if err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to get multiple comments from service layer")
+		return nil, serviceError(err, codes.Internal)
+	}
+
+	resp := &commentv1.ListCommentsResponse{Comments: make([]*commentv1.Comment, 0, len(cmts))}
+	for _, c := range cmts {
+		resp.Comments = append(resp.Comments, toProto(c))
+	}
+	return resp, nil
+}
+
+func (s *Server) CreateComment(ctx context.Context, req *commentv1.CreateCommentRequest) (*commentv1.Comment, error) {
+	if err := validateComment(req.GetSlug(), req.GetBody(), req.GetAuthor()); err != nil {
+		return nil, err
+	}
+
+	c, err := s.Service.PostComment(ctx, comment.Comment{
+		Slug:   req.GetSlug(),
+		Body:   req.GetBody(),
+		Author: req.GetAuthor(),
+	})
+	if err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to post comment")
+		return nil, serviceError(err, codes.Internal)
+	}
+	return toProto(c), nil
+}
+
+func (s *Server) UpdateComment(ctx context.Context, req *commentv1.UpdateCommentRequest) (*commentv1.Comment, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if err := validateComment(req.GetSlug(), req.GetBody(), req.GetAuthor()); err != nil {
+		return nil, err
+	}
+
+	c, err := s.Service.UpdateComment(ctx, req.GetId(), comment.Comment{
+		Slug:   req.GetSlug(),
+		Body:   req.GetBody(),
+		Author: req.GetAuthor(),
+	})
+	if err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to update comment")
+		return nil, serviceError(err, codes.Internal)
+	}
+	return toProto(c), nil
+}
+
+func (s *Server) DeleteComment(ctx context.Context, req *commentv1.DeleteCommentRequest) (*commentv1.DeleteCommentResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	if err := s.Service.DeleteComment(ctx, req.GetId()); err != nil {
+		s.Log.WithError(err).Error(ctx, "failed to delete comment")
+		return nil, serviceError(err, codes.Internal)
+	}
+	return &commentv1.DeleteCommentResponse{}, nil
+}
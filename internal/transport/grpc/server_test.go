@@ -0,0 +1,230 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	commentv1 "github.com/ridwanulhoquejr/go-rest-api-v2/api/comment/v1"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	apphealth "github.com/ridwanulhoquejr/go-rest-api-v2/internal/health"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeAuth - "good" is the only valid token
+type fakeAuth struct{}
+
+func (fakeAuth) ValidateToken(_ context.Context, token string) (auth.Claims, error) {
+	if token != "good" {
+		return auth.Claims{}, errors.New("invalid token")
+	}
+	claims := auth.Claims{}
+	claims.Subject = "user-1"
+	return claims, nil
+}
+
+// fakeService - remembers what the last call saw in its context
+type fakeService struct {
+	CommentService
+	comments map[string]comment.Comment
+	claims   auth.Claims
+	info     audit.RequestInfo
+}
+
+func (s *fakeService) GetComment(_ context.Context, id string) (comment.Comment, error) {
+	c, ok := s.comments[id]
+	if !ok {
+		return comment.Comment{}, comment.ErrNotFound
+	}
+	return c, nil
+}
+
+func (s *fakeService) PostComment(ctx context.Context, c comment.Comment) (comment.Comment, error) {
+	s.claims, _ = auth.ClaimsFromContext(ctx)
+	s.info = audit.RequestInfoFromContext(ctx)
+	c.ID = "2"
+	s.comments[c.ID] = c
+	return c, nil
+}
+
+func (s *fakeService) DeleteComment(context.Context, string) error {
+	return comment.ErrUnavailable
+}
+
+func TestServer(t *testing.T) {
+	service := &fakeService{comments: map[string]comment.Comment{
+		"1": {ID: "1", Slug: "go", Body: "hi", Author: "ann"},
+	}}
+	s := NewServer(service, fakeAuth{}, logging.Nop())
+
+	lis := bufconn.Listen(1 << 20)
+	go s.GRPC.Serve(lis)
+	t.Cleanup(s.GRPC.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	client := commentv1.NewCommentServiceClient(conn)
+	ctx := context.Background()
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	t.Run("reads are open", func(t *testing.T) {
+		var header metadata.MD
+		c, err := client.GetComment(ctx, &commentv1.GetCommentRequest{Id: "1"}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Equal(t, "hi", c.GetBody())
+		assert.Len(t, header.Get(requestIDKey), 1)
+
+		_, err = client.GetComment(ctx, &commentv1.GetCommentRequest{Id: "404"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("writes need a valid token", func(t *testing.T) {
+		req := &commentv1.CreateCommentRequest{Slug: "go", Body: "hello", Author: "bob"}
+
+		_, err := client.CreateComment(ctx, req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = client.CreateComment(withToken("bad"), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		c, err := client.CreateComment(withToken("good"), req)
+		require.NoError(t, err)
+		assert.Equal(t, "2", c.GetId())
+		assert.Equal(t, "user-1", service.claims.Subject, "the service sees the caller")
+		assert.NotEmpty(t, service.info.RequestID)
+	})
+
+	t.Run("requests are validated", func(t *testing.T) {
+		_, err := client.CreateComment(withToken("good"), &commentv1.CreateCommentRequest{Slug: "go"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("a store outage is retryable", func(t *testing.T) {
+		_, err := client.DeleteComment(withToken("good"), &commentv1.DeleteCommentRequest{Id: "1"})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("health checking protocol", func(t *testing.T) {
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+
+		watch, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		resp, err = watch.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	})
+
+	t.Run("reflection is open", func(t *testing.T) {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.NotEmpty(t, resp.GetListServicesResponse().GetService())
+	})
+}
+
+func TestOnlyPublicMethodsAreOpen(t *testing.T) {
+	s := NewServer(&fakeService{}, fakeAuth{}, logging.Nop())
+
+	for service, info := range s.GRPC.GetServiceInfo() {
+		for _, method := range info.Methods {
+			name := "/" + service + "/" + method.Name
+			_, err := s.authenticate(context.Background(), name)
+			if public[name] {
+				assert.NoError(t, err, name)
+			} else {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err), name)
+			}
+		}
+	}
+}
+
+func TestRunFailsWhenItCannotListen(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { taken.Close() })
+
+	s := NewServer(&fakeService{}, fakeAuth{}, logging.Nop(), WithAddr(taken.Addr().String()))
+	assert.Error(t, s.Listen())
+	assert.Error(t, s.Run(context.Background()))
+}
+
+func TestHealthFollowsReadiness(t *testing.T) {
+	var failing atomic.Bool
+	registry := apphealth.NewRegistry()
+	registry.RegisterReadiness("database", time.Second, func(context.Context) error {
+		if failing.Load() {
+			return errors.New("down")
+		}
+		return nil
+	})
+
+	s := NewServer(&fakeService{}, fakeAuth{}, logging.Nop(), WithReadiness(registry, 10*time.Millisecond))
+	lis := bufconn.Listen(1 << 20)
+	s.lis = lis
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	client := healthpb.NewHealthClient(conn)
+	statusIs := func(want healthpb.HealthCheckResponse_ServingStatus) func() bool {
+		return func() bool {
+			resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			return err == nil && resp.GetStatus() == want
+		}
+	}
+
+	assert.Eventually(t, statusIs(healthpb.HealthCheckResponse_SERVING), time.Second, 5*time.Millisecond)
+
+	failing.Store(true)
+	assert.Eventually(t, statusIs(healthpb.HealthCheckResponse_NOT_SERVING), time.Second, 5*time.Millisecond)
+
+	failing.Store(false)
+	assert.Eventually(t, statusIs(healthpb.HealthCheckResponse_SERVING), time.Second, 5*time.Millisecond)
+
+	// draining is passed on right away, not at the next check
+	registry.SetDraining(true)
+	assert.True(t, statusIs(healthpb.HealthCheckResponse_NOT_SERVING)())
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/requestid"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AuditService interface {
+	Query(ctx context.Context, f audit.Filter) ([]audit.Entry, error)
+}
+
+// AuditContextMiddleware - records where the request came from,
+// so the service layer can attach it to audit entries
+func AuditContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx := audit.WithRequestInfo(r.Context(), audit.RequestInfo{
+				RemoteAddr: audit.RemoteIP(r.RemoteAddr),
+				RequestID:  requestid.FromContext(r.Context()),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+}
+
+// RequireRole - must be wrapped by JWTAuth, it relies on the claims
+// JWTAuth puts in the request context
+func (h *Handler) RequireRole(
+	role string,
+	original func(w http.ResponseWriter, r *http.Request),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := auth.ClaimsFromContext(r.Context())
+		if !ok || claims.Role != role {
+			WriteError(w, r, ApiError{
+				Error:      "forbidden",
+				Details:    "insufficient role for this resource",
+				StatusCode: http.StatusForbidden,
+			})
+			return
+		}
+
+		original(w, r)
+	}
+}
+
+// GetAuditLog - GET /api/v1/admin/audit
+// supports ?actor=, ?comment_id=, ?from= and ?to= (RFC3339), ?after_id= and ?limit=
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	f := audit.Filter{
+		Actor:      q.Get("actor"),
+		ResourceID: q.Get("comment_id"),
+		Limit:      defaultAuditLimit,
+	}
+
+	badRequest := func(details string) {
+		WriteError(w, r, ApiError{
+			Error:      "bad request",
+			Details:    details,
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			badRequest("from must be an RFC3339 timestamp")
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			badRequest("to must be an RFC3339 timestamp")
+			return
+		}
+	}
+	if v := q.Get("after_id"); v != "" {
+		if f.AfterID, err = strconv.ParseInt(v, 10, 64); err != nil || f.AfterID < 0 {
+			badRequest("after_id must be a positive integer")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > maxAuditLimit {
+			badRequest("limit must be between 1 and 1000")
+			return
+		}
+	}
+
+	entries, err := h.Audit.Query(r.Context(), f)
+	if err != nil {
+		h.Log.WithError(err).Error(r.Context(), "failed to query the audit log")
+		WriteError(w, r, ErrInernalServer)
+		return
+	}
+
+	WriteJson(w, http.StatusOK, entries)
+}
@@ -1,41 +1,62 @@
 package http
 
 import (
-	"errors"
-	"fmt"
 	"net/http"
-	"strings"
 
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/websocket"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
 )
 
-func JWTAuth(
+func (h *Handler) JWTAuth(
 	original func(w http.ResponseWriter, r *http.Request),
 ) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		authHeader := r.Header["Authorization"]
+		token, err := bearerToken(r)
+		if err != nil {
+			unauthorized(w, r)
+			return
+		}
 
-		if authHeader == nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		r, ok := h.authenticate(r, token)
+		if !ok {
+			unauthorized(w, r)
 			return
 		}
 
-		// Bearer token
-		authHeaderParts := strings.Split(authHeader[0], " ")
-		fmt.Println(authHeaderParts)
-		fmt.Println(authHeaderParts[0])
+		original(w, r)
+	}
+}
 
-		if len(authHeaderParts) != 2 || (strings.ToLower(authHeaderParts[0]) != "bearer") {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+// OptionalAuth - JWTAuth for routes anonymous callers may use as well,
+// like /graphql where only mutations need a token. A token that is sent
+// still has to be valid. Websocket handshakes may send it the way
+// SocketAuth takes it.
+func (h *Handler) OptionalAuth(
+	original func(w http.ResponseWriter, r *http.Request),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			token string
+			err   error
+		)
+		if websocket.IsWebSocketUpgrade(r) {
+			token, err = socketToken(r)
+		} else if len(r.Header.Values("Authorization")) > 0 {
+			token, err = bearerToken(r)
+		}
+		if err != nil {
+			unauthorized(w, r)
 			return
 		}
-
-		if validateToken(authHeaderParts[1]) {
+		if token == "" {
 			original(w, r)
+			return
+		}
 
-		} else {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		r, ok := h.authenticate(r, token)
+		if !ok {
+			unauthorized(w, r)
 			return
 		}
 
@@ -43,19 +64,26 @@ func JWTAuth(
 	}
 }
 
-func validateToken(accessToken string) bool {
-	var mySigningKey = []byte("missionimpossible")
-
-	token, err := jwt.Parse(
-		accessToken, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("could not validate the token")
-			}
-			return mySigningKey, nil
-		})
-
+// authenticate - validates token and returns r carrying its claims
+func (h *Handler) authenticate(r *http.Request, token string) (*http.Request, bool) {
+	// validation also checks the jti against the revocation denylist
+	claims, err := h.Auth.ValidateToken(r.Context(), token)
 	if err != nil {
-		return false
+		return r, false
 	}
-	return token.Valid
+
+	// the access log wraps this handler, let it know who is calling
+	setPrincipal(r.Context(), claims.Subject)
+
+	return r.WithContext(auth.WithClaims(r.Context(), claims)), true
+}
+
+// bearerToken - see auth.BearerToken
+func bearerToken(r *http.Request) (string, error) {
+	return auth.BearerToken(r.Header.Values("Authorization"))
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	WriteError(w, r, ErrUnauthorized)
 }
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    string
+		wantErr bool
+	}{
+		{name: "canonical scheme", headers: []string{"Bearer abc"}, want: "abc"},
+		{name: "lower case scheme", headers: []string{"bearer abc"}, want: "abc"},
+		{name: "surrounding whitespace", headers: []string{"  Bearer   abc  "}, want: "abc"},
+		{name: "tab separated", headers: []string{"Bearer\tabc"}, want: "abc"},
+		{name: "mixed whitespace", headers: []string{"\tBEARER \t abc\t"}, want: "abc"},
+		{name: "no header", wantErr: true},
+		{name: "empty header", headers: []string{""}, wantErr: true},
+		{name: "scheme only", headers: []string{"Bearer"}, wantErr: true},
+		{name: "scheme and blank token", headers: []string{"Bearer   "}, wantErr: true},
+		{name: "other scheme", headers: []string{"Basic abc"}, wantErr: true},
+		{name: "extra parts", headers: []string{"Bearer abc def"}, wantErr: true},
+		{name: "extra parts after a tab", headers: []string{"Bearer abc\tdef"}, wantErr: true},
+		{name: "multiple headers", headers: []string{"Bearer abc", "Bearer def"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			for _, h := range tt.headers {
+				r.Header.Add("Authorization", h)
+			}
+
+			got, err := bearerToken(r)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// claimsRecorder - a GraphQLHandler that answers with the caller's subject
+type claimsRecorder struct{}
+
+func (claimsRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	io.WriteString(w, claims.Subject)
+}
+
+func (claimsRecorder) Shutdown() {}
+
+func TestOptionalAuth(t *testing.T) {
+	h := NewHandler(nil, tokenAuth{}, nil, logging.Nop(), WithGraphQL(claimsRecorder{}))
+
+	tests := []struct {
+		name        string
+		target      string
+		header      string
+		wantStatus  int
+		wantSubject string
+	}{
+		{name: "anonymous", target: "/graphql", wantStatus: http.StatusOK},
+		{name: "valid token", target: "/graphql", header: "Bearer ann", wantStatus: http.StatusOK, wantSubject: "user-ann"},
+		{name: "invalid token", target: "/graphql", header: "Bearer bad", wantStatus: http.StatusUnauthorized},
+		{name: "malformed header", target: "/graphql", header: "Basic ann", wantStatus: http.StatusUnauthorized},
+		// only websocket handshakes may put the token in the url
+		{name: "query token", target: "/graphql?access_token=ann", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.wantSubject, w.Body.String())
+			}
+		})
+	}
+}
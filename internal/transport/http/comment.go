@@ -3,30 +3,80 @@ package http
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/requestid"
 )
 
 type ApiError struct {
 	Error      string `json:"error"`
 	Details    string `json:"details"`
 	StatusCode int    `json:"status_code"`
+	// RequestID - lets a client point us at the log lines of a failed request
+	RequestID string `json:"request_id,omitempty"`
 }
 
 var (
-	ErrInernalServer = "internal server error"
-	ErrPathNotFound  = "path not found"
-	ErrNotFound      = map[string]string{"error": "not found for the given id"}
+	ErrInernalServer = ApiError{
+		Error:      "internal server error",
+		Details:    "something went wrong while processing the request",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrPathNotFound = ApiError{
+		Error:      "path not found",
+		Details:    "the id path parameter is missing",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNotFound = ApiError{
+		Error:      "not found",
+		Details:    "not found for the given id",
+		StatusCode: http.StatusNotFound,
+	}
 	ErrUnprocessable = ApiError{
-		Error:   "unprocessable entity",
-		Details: "unable to process the entity",
+		Error:      "unprocessable entity",
+		Details:    "unable to process the entity",
+		StatusCode: http.StatusUnprocessableEntity,
+	}
+	ErrRouteNotFound = ApiError{
+		Error:      "not found",
+		Details:    "no route matches the requested path",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrMethodNotAllowed = ApiError{
+		Error:      "method not allowed",
+		Details:    "the route does not accept the request method",
+		StatusCode: http.StatusMethodNotAllowed,
+	}
+	ErrServiceUnavailable = ApiError{
+		Error:      "service unavailable",
+		Details:    "the database is unavailable, try again shortly",
+		StatusCode: http.StatusServiceUnavailable,
 	}
 )
 
+// unavailableRetryAfter - hint sent with ErrServiceUnavailable when there
+// is no Cooldown to ask
+const unavailableRetryAfter = 5 * time.Second
+
+// Cooldown - how long until the store is worth retrying, like the
+// circuit breaker in front of it
+type Cooldown interface {
+	RetryAfter() time.Duration
+}
+
+// WithCooldown - Retry-After of a 503 comes from c
+func WithCooldown(c Cooldown) Option {
+	return func(h *Handler) {
+		h.Cooldown = c
+	}
+}
+
 type CommentService interface {
 	GetComment(ctx context.Context, ID string) (comment.Comment, error)
 	PostComment(context.Context, comment.Comment) (comment.Comment, error)
@@ -35,10 +85,15 @@ type CommentService interface {
 	GetMultipleComment(ctx context.Context) ([]comment.Comment, error)
 }
 
+// validate - checks the validate tags of request bodies, it caches what
+// it learns about each struct so there is one for every handler
+var validate = validator.New()
+
+// PostCommentRequest - the limits match the checks on the comments table
 type PostCommentRequest struct {
-	Slug   string `json:"slug" validate:"required"`
-	Body   string `json:"body" validate:"required"`
-	Author string `json:"author" validate:"required"`
+	Slug   string `json:"slug" validate:"required,max=255"`
+	Body   string `json:"body" validate:"required,max=10000"`
+	Author string `json:"author" validate:"required,max=255"`
 }
 
 func convertPostCmtReqToCmt(c PostCommentRequest) comment.Comment {
@@ -56,14 +111,21 @@ func (h *Handler) PostComment(
 	var cmt PostCommentRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&cmt); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, r, ApiError{
+			Error:      "bad request",
+			Details:    "could not decode the request body",
+			StatusCode: http.StatusBadRequest,
+		})
 		return
 	}
 
 	//? validate the request body
-	validate := validator.New()
 	if err := validate.Struct(cmt); err != nil {
-		http.Error(w, "some required fields are missing", http.StatusUnprocessableEntity)
+		WriteError(w, r, ApiError{
+			Error:      "unprocessable entity",
+			Details:    "some required fields are missing",
+			StatusCode: http.StatusUnprocessableEntity,
+		})
 		return
 	}
 
@@ -71,7 +133,8 @@ func (h *Handler) PostComment(
 
 	postedCmt, err := h.Service.PostComment(r.Context(), convertedCmt)
 	if err != nil {
-		log.Println(err)
+		h.Log.WithError(err).Error(r.Context(), "failed to post comment")
+		h.writeServiceError(w, r, err, ErrInernalServer)
 		return
 	}
 
@@ -89,12 +152,13 @@ func (h *Handler) GetMultipleComment(
 	cmts, err := h.Service.GetMultipleComment(r.Context())
 
 	if err != nil {
-		log.Println("failed to get multiple comments from service layer", err)
+		h.Log.WithError(err).Error(r.Context(), "failed to get multiple comments from service layer")
+		h.writeServiceError(w, r, err, ErrInernalServer)
 		return
 	}
 
 	if err := WriteJson(w, http.StatusOK, cmts); err != nil {
-		log.Println("failed to write json response", err)
+		h.Log.WithError(err).Error(r.Context(), "failed to write json response")
 		return
 	}
 }
@@ -105,30 +169,40 @@ func (h *Handler) GetComment(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	if id == "" {
-		WriteJson(w, http.StatusNotFound, ErrPathNotFound)
+		WriteError(w, r, ErrPathNotFound)
 		return
 	}
 
 	cmt, err := h.Service.GetComment(r.Context(), id)
 	if err != nil {
-		log.Println("failed to get comment from service layer", err)
-		WriteJson(w, http.StatusInternalServerError, ErrNotFound)
+		h.Log.WithError(err).Error(r.Context(), "failed to get comment from service layer")
+		h.writeServiceError(w, r, err, ErrNotFound)
 		return
 	}
 
 	if err := WriteJson(w, http.StatusOK, cmt); err != nil {
-		WriteJson(w, http.StatusInternalServerError, ErrInernalServer)
+		h.Log.WithError(err).Error(r.Context(), "failed to write json response")
 		return
 	}
 }
 
 func (h *Handler) UpdateComment(w http.ResponseWriter, r *http.Request) {
-	var updatedCmt comment.Comment
+	var updatedCmt PostCommentRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&updatedCmt); err != nil {
-		WriteJson(w, http.StatusBadRequest, ApiError{
+		WriteError(w, r, ApiError{
 			Error:      "unprocessable entity",
 			Details:    "Server could not process the entity, check the request body",
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	// same limits as a new comment, the table checks would turn them into a 500
+	if err := validate.Struct(updatedCmt); err != nil {
+		WriteError(w, r, ApiError{
+			Error:      "unprocessable entity",
+			Details:    "some required fields are missing",
 			StatusCode: http.StatusUnprocessableEntity,
 		})
 		return
@@ -138,19 +212,20 @@ func (h *Handler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	if id == "" {
-		WriteJson(w, http.StatusNotFound, ErrInernalServer)
+		WriteError(w, r, ErrPathNotFound)
 		return
 	}
 
-	cmt, err := h.Service.UpdateComment(r.Context(), id, updatedCmt)
+	cmt, err := h.Service.UpdateComment(r.Context(), id, convertPostCmtReqToCmt(updatedCmt))
 
 	if err != nil {
-		WriteJson(w, http.StatusInternalServerError, ErrInernalServer)
+		h.Log.WithError(err).Error(r.Context(), "failed to update comment")
+		h.writeServiceError(w, r, err, ErrInernalServer)
 		return
 	}
 
 	if err := WriteJson(w, http.StatusOK, cmt); err != nil {
-		WriteJson(w, http.StatusInternalServerError, ErrInernalServer)
+		h.Log.WithError(err).Error(r.Context(), "failed to write json response")
 		return
 	}
 
@@ -163,19 +238,20 @@ func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	if id == "" {
-		WriteJson(w, http.StatusNotFound, ErrNotFound)
+		WriteError(w, r, ErrPathNotFound)
 		return
 	}
 
 	if err := h.Service.DeleteComment(r.Context(), id); err != nil {
-		WriteJson(w, http.StatusInternalServerError, ErrInernalServer)
+		h.Log.WithError(err).Error(r.Context(), "failed to delete comment")
+		h.writeServiceError(w, r, err, ErrInernalServer)
 		return
 	}
 
 	err := WriteJson(w, http.StatusOK, map[string]string{"result": "deleted comment successfully"})
 
 	if err != nil {
-		WriteJson(w, http.StatusInternalServerError, ErrInernalServer)
+		h.Log.WithError(err).Error(r.Context(), "failed to write json response")
 		return
 	}
 }
@@ -184,3 +260,38 @@ func WriteJson(w http.ResponseWriter, status int, v any) error {
 	w.WriteHeader(status)
 	return json.NewEncoder(w).Encode(v)
 }
+
+// writeServiceError - a store outage becomes a 503 the client can
+// retry, a missing comment a 404, anything else is answered with fallback
+func (h *Handler) writeServiceError(w http.ResponseWriter, r *http.Request, err error, fallback ApiError) {
+	switch {
+	case errors.Is(err, comment.ErrUnavailable):
+		w.Header().Set("Retry-After", strconv.Itoa(h.retryAfter()))
+		WriteError(w, r, ErrServiceUnavailable)
+	case errors.Is(err, comment.ErrNotFound):
+		WriteError(w, r, ErrNotFound)
+	default:
+		WriteError(w, r, fallback)
+	}
+}
+
+// retryAfter - seconds until the store is worth retrying, rounded up and
+// at least 1. The breaker says 0 when it is closed or about to probe,
+// the client should still back off a little.
+func (h *Handler) retryAfter() int {
+	d := unavailableRetryAfter
+	if h.Cooldown != nil {
+		d = h.Cooldown.RetryAfter()
+	}
+	return max(ceilSeconds(d), 1)
+}
+
+// WriteError - writes an ApiError tagged with the id of the current request
+func WriteError(w http.ResponseWriter, r *http.Request, apiErr ApiError) error {
+	if apiErr.StatusCode == 0 {
+		apiErr.StatusCode = http.StatusInternalServerError
+	}
+	apiErr.RequestID = requestid.FromContext(r.Context())
+
+	return WriteJson(w, apiErr.StatusCode, apiErr)
+}
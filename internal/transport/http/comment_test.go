@@ -0,0 +1,106 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingService struct {
+	CommentService
+	err error
+}
+
+func (s failingService) GetComment(context.Context, string) (comment.Comment, error) {
+	return comment.Comment{}, s.err
+}
+
+// fixedCooldown - a breaker that always has d left to wait
+type fixedCooldown time.Duration
+
+func (c fixedCooldown) RetryAfter() time.Duration { return time.Duration(c) }
+
+func TestGetCommentErrors(t *testing.T) {
+	get := func(err error, opts ...Option) *httptest.ResponseRecorder {
+		h := NewHandler(failingService{err: err}, nil, nil, logging.Nop(), opts...)
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/comment/42", nil))
+		return w
+	}
+
+	t.Run("store outage is a retryable 503", func(t *testing.T) {
+		w := get(fmt.Errorf("%w: %w", comment.ErrFetchingComment, comment.ErrUnavailable))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("the breaker says when to retry", func(t *testing.T) {
+		unavailable := fmt.Errorf("%w: %w", comment.ErrFetchingComment, comment.ErrUnavailable)
+
+		w := get(unavailable, WithCooldown(fixedCooldown(2100*time.Millisecond)))
+		assert.Equal(t, "3", w.Header().Get("Retry-After"))
+
+		// closed or about to probe, still back off a little
+		w = get(unavailable, WithCooldown(fixedCooldown(0)))
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("anything else is still a 404", func(t *testing.T) {
+		w := get(errors.New("no rows"))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+// updatingService - UpdateComment stores nothing and remembers what it got
+type updatingService struct {
+	CommentService
+	got *comment.Comment
+}
+
+func (s updatingService) UpdateComment(_ context.Context, id string, c comment.Comment) (comment.Comment, error) {
+	*s.got = c
+	c.ID = id
+	return c, nil
+}
+
+func TestUpdateCommentValidation(t *testing.T) {
+	var got comment.Comment
+	h := NewHandler(updatingService{got: &got}, tokenAuth{}, nil, logging.Nop())
+
+	put := func(body string) *httptest.ResponseRecorder {
+		got = comment.Comment{}
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/comment/42", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer ann")
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("a valid update reaches the service", func(t *testing.T) {
+		w := put(`{"slug": "go", "body": "hi", "author": "ann"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, comment.Comment{Slug: "go", Body: "hi", Author: "ann"}, got)
+	})
+
+	t.Run("an empty field is unprocessable", func(t *testing.T) {
+		w := put(`{"slug": "go", "body": "", "author": "ann"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Empty(t, got)
+	})
+
+	t.Run("a field that is too long is unprocessable", func(t *testing.T) {
+		w := put(`{"slug": "` + strings.Repeat("x", 256) + `", "body": "hi", "author": "ann"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Empty(t, got)
+	})
+}
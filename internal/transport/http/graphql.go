@@ -0,0 +1,18 @@
+package http
+
+import "net/http"
+
+// GraphQLHandler - a graphql.Handler, Shutdown ends its open sockets
+type GraphQLHandler interface {
+	http.Handler
+	Shutdown()
+}
+
+// WithGraphQL - mounts g on /graphql behind OptionalAuth and
+// RateLimitReads, mutations check for the claims and take a write
+// themselves
+func WithGraphQL(g GraphQLHandler) Option {
+	return func(h *Handler) {
+		h.GraphQL = g
+	}
+}
@@ -2,24 +2,174 @@ package http
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/health"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/metrics"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
+// serviceName - how the API identifies itself in traces
+const serviceName = "comments-api"
+
 type Handler struct {
 	Router  *mux.Router
 	Service CommentService
-	Server  *http.Server
+	Auth    AuthService
+	Audit   AuditService
+	// Webhooks - optional, the webhook admin endpoints need it
+	Webhooks WebhookService
+	Log      logging.Logger
+	Limiter  *RateLimiter
+	Metrics  *metrics.Metrics
+	Health   *health.Registry
+	Server   *http.Server
+	// Cooldown - optional, answers Retry-After on a store outage
+	Cooldown Cooldown
+
+	// AdminRouter and AdminServer - serve /metrics on their own port when
+	// WithAdminAddr is used, otherwise /metrics is mounted on the main router
+	AdminRouter *mux.Router
+	AdminServer *http.Server
+
+	// AccessLogSampleRate - share of successful requests that get an
+	// access log entry, errors are always logged. 1 logs everything.
+	AccessLogSampleRate float64
+
+	// DrainDelay - how long /readyz reports draining before the server
+	// stops accepting connections, so load balancers can take it out
+	DrainDelay time.Duration
+
+	// ShutdownTimeout - how long in-flight requests get once draining is over
+	ShutdownTimeout time.Duration
+
+	// Broker - optional, feeds the live comment streams
+	Broker *stream.Broker
+	// StreamHeartbeat - how often an idle stream sends a keep-alive
+	// comment, and an idle websocket a ping
+	StreamHeartbeat time.Duration
+	// MaxSubscriptions - slugs one websocket may follow at a time
+	MaxSubscriptions int
+	// GraphQL - optional, served on /graphql
+	GraphQL GraphQLHandler
+	// ValidateRequests and ValidateResponses - check traffic against
+	// OpenAPI(), see Validate
+	ValidateRequests  bool
+	ValidateResponses bool
+	// closing - closed when shutdown starts, so streams end instead of
+	// holding it up
+	closing chan struct{}
+}
+
+// WithTimeouts - read and write timeouts of the API server, streams
+// lift them for their own connection
+func WithTimeouts(read, write time.Duration) Option {
+	return func(h *Handler) {
+		h.Server.ReadTimeout = read
+		h.Server.WriteTimeout = write
+	}
+}
+
+// WithAddr - the address the API listens on, :8080 by default
+func WithAddr(addr string) Option {
+	return func(h *Handler) {
+		h.Server.Addr = addr
+	}
+}
+
+// WithShutdownTimeout - see Handler.ShutdownTimeout
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.ShutdownTimeout = d
+	}
+}
+
+// Option - optional Handler dependencies
+type Option func(*Handler)
+
+// WithAccessLogSampleRate - see Handler.AccessLogSampleRate
+func WithAccessLogSampleRate(rate float64) Option {
+	return func(h *Handler) {
+		h.AccessLogSampleRate = rate
+	}
+}
+
+// WithMetrics - records prometheus metrics and exposes them on /metrics
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(h *Handler) {
+		h.Metrics = m
+	}
+}
+
+// WithAdminAddr - moves the admin endpoints (/metrics) to a separate
+// listener, so they don't have to be exposed next to the public API
+func WithAdminAddr(addr string) Option {
+	return func(h *Handler) {
+		h.AdminRouter = mux.NewRouter()
+		h.AdminServer = &http.Server{
+			Addr:    addr,
+			Handler: h.AdminRouter,
+		}
+	}
 }
 
-func NewHandler(service CommentService) *Handler {
+// WithHealth - the checks behind /healthz and /readyz
+func WithHealth(r *health.Registry) Option {
+	return func(h *Handler) {
+		h.Health = r
+	}
+}
+
+// WithDrainDelay - see Handler.DrainDelay
+func WithDrainDelay(d time.Duration) Option {
+	return func(h *Handler) {
+		h.DrainDelay = d
+	}
+}
+
+// WithRateLimiter - enables per-client rate limiting on every route
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(h *Handler) {
+		h.Limiter = l
+	}
+}
+
+func NewHandler(
+	service CommentService,
+	authService AuthService,
+	auditService AuditService,
+	logger logging.Logger,
+	opts ...Option,
+) *Handler {
 	h := &Handler{
-		Service: service,
+		Service:             service,
+		Auth:                authService,
+		Audit:               auditService,
+		Log:                 logger.WithFields(logging.Fields{"component": "http"}),
+		AccessLogSampleRate: 1,
+		ShutdownTimeout:     15 * time.Second,
+		StreamHeartbeat:     15 * time.Second,
+		MaxSubscriptions:    20,
+		Server:              &http.Server{Addr: ":8080"},
+		closing:             make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.Health == nil {
+		h.Health = health.NewRegistry()
 	}
 
 	h.Router = mux.NewRouter()
@@ -28,48 +178,169 @@ func NewHandler(service CommentService) *Handler {
 	// bcz it has a reciver of the Handler struct. we can acces it
 	// by using a handler struct instance
 	h.mapRoutes()
+	h.mapAdminRoutes()
+	// mux answers unmatched requests without running the Use chain, these
+	// get the request id and JSON body every other error has
+	h.Router.NotFoundHandler = RequestIDMiddleware(JSONMiddleware(errorHandler(ErrRouteNotFound)))
+	h.Router.MethodNotAllowedHandler = RequestIDMiddleware(JSONMiddleware(errorHandler(ErrMethodNotAllowed)))
+	// otelmux goes first: it picks up an incoming traceparent and starts the
+	// server span, named after the route template, everything else runs inside
+	h.Router.Use(otelmux.Middleware(serviceName))
+	h.Router.Use(RequestIDMiddleware)
+	h.Router.Use(h.MetricsMiddleware)
 	h.Router.Use(JSONMiddleware)
-	h.Router.Use(LoggingMiddleware)
+	h.Router.Use(h.LoggingMiddleware)
+	h.Router.Use(AuditContextMiddleware)
 
-	h.Server = &http.Server{
-		Addr:    ":8080",
-		Handler: h.Router,
+	h.Server.Handler = h.Router
+	h.Server.RegisterOnShutdown(func() { close(h.closing) })
+	if h.GraphQL != nil {
+		h.Server.RegisterOnShutdown(h.GraphQL.Shutdown)
 	}
 
 	return h
 }
 
 func (h *Handler) mapRoutes() {
-	h.Router.HandleFunc("/alive",
-		func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("I am alive!!"))
-		}).Methods("GET")
+	h.Router.HandleFunc("/healthz", h.Liveness).Methods("GET")
+	h.Router.HandleFunc("/readyz", h.Readiness).Methods("GET")
+	// kept for existing probes, same as /healthz
+	h.Router.HandleFunc("/alive", h.Liveness).Methods("GET")
+
+	// RateLimit goes inside JWTAuth so authenticated callers are keyed by
+	// subject, Validate inside both so only they get to see the API's shape
+	h.Router.HandleFunc("/api/v1/auth/token", h.RateLimit(h.Validate(h.IssueToken))).Methods("POST")
+	h.Router.HandleFunc("/api/v1/auth/revoke", h.JWTAuth(h.RateLimit(h.Validate(h.RevokeToken)))).Methods("POST")
+
+	h.Router.HandleFunc("/api/v1/comment", h.JWTAuth(h.RateLimit(h.Validate(h.PostComment)))).Methods("POST")
+	h.Router.HandleFunc("/api/v1/comment/{id}", h.RateLimit(h.Validate(h.GetComment))).Methods("GET")
+	h.Router.HandleFunc("/api/v1/comment/{id}", h.JWTAuth(h.RateLimit(h.Validate(h.UpdateComment)))).Methods("PUT")
+	h.Router.HandleFunc("/api/v1/comment/{id}", h.JWTAuth(h.RateLimit(h.Validate(h.DeleteComment)))).Methods("DELETE")
+	h.Router.HandleFunc("/api/v1/get-multiple", h.RateLimit(h.Validate(h.GetMultipleComment))).Methods("GET")
+	if h.Broker != nil {
+		h.Router.HandleFunc("/api/v1/slugs/{slug}/comments/stream", h.RateLimit(h.Validate(h.StreamComments))).Methods("GET")
+		h.Router.HandleFunc("/api/v1/comments/ws", h.SocketAuth(h.RateLimit(h.Validate(h.CommentSocket)))).Methods("GET")
+	}
+
+	if h.GraphQL != nil {
+		// GET opens a socket for subscriptions, queries are POSTed like
+		// mutations so each mutation is charged as a write by the handler
+		h.Router.HandleFunc("/graphql", h.OptionalAuth(h.RateLimitReads(h.Validate(h.GraphQL.ServeHTTP)))).Methods("GET", "POST")
+	}
 
-	h.Router.HandleFunc("/api/v1/comment", JWTAuth(h.PostComment)).Methods("POST")
-	h.Router.HandleFunc("/api/v1/comment/{id}", h.GetComment).Methods("GET")
-	h.Router.HandleFunc("/api/v1/comment/{id}", JWTAuth(h.UpdateComment)).Methods("PUT")
-	h.Router.HandleFunc("/api/v1/comment/{id}", JWTAuth(h.DeleteComment)).Methods("DELETE")
-	h.Router.HandleFunc("/api/v1/get-multiple", h.GetMultipleComment).Methods("GET")
+	h.Router.HandleFunc("/api/v1/admin/audit",
+		h.JWTAuth(h.RequireRole(auth.RoleAdmin, h.RateLimit(h.Validate(h.GetAuditLog))))).Methods("GET")
+
+	if h.Webhooks != nil {
+		admin := func(f http.HandlerFunc) http.HandlerFunc {
+			return h.JWTAuth(h.RequireRole(auth.RoleAdmin, h.RateLimit(h.Validate(f))))
+		}
+		h.Router.HandleFunc("/api/v1/admin/webhooks", admin(h.RegisterWebhook)).Methods("POST")
+		h.Router.HandleFunc("/api/v1/admin/webhooks", admin(h.ListWebhooks)).Methods("GET")
+		h.Router.HandleFunc("/api/v1/admin/webhooks/{id}", admin(h.DeleteWebhook)).Methods("DELETE")
+		h.Router.HandleFunc("/api/v1/admin/webhooks/{id}/deliveries", admin(h.ListWebhookDeliveries)).Methods("GET")
+		h.Router.HandleFunc("/api/v1/admin/webhooks/{id}/deliveries/{delivery_id}/replay",
+			admin(h.ReplayWebhookDelivery)).Methods("POST")
+	}
+
+	// every route above has to be described in OpenAPI, TestOpenAPICoversRoutes checks
+	h.Router.HandleFunc("/openapi.json", h.RateLimit(h.Validate(h.ServeOpenAPI))).Methods("GET")
+	h.Router.HandleFunc("/docs", h.RateLimit(h.Validate(h.ServeDocs))).Methods("GET")
+	h.Router.HandleFunc("/docs/{file}", h.RateLimit(h.Validate(h.ServeDocsAsset))).Methods("GET")
 }
 
+// mapAdminRoutes - operational endpoints, on the admin listener if
+// there is one and on the main router otherwise
+func (h *Handler) mapAdminRoutes() {
+	router := h.Router
+	if h.AdminRouter != nil {
+		router = h.AdminRouter
+	}
+
+	if h.Metrics != nil {
+		router.Handle("/metrics", h.Metrics.Handler()).Methods("GET")
+	}
+}
+
+// errorHandler - answers every request with apiErr
+func errorHandler(apiErr ApiError) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, apiErr)
+	})
+}
+
+// Serve - serves until SIGTERM or an interrupt, then drains and shuts
+// down. A listener that can't bind or stops serving ends it with an error.
 func (h *Handler) Serve() error {
-	go func() {
-		if err := h.Server.ListenAndServe(); err != nil {
-			log.Println(err.Error())
+	// SIGTERM is what docker and kubernetes send, Ctrl-C is for local runs
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+
+	// bound up front, so a port that is taken fails startup
+	servers := []*http.Server{h.Server}
+	if h.AdminServer != nil {
+		servers = append(servers, h.AdminServer)
+	}
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		lis, err := listen(srv)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
 		}
-	}()
+		listeners = append(listeners, lis)
+	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	failed := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func(srv *http.Server, lis net.Listener) {
+			if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("error serving http on %s: %w", lis.Addr(), err)
+			}
+		}(srv, listeners[i])
+	}
+
+	var serveErr error
+	select {
+	case <-c:
+		// fail readiness first and give the load balancer time to notice,
+		// in-flight and late requests are still served meanwhile
+		h.Health.SetDraining(true)
+		h.Log.WithFields(logging.Fields{"drain_delay": h.DrainDelay.String()}).
+			Info(context.Background(), "draining before shutdown")
+		time.Sleep(h.DrainDelay)
+	case serveErr = <-failed:
+		// nothing left worth draining for
+		h.Health.SetDraining(true)
+		h.Log.WithError(serveErr).Error(context.Background(), "server stopped")
+	}
 
 	ctx, cancel := context.WithTimeout(
 		context.Background(),
-		15*time.Second)
+		h.ShutdownTimeout)
 	defer cancel()
 
 	h.Server.Shutdown(ctx)
-	log.Println("shutting down the server gracefully")
+	if h.AdminServer != nil {
+		h.AdminServer.Shutdown(ctx)
+	}
+	h.Log.Info(ctx, "shutting down the server gracefully")
 
-	return nil
+	return serveErr
+}
+
+// listen - binds srv.Addr the way ListenAndServe would
+func listen(srv *http.Server) (net.Listener, error) {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for http on %s: %w", addr, err)
+	}
+	return lis, nil
 }
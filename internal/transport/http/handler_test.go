@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeFailsWhenThePortIsTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { taken.Close() })
+
+	t.Run("the api port", func(t *testing.T) {
+		h := NewHandler(echoService{}, nil, nil, logging.Nop(), WithAddr(taken.Addr().String()))
+		assert.ErrorContains(t, serveWithin(t, h), "error listening for http")
+	})
+
+	t.Run("the admin port", func(t *testing.T) {
+		h := NewHandler(echoService{}, nil, nil, logging.Nop(),
+			WithAddr("127.0.0.1:0"),
+			WithAdminAddr(taken.Addr().String()),
+		)
+		assert.ErrorContains(t, serveWithin(t, h), "error listening for http")
+	})
+}
+
+// serveWithin - Serve, which must give up without waiting for a signal
+func serveWithin(t *testing.T, h *Handler) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- h.Serve() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve is still waiting for a signal")
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/health"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+)
+
+// Liveness - /healthz, whether the process should be restarted
+func (h *Handler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, h.Health.Liveness(r.Context()))
+}
+
+// Readiness - /readyz, whether the instance should receive traffic.
+// Fails while a dependency is down or the server is draining.
+func (h *Handler) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := h.Health.Readiness(r.Context())
+	if !report.Healthy() {
+		h.Log.WithFields(healthFields(report)).Warn(r.Context(), "readiness check failed")
+	}
+	writeReport(w, report)
+}
+
+func writeReport(w http.ResponseWriter, report health.Report) {
+	// probes must never see a cached answer
+	w.Header().Set("Cache-Control", "no-store")
+
+	status := http.StatusOK
+	if !report.Healthy() {
+		status = http.StatusServiceUnavailable
+	}
+	WriteJson(w, status, report)
+}
+
+// healthFields - the failing checks, for the log entry
+func healthFields(report health.Report) logging.Fields {
+	fields := logging.Fields{"status": report.Status}
+	for name, res := range report.Checks {
+		if res.Status != health.StatusOK {
+			fields["check_"+name] = res.Error
+		}
+	}
+	return fields
+}
@@ -0,0 +1,60 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/health"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	var dbErr error
+	registry := health.NewRegistry()
+	registry.RegisterReadiness("database", time.Second, func(context.Context) error { return dbErr })
+
+	h := NewHandler(nil, nil, nil, logging.Nop(), WithHealth(registry))
+
+	get := func(path string) (*httptest.ResponseRecorder, health.Report) {
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var report health.Report
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return w, report
+	}
+
+	t.Run("ready when every check passes", func(t *testing.T) {
+		w, report := get("/readyz")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, health.StatusOK, report.Checks["database"].Status)
+	})
+
+	t.Run("not ready when a dependency is down", func(t *testing.T) {
+		dbErr = errors.New("connection refused")
+		defer func() { dbErr = nil }()
+
+		w, report := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "connection refused", report.Checks["database"].Error)
+
+		// liveness does not depend on the database
+		w, _ = get("/healthz")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("not ready while draining", func(t *testing.T) {
+		registry.SetDraining(true)
+		defer registry.SetDraining(false)
+
+		w, report := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, health.StatusDraining, report.Status)
+	})
+}
@@ -0,0 +1,36 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MetricsMiddleware - counts requests and observes their latency,
+// labeled by route template so ids in the path don't explode cardinality
+func (h *Handler) MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if h.Metrics == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Metrics.HTTPInFlight.Inc()
+			defer h.Metrics.HTTPInFlight.Dec()
+
+			start := time.Now()
+			rec := newResponseRecorder(w)
+
+			next.ServeHTTP(rec, r)
+
+			route, ok := matchedRoute(r)
+			if !ok {
+				route = "unmatched"
+			}
+			labels := []string{route, r.Method, strconv.Itoa(rec.Status())}
+
+			h.Metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+			h.Metrics.HTTPDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		})
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	t.Run("served on the main router by default", func(t *testing.T) {
+		h := NewHandler(nil, nil, nil, logging.Nop(), WithMetrics(metrics.New()))
+
+		h.Router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/alive", nil))
+
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(),
+			`comments_api_http_requests_total{method="GET",route="/alive",status="200"} 1`)
+		assert.Contains(t, w.Body.String(), "comments_api_http_request_duration_seconds_bucket")
+		assert.Contains(t, w.Body.String(), "comments_api_http_requests_in_flight")
+	})
+
+	t.Run("moved to the admin listener", func(t *testing.T) {
+		h := NewHandler(nil, nil, nil, logging.Nop(),
+			WithMetrics(metrics.New()), WithAdminAddr(":0"))
+
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		h.AdminRouter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
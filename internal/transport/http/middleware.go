@@ -2,10 +2,15 @@ package http
 
 import (
 	"context"
+	"math/rand"
 	"net/http"
 	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/requestid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -23,20 +28,78 @@ func JSONMiddleware(next http.Handler) http.Handler {
 		})
 }
 
-func LoggingMiddleware(next http.Handler) http.Handler {
+// RequestIDMiddleware - reuses the caller's X-Request-ID when it is sane,
+// generates one otherwise, and makes it available to everything downstream
+func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			// log the request
-			log.WithFields(log.Fields{
-				"method": r.Method,
-				"path":   r.URL.Path,
-			}).Info("handled request")
+			id := r.Header.Get(requestid.Header)
+			if !requestid.Valid(id) {
+				id = requestid.New()
+			}
+
+			w.Header().Set(requestid.Header, id)
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request_id", id))
+			next.ServeHTTP(w, r.WithContext(requestid.WithContext(r.Context(), id)))
+		})
+}
+
+// LoggingMiddleware - writes one access log entry per request, after
+// the handler has run so status, latency and size are known.
+// Successful requests are sampled with h.AccessLogSampleRate,
+// 4xx and 5xx are always logged.
+func (h *Handler) LoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newResponseRecorder(w)
+			ctx, principal := withPrincipalHolder(r.Context())
 
 			// call the next handler in the chain
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			status := rec.Status()
+			if status < http.StatusBadRequest && !sampled(h.AccessLogSampleRate) {
+				return
+			}
+
+			subject := principal.subject
+			if subject == "" {
+				subject = "anonymous"
+			}
+
+			// the request id comes from the context
+			entry := h.Log.WithFields(logging.Fields{
+				"method":      r.Method,
+				"route":       routeTemplate(r),
+				"path":        r.URL.Path,
+				"status":      status,
+				"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"bytes":       rec.bytes,
+				"remote_ip":   audit.RemoteIP(r.RemoteAddr),
+				"user_agent":  r.UserAgent(),
+				"principal":   subject,
+			})
+
+			switch {
+			case status >= http.StatusInternalServerError:
+				entry.Error(r.Context(), "handled request")
+			case status >= http.StatusBadRequest:
+				entry.Warn(r.Context(), "handled request")
+			default:
+				entry.Info(r.Context(), "handled request")
+			}
 		})
 }
 
+// sampled - rate <= 0 or >= 1 logs everything
+func sampled(rate float64) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	return rand.Float64() < rate
+}
+
 // TimeoutMiddleware is a middleware that times out the request after 15 seconds
 // if it takes longer than that to process, it will return a 500
 func TimeoutMiddleware(next http.Handler) http.Handler {
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			seen = requestid.FromContext(r.Context())
+			WriteError(w, r, ErrInernalServer)
+		}))
+
+	t.Run("generates an id when none is sent", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.NotEmpty(t, seen)
+		assert.Equal(t, seen, w.Header().Get(requestid.Header))
+		assert.Contains(t, w.Body.String(), `"request_id":"`+seen+`"`)
+	})
+
+	t.Run("reuses a valid incoming id", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(requestid.Header, "abc-123")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, "abc-123", seen)
+		assert.Equal(t, "abc-123", w.Header().Get(requestid.Header))
+	})
+
+	t.Run("replaces an unsafe incoming id", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(requestid.Header, "bad id\twith spaces")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.NotEqual(t, "bad id\twith spaces", seen)
+		assert.Equal(t, seen, w.Header().Get(requestid.Header))
+	})
+}
+
+func TestUnmatchedRequests(t *testing.T) {
+	h := NewHandler(echoService{}, nil, nil, logging.Nop())
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   ApiError
+	}{
+		{name: "unknown path", method: http.MethodGet, target: "/api/v1/nope", want: ErrRouteNotFound},
+		{name: "unknown method", method: http.MethodPatch, target: "/api/v1/comment/42", want: ErrMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.Header.Set(requestid.Header, "abc-123")
+			w := httptest.NewRecorder()
+			h.Router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.want.StatusCode, w.Code)
+			assert.Equal(t, "abc-123", w.Header().Get(requestid.Header))
+			assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"))
+
+			var got ApiError
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			want := tt.want
+			want.RequestID = "abc-123"
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(logging.Options{Output: &buf})
+	assert.NoError(t, err)
+
+	h := &Handler{Log: logger, AccessLogSampleRate: 1}
+	router := mux.NewRouter()
+	router.Use(RequestIDMiddleware)
+	router.Use(h.LoggingMiddleware)
+	router.HandleFunc("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		setPrincipal(r.Context(), "user-1")
+		WriteJson(w, http.StatusCreated, map[string]string{"ok": "yes"})
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/things/42", nil)
+	r.Header.Set("User-Agent", "test-agent")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+
+	assert.Equal(t, "/things/{id}", entry["route"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.Equal(t, "user-1", entry["principal"])
+	assert.Equal(t, "test-agent", entry["user_agent"])
+	assert.NotEmpty(t, entry["request_id"])
+	assert.Greater(t, entry["bytes"], float64(0))
+	assert.Contains(t, entry, "duration_ms")
+}
@@ -0,0 +1,606 @@
+package http
+
+import (
+	_ "embed"
+	"mime"
+	"net/http"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/health"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/webhook"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// docsPage - Swagger UI, loading its files from /docs/ and the spec
+// from /openapi.json
+//
+//go:embed docs.html
+var docsPage []byte
+
+// OpenAPI - the contract of every route mapRoutes and mapAdminRoutes can
+// mount, optional ones included. It is built once and shared by every
+// caller, so it must not be changed.
+func OpenAPI() *openapi3.T {
+	return openAPI()
+}
+
+var openAPI = sync.OnceValue(newOpenAPI)
+
+// ServeOpenAPI - GET /openapi.json
+func (h *Handler) ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if err := WriteJson(w, http.StatusOK, OpenAPI()); err != nil {
+		h.Log.WithError(err).Error(r.Context(), "failed to write json response")
+	}
+}
+
+// ServeDocs - GET /docs
+func (h *Handler) ServeDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Write(docsPage)
+}
+
+// ServeDocsAsset - GET /docs/{file}, the Swagger UI files docs.html loads
+func (h *Handler) ServeDocsAsset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["file"]
+
+	// JSONMiddleware has set application/json already, the file server
+	// only works the type out itself when there is none
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	} else {
+		w.Header().Del("Content-Type")
+	}
+	http.ServeFileFS(w, r, swaggerFiles.FS, name)
+}
+
+// schemaFor - the JSON schema of v's type. Requests require what their
+// validate tags require, responses every field that isn't omitempty.
+func schemaFor(v any, request bool) *openapi3.Schema {
+	ref, err := openapi3gen.NewSchemaRefForValue(v, nil, openapi3gen.SchemaCustomizer(
+		func(_ string, t reflect.Type, tag reflect.StructTag, s *openapi3.Schema) error {
+			// 3.1 has no nullable, optional pointers are simply left out
+			s.Nullable = false
+			applyRules(s, validateRules(t, tag))
+			if t.Kind() == reflect.Struct && s.Properties != nil {
+				s.Required = requiredFields(t, request)
+			}
+			return nil
+		}))
+	if err != nil {
+		// only types that can't be described at all, like channels, fail
+		panic(err)
+	}
+	return ref.Value
+}
+
+// validateRules - the validate rules that apply to t. The items of a
+// slice get the ones after dive, the slice itself the ones before.
+func validateRules(t reflect.Type, tag reflect.StructTag) []string {
+	v := tag.Get("validate")
+	if v == "" {
+		return nil
+	}
+	rules := strings.Split(v, ",")
+	if i := slices.Index(rules, "dive"); i >= 0 {
+		if t.Kind() == reflect.Slice {
+			return rules[:i]
+		}
+		return rules[i+1:]
+	}
+	return rules
+}
+
+// applyRules - the validate rules with a JSON schema counterpart
+func applyRules(s *openapi3.Schema, rules []string) {
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "max", "min":
+			n, err := strconv.ParseUint(param, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch {
+			case s.Type.Is("string") && name == "max":
+				s.MaxLength = &n
+			case s.Type.Is("string"):
+				s.MinLength = n
+			case s.Type.Is("array") && name == "max":
+				s.MaxItems = &n
+			case s.Type.Is("array"):
+				s.MinItems = n
+			}
+		case "oneof":
+			for _, v := range strings.Fields(param) {
+				s.Enum = append(s.Enum, v)
+			}
+		case "url":
+			s.Format = "uri"
+		}
+	}
+}
+
+func requiredFields(t reflect.Type, request bool) []string {
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		if request && !slices.Contains(validateRules(f.Type, f.Tag), "required") {
+			continue
+		}
+		if !request && slices.Contains(strings.Split(opts, ","), "omitempty") {
+			continue
+		}
+		required = append(required, name)
+	}
+	return required
+}
+
+func newOpenAPI() *openapi3.T {
+	doc := &openapi3.T{
+		OpenAPI: "3.1.0",
+		Info: &openapi3.Info{
+			Title:   "Comments API",
+			Version: "1.0.0",
+			Description: "Comments grouped into threads by slug. Errors are ApiErrors, except for " +
+				"rate limited requests and requests that fail validation which get problem details, " +
+				"and carry the request id from X-Request-ID.",
+		},
+		Paths: openapi3.NewPaths(),
+		Components: &openapi3.Components{
+			Schemas: openapi3.Schemas{},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"bearerAuth": &openapi3.SecuritySchemeRef{Value: openapi3.NewJWTSecurityScheme().
+					WithDescription("an access token from /api/v1/auth/token")},
+				"accessToken": &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().
+					WithType("apiKey").WithIn("query").WithName("access_token").
+					WithDescription("the access token for websockets, browsers can't set headers on the handshake")},
+			},
+		},
+	}
+
+	schemas := doc.Components.Schemas
+	add := func(name, description string, s *openapi3.Schema) {
+		s.Description = description
+		schemas[name] = s.NewRef()
+	}
+	// ref - the value is kept next to the $ref, so the document can be
+	// used without resolving it first
+	ref := func(name string) *openapi3.SchemaRef {
+		s, ok := schemas[name]
+		if !ok {
+			panic("openapi: no schema named " + name)
+		}
+		return openapi3.NewSchemaRef("#/components/schemas/"+name, s.Value)
+	}
+	arrayOf := func(name string) *openapi3.SchemaRef {
+		s := openapi3.NewArraySchema()
+		s.Items = ref(name)
+		return s.NewRef()
+	}
+
+	add("Comment", "A comment on the thread of a slug",
+		schemaFor(comment.Comment{}, false))
+	add("PostCommentRequest", "A new comment, or the new contents of one",
+		schemaFor(PostCommentRequest{}, true))
+	add("ApiError", "The body of every error response",
+		schemaFor(ApiError{}, false))
+	add("ProblemDetails", "An RFC 9457 problem, the body of a 429 and of requests "+
+		"turned away by validation, problems then lists every mismatch",
+		schemaFor(ProblemDetails{}, false))
+	add("Result", "What is left to say after deleting or revoking",
+		openapi3.NewObjectSchema().
+			WithProperty("result", openapi3.NewStringSchema()).
+			WithRequired([]string{"result"}))
+	add("TokenRequest", "username and password are required for the password grant, "+
+		"refresh_token for the refresh_token grant",
+		schemaFor(TokenRequest{}, true))
+	add("TokenResponse", "", schemaFor(TokenResponse{}, false))
+	add("RevokeRequest", "The bearer token used to call the endpoint is always revoked, "+
+		"refresh_token with it when given",
+		schemaFor(RevokeRequest{}, true))
+	add("HealthReport", "", schemaFor(health.Report{}, false))
+	add("AuditEntry", "One link of the hash chained audit log",
+		schemaFor(audit.Entry{}, false))
+	add("RegisterWebhookRequest", `events may be exact types, "comment.*" or "*"`,
+		schemaFor(RegisterWebhookRequest{}, true))
+	add("Webhook", "secret is only ever shown in the response to registering the webhook",
+		schemaFor(webhook.Webhook{}, false))
+	add("WebhookDelivery", "", schemaFor(webhook.Delivery{}, false))
+	add("GraphQLRequest", "",
+		openapi3.NewObjectSchema().
+			WithProperty("query", openapi3.NewStringSchema()).
+			WithProperty("operationName", openapi3.NewStringSchema()).
+			WithProperty("variables", openapi3.NewObjectSchema()).
+			WithRequired([]string{"query"}))
+	add("GraphQLResponse", "Errors in the query are reported here with a 200, "+
+		"extensions.code tells them apart",
+		openapi3.NewObjectSchema().
+			WithPropertyRef("data", &openapi3.SchemaRef{Value: &openapi3.Schema{}}).
+			WithProperty("errors", openapi3.NewArraySchema().WithItems(
+				openapi3.NewObjectSchema().
+					WithProperty("message", openapi3.NewStringSchema()).
+					WithProperty("extensions", openapi3.NewObjectSchema()).
+					WithRequired([]string{"message"}))))
+
+	jsonResponse := func(description string, s *openapi3.SchemaRef) *openapi3.ResponseRef {
+		return &openapi3.ResponseRef{Value: openapi3.NewResponse().
+			WithDescription(description).
+			WithJSONSchemaRef(s)}
+	}
+	contentResponse := func(description, contentType string) *openapi3.ResponseRef {
+		return &openapi3.ResponseRef{Value: openapi3.NewResponse().
+			WithDescription(description).
+			WithContent(openapi3.NewContentWithSchema(openapi3.NewStringSchema(), []string{contentType}))}
+	}
+	errorResponse := func(description string) *openapi3.ResponseRef {
+		return jsonResponse(description, ref("ApiError"))
+	}
+	// rejectedResponse - an ApiError from the handler, or problem details
+	// when validation turned the request away before it got there
+	rejectedResponse := func(description string) *openapi3.ResponseRef {
+		r := errorResponse(description)
+		r.Value.Content[problemContentType] = openapi3.NewMediaType().WithSchemaRef(ref("ProblemDetails"))
+		return r
+	}
+	tooManyRequests := &openapi3.ResponseRef{Value: openapi3.NewResponse().
+		WithDescription("The rate limit is used up, Retry-After says for how many seconds").
+		WithContent(openapi3.NewContentWithSchemaRef(ref("ProblemDetails"), []string{problemContentType}))}
+	// unlimited - any route can fail with an ApiError, a 503 included, the
+	// statuses listed are the ones worth telling apart
+	unlimited := func(opts ...openapi3.NewResponsesOption) *openapi3.Responses {
+		r := openapi3.NewResponses(opts...)
+		r.Set("default", errorResponse("An error"))
+		return r
+	}
+	// responses - the same for routes behind RateLimit and Validate
+	responses := func(opts ...openapi3.NewResponsesOption) *openapi3.Responses {
+		r := unlimited(opts...)
+		r.Set(strconv.Itoa(http.StatusTooManyRequests), tooManyRequests)
+		r.Set("default", rejectedResponse("An error"))
+		return r
+	}
+	jsonBody := func(name string, required bool) *openapi3.RequestBodyRef {
+		return &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().
+			WithRequired(required).
+			WithJSONSchemaRef(ref(name))}
+	}
+	security := func(schemes ...string) *openapi3.SecurityRequirements {
+		reqs := openapi3.NewSecurityRequirements()
+		for _, scheme := range schemes {
+			req := openapi3.NewSecurityRequirement()
+			if scheme != "" {
+				req.Authenticate(scheme)
+			}
+			reqs.With(req)
+		}
+		return reqs
+	}
+	pathParam := func(name, description string) *openapi3.ParameterRef {
+		return &openapi3.ParameterRef{Value: openapi3.NewPathParameter(name).
+			WithDescription(description).
+			WithSchema(openapi3.NewStringSchema())}
+	}
+	queryParam := func(name, description string, s *openapi3.Schema) *openapi3.ParameterRef {
+		return &openapi3.ParameterRef{Value: openapi3.NewQueryParameter(name).
+			WithDescription(description).
+			WithSchema(s)}
+	}
+	afterID := queryParam("after_id", "Only entries after this id, for paging",
+		openapi3.NewInt64Schema().WithMin(0))
+	limit := queryParam("limit", "At most this many entries, 100 by default",
+		openapi3.NewIntegerSchema().WithMin(1).WithMax(1000))
+
+	unauthorized := openapi3.WithStatus(http.StatusUnauthorized, errorResponse("A missing, invalid or revoked token"))
+	forbidden := openapi3.WithStatus(http.StatusForbidden, errorResponse("The token lacks the admin role"))
+	notFound := openapi3.WithStatus(http.StatusNotFound, errorResponse("Nothing with the given id"))
+	badRequest := func(description string) openapi3.NewResponsesOption {
+		return openapi3.WithStatus(http.StatusBadRequest, rejectedResponse(description))
+	}
+	unprocessable := func(description string) openapi3.NewResponsesOption {
+		return openapi3.WithStatus(http.StatusUnprocessableEntity, rejectedResponse(description))
+	}
+
+	probe := func(id, summary string) *openapi3.Operation {
+		return &openapi3.Operation{
+			Tags:        []string{"health"},
+			OperationID: id,
+			Summary:     summary,
+			Responses: unlimited(
+				openapi3.WithStatus(http.StatusOK, jsonResponse("Every check passed", ref("HealthReport"))),
+				openapi3.WithStatus(http.StatusServiceUnavailable, jsonResponse("A check failed, or the server is draining", ref("HealthReport"))),
+			),
+		}
+	}
+	doc.AddOperation("/healthz", http.MethodGet, probe("liveness", "Whether the process is up"))
+	doc.AddOperation("/readyz", http.MethodGet, probe("readiness", "Whether the server and its dependencies can take traffic"))
+	alive := probe("alive", "Same as /healthz, kept for existing probes")
+	alive.Deprecated = true
+	doc.AddOperation("/alive", http.MethodGet, alive)
+
+	doc.AddOperation("/api/v1/auth/token", http.MethodPost, &openapi3.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "issueToken",
+		Summary:     "Log in, or swap a refresh token for a new pair",
+		RequestBody: jsonBody("TokenRequest", true),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("A new token pair", ref("TokenResponse"))),
+			badRequest("The body isn't JSON"),
+			unauthorized,
+			unprocessable("An unsupported grant_type, or fields it needs are missing"),
+		),
+	})
+	doc.AddOperation("/api/v1/auth/revoke", http.MethodPost, &openapi3.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "revokeToken",
+		Summary:     "Log out",
+		Security:    security("bearerAuth"),
+		RequestBody: jsonBody("RevokeRequest", false),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The tokens are revoked", ref("Result"))),
+			unauthorized,
+		),
+	})
+
+	commentID := pathParam("id", "The id of the comment")
+	doc.AddOperation("/api/v1/comment", http.MethodPost, &openapi3.Operation{
+		Tags:        []string{"comments"},
+		OperationID: "postComment",
+		Summary:     "Post a comment",
+		Security:    security("bearerAuth"),
+		RequestBody: jsonBody("PostCommentRequest", true),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The comment as stored", ref("Comment"))),
+			badRequest("The body isn't JSON"),
+			unauthorized,
+			unprocessable("A field is missing or too long"),
+		),
+	})
+	doc.AddOperation("/api/v1/comment/{id}", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"comments"},
+		OperationID: "getComment",
+		Summary:     "Get a comment",
+		Parameters:  openapi3.Parameters{commentID},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The comment", ref("Comment"))),
+			notFound,
+		),
+	})
+	doc.AddOperation("/api/v1/comment/{id}", http.MethodPut, &openapi3.Operation{
+		Tags:        []string{"comments"},
+		OperationID: "updateComment",
+		Summary:     "Replace the contents of a comment",
+		Security:    security("bearerAuth"),
+		Parameters:  openapi3.Parameters{commentID},
+		RequestBody: jsonBody("PostCommentRequest", true),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The comment as stored", ref("Comment"))),
+			badRequest("The body isn't JSON"),
+			unauthorized,
+			notFound,
+			unprocessable("A field is missing or too long"),
+		),
+	})
+	doc.AddOperation("/api/v1/comment/{id}", http.MethodDelete, &openapi3.Operation{
+		Tags:        []string{"comments"},
+		OperationID: "deleteComment",
+		Summary:     "Delete a comment",
+		Security:    security("bearerAuth"),
+		Parameters:  openapi3.Parameters{commentID},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The comment is gone", ref("Result"))),
+			unauthorized,
+			notFound,
+		),
+	})
+	doc.AddOperation("/api/v1/get-multiple", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"comments"},
+		OperationID: "listComments",
+		Summary:     "Get every comment",
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The comments", arrayOf("Comment"))),
+		),
+	})
+
+	doc.AddOperation("/api/v1/slugs/{slug}/comments/stream", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"live"},
+		OperationID: "streamComments",
+		Summary:     "Follow the comments of a slug as Server-Sent Events",
+		Description: "Every comment created, updated or deleted under the slug is an event whose data " +
+			"is the comment. A reconnecting client sends Last-Event-ID and gets what it missed, " +
+			"a reset event means that wasn't possible and it should reload.",
+		Parameters: openapi3.Parameters{
+			pathParam("slug", "The thread to follow"),
+			&openapi3.ParameterRef{Value: openapi3.NewHeaderParameter("Last-Event-ID").
+				WithDescription("The id of the last event seen").
+				WithSchema(openapi3.NewStringSchema())},
+		},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, contentResponse("The event stream", "text/event-stream")),
+		),
+	})
+	doc.AddOperation("/api/v1/comments/ws", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"live"},
+		OperationID: "commentSocket",
+		Summary:     "Open a websocket to follow slugs, post comments and send typing indicators",
+		Security:    security("bearerAuth", "accessToken"),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusSwitchingProtocols, &openapi3.ResponseRef{Value: openapi3.NewResponse().
+				WithDescription("The websocket is open")}),
+			unauthorized,
+		),
+	})
+	doc.AddOperation("/graphql", http.MethodPost, &openapi3.Operation{
+		Tags:        []string{"live"},
+		OperationID: "graphql",
+		Summary:     "Run a GraphQL query or mutation, mutations need a token",
+		Security:    security("", "bearerAuth"),
+		RequestBody: jsonBody("GraphQLRequest", true),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The result", ref("GraphQLResponse"))),
+			openapi3.WithStatus(http.StatusBadRequest, jsonResponse("The body isn't a request", ref("GraphQLResponse"))),
+		),
+	})
+	doc.AddOperation("/graphql", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"live"},
+		OperationID: "graphqlSocket",
+		Summary:     "Open a graphql-transport-ws websocket for subscriptions",
+		Security:    security("", "bearerAuth"),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusSwitchingProtocols, &openapi3.ResponseRef{Value: openapi3.NewResponse().
+				WithDescription("The websocket is open")}),
+			openapi3.WithStatus(http.StatusMethodNotAllowed, jsonResponse("Not a websocket handshake", ref("GraphQLResponse"))),
+		),
+	})
+
+	doc.AddOperation("/api/v1/admin/audit", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"admin"},
+		OperationID: "getAuditLog",
+		Summary:     "Search the audit log",
+		Security:    security("bearerAuth"),
+		Parameters: openapi3.Parameters{
+			queryParam("actor", "Only what this subject did", openapi3.NewStringSchema()),
+			queryParam("comment_id", "Only what happened to this comment", openapi3.NewStringSchema()),
+			queryParam("from", "Only entries at or after this time", openapi3.NewDateTimeSchema()),
+			queryParam("to", "Only entries before this time", openapi3.NewDateTimeSchema()),
+			afterID,
+			limit,
+		},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The entries, oldest first", arrayOf("AuditEntry"))),
+			badRequest("A query parameter is malformed"),
+			unauthorized,
+			forbidden,
+		),
+	})
+
+	webhookID := pathParam("id", "The id of the webhook")
+	doc.AddOperation("/api/v1/admin/webhooks", http.MethodPost, &openapi3.Operation{
+		Tags:        []string{"admin"},
+		OperationID: "registerWebhook",
+		Summary:     "Register a webhook",
+		Description: "Deliveries to loopback, link-local and private addresses are refused " +
+			"unless the server runs with webhooks.allow_private.",
+		Security:    security("bearerAuth"),
+		RequestBody: jsonBody("RegisterWebhookRequest", true),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusCreated, jsonResponse("The webhook with its signing secret", ref("Webhook"))),
+			badRequest("The body isn't JSON"),
+			unauthorized,
+			forbidden,
+			unprocessable("The url or an event type isn't acceptable"),
+		),
+	})
+	doc.AddOperation("/api/v1/admin/webhooks", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"admin"},
+		OperationID: "listWebhooks",
+		Summary:     "List the webhooks",
+		Security:    security("bearerAuth"),
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The webhooks, without their secrets", arrayOf("Webhook"))),
+			unauthorized,
+			forbidden,
+		),
+	})
+	doc.AddOperation("/api/v1/admin/webhooks/{id}", http.MethodDelete, &openapi3.Operation{
+		Tags:        []string{"admin"},
+		OperationID: "deleteWebhook",
+		Summary:     "Delete a webhook, pending deliveries are dropped with it",
+		Security:    security("bearerAuth"),
+		Parameters:  openapi3.Parameters{webhookID},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusNoContent, &openapi3.ResponseRef{Value: openapi3.NewResponse().
+				WithDescription("The webhook is gone")}),
+			unauthorized,
+			forbidden,
+			notFound,
+		),
+	})
+	doc.AddOperation("/api/v1/admin/webhooks/{id}/deliveries", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"admin"},
+		OperationID: "listWebhookDeliveries",
+		Summary:     "List the deliveries of a webhook",
+		Security:    security("bearerAuth"),
+		Parameters: openapi3.Parameters{
+			webhookID,
+			queryParam("status", "Only deliveries in this state", openapi3.NewStringSchema().
+				WithEnum(webhook.StatusPending, webhook.StatusSucceeded, webhook.StatusDead)),
+			afterID,
+			limit,
+		},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The deliveries, oldest first", arrayOf("WebhookDelivery"))),
+			badRequest("A query parameter is malformed"),
+			unauthorized,
+			forbidden,
+		),
+	})
+	doc.AddOperation("/api/v1/admin/webhooks/{id}/deliveries/{delivery_id}/replay", http.MethodPost, &openapi3.Operation{
+		Tags:        []string{"admin"},
+		OperationID: "replayWebhookDelivery",
+		Summary:     "Send a delivery again with a fresh set of attempts",
+		Security:    security("bearerAuth"),
+		Parameters: openapi3.Parameters{
+			webhookID,
+			pathParam("delivery_id", "The id of the delivery"),
+		},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusAccepted, jsonResponse("The delivery, queued again", ref("WebhookDelivery"))),
+			unauthorized,
+			forbidden,
+			notFound,
+		),
+	})
+
+	doc.AddOperation("/metrics", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "metrics",
+		Summary:     "Prometheus metrics",
+		Description: "Served on the admin listener instead when the server has one.",
+		Responses: unlimited(
+			openapi3.WithStatus(http.StatusOK, contentResponse("The metrics in the text exposition format", "text/plain")),
+		),
+	})
+	doc.AddOperation("/openapi.json", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "openapi",
+		Summary:     "This document",
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, jsonResponse("The OpenAPI document", openapi3.NewObjectSchema().NewRef())),
+		),
+	})
+	doc.AddOperation("/docs", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "docs",
+		Summary:     "Swagger UI for this document",
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, contentResponse("The page", "text/html")),
+		),
+	})
+	doc.AddOperation("/docs/{file}", http.MethodGet, &openapi3.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "docsAsset",
+		Summary:     "The scripts and styles of the docs page",
+		Parameters:  openapi3.Parameters{pathParam("file", "The name of the file")},
+		Responses: responses(
+			openapi3.WithStatus(http.StatusOK, &openapi3.ResponseRef{Value: openapi3.NewResponse().
+				WithDescription("The file")}),
+			openapi3.WithStatus(http.StatusNotFound, contentResponse("No such file", "text/plain")),
+		),
+	})
+
+	return doc
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/metrics"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookService - only there so the webhook routes get mounted
+type webhookService struct {
+	WebhookService
+}
+
+// fullHandler - a Handler with every optional route mounted
+func fullHandler() *Handler {
+	return NewHandler(nil, nil, nil, logging.Nop(),
+		WithMetrics(metrics.New()),
+		WithBroker(stream.NewBroker(stream.Options{})),
+		WithWebhooks(webhookService{}),
+		WithGraphQL(claimsRecorder{}),
+	)
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	doc := OpenAPI()
+	require.NoError(t, doc.Validate(context.Background()))
+
+	mounted := map[string]bool{}
+	err := fullHandler().Router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		require.NoError(t, err)
+		methods, err := route.GetMethods()
+		require.NoError(t, err)
+
+		for _, method := range methods {
+			key := method + " " + path
+			mounted[key] = true
+			item := doc.Paths.Value(path)
+			assert.True(t, item != nil && item.GetOperation(method) != nil,
+				"%s is not described in OpenAPI(), add it to newOpenAPI", key)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			assert.True(t, mounted[method+" "+path], "%s %s is described but not mounted", method, path)
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	schemas := OpenAPI().Components.Schemas
+
+	post := schemas["PostCommentRequest"].Value
+	assert.ElementsMatch(t, []string{"slug", "body", "author"}, post.Required)
+	require.NotNil(t, post.Properties["body"].Value.MaxLength)
+	assert.EqualValues(t, 10000, *post.Properties["body"].Value.MaxLength)
+
+	// responses require what is always there
+	assert.ElementsMatch(t, []string{"error", "details", "status_code"}, schemas["ApiError"].Value.Required)
+	assert.ElementsMatch(t, []string{"id", "slug", "body", "author"}, schemas["Comment"].Value.Required)
+
+	token := schemas["TokenRequest"].Value
+	assert.Equal(t, []string{"grant_type"}, token.Required)
+	assert.Equal(t, []any{GrantTypePassword, GrantTypeRefreshToken}, token.Properties["grant_type"].Value.Enum)
+
+	events := schemas["RegisterWebhookRequest"].Value.Properties["events"].Value
+	assert.EqualValues(t, 1, events.MinItems)
+	require.NotNil(t, events.Items.Value.MaxLength)
+	assert.EqualValues(t, 255, *events.Items.Value.MaxLength)
+	assert.Equal(t, "uri", schemas["RegisterWebhookRequest"].Value.Properties["url"].Value.Format)
+
+	// optional pointers are left out rather than nullable, which 3.1 dropped
+	delivery := schemas["WebhookDelivery"].Value
+	assert.False(t, delivery.Properties["delivered_at"].Value.Nullable)
+	assert.NotContains(t, delivery.Required, "delivered_at")
+}
+
+func TestDocsEndpoints(t *testing.T) {
+	h := NewHandler(nil, nil, nil, logging.Nop())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("the document", func(t *testing.T) {
+		w := get("/openapi.json")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		doc, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "3.1.0", doc.OpenAPI)
+		assert.NoError(t, doc.Validate(context.Background()))
+		assert.NotNil(t, doc.Paths.Find("/api/v1/comment/{id}"))
+	})
+
+	t.Run("the page and its files", func(t *testing.T) {
+		w := get("/docs")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+		assert.Contains(t, w.Body.String(), "/openapi.json")
+
+		w = get("/docs/swagger-ui-bundle.js")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript"))
+
+		w = get("/docs/swagger-ui.css")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/css"))
+
+		assert.Equal(t, http.StatusNotFound, get("/docs/nope.js").Code)
+	})
+}
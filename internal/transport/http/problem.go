@@ -0,0 +1,45 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/requestid"
+)
+
+// problemContentType - the media type of RFC 9457 problem details
+const problemContentType = "application/problem+json"
+
+// ProblemDetails - an RFC 9457 problem, the body of errors raised before a
+// request reaches its handler. Type is a URI naming the kind of problem,
+// "about:blank" when the status says it all.
+type ProblemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// RequestID - same as on ApiError, lets a client point us at the log lines
+	RequestID string `json:"request_id,omitempty"`
+	// Problems - every way a request broke the OpenAPI document, when
+	// that is why it was rejected
+	Problems []Problem `json:"problems,omitempty"`
+}
+
+// WriteProblem - writes p as application/problem+json, tagged with the id
+// of the current request
+func WriteProblem(w http.ResponseWriter, r *http.Request, p ProblemDetails) error {
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	p.RequestID = requestid.FromContext(r.Context())
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/audit"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+)
+
+// RateLimitPolicy - read limits apply to GET, HEAD and OPTIONS,
+// write limits to every other method
+type RateLimitPolicy struct {
+	Read  ratelimit.Limit
+	Write ratelimit.Limit
+}
+
+// RateLimiter - token bucket limits keyed per client and per route
+type RateLimiter struct {
+	Store   ratelimit.Store
+	Default RateLimitPolicy
+	// Routes - overrides keyed by route template, e.g. "/api/v1/comment/{id}"
+	Routes map[string]RateLimitPolicy
+}
+
+// ErrTooManyRequests - body of a 429 response
+var ErrTooManyRequests = ProblemDetails{
+	Type:   "about:blank",
+	Title:  "Too Many Requests",
+	Status: http.StatusTooManyRequests,
+	Detail: "rate limit exceeded, retry after the number of seconds in Retry-After",
+}
+
+// RateLimit - wraps a route handler. It has to sit inside JWTAuth on
+// protected routes so authenticated clients are keyed by their subject.
+func (h *Handler) RateLimit(
+	original func(w http.ResponseWriter, r *http.Request),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.allow(w, r, r.Method) {
+			original(w, r)
+		}
+	}
+}
+
+// RateLimitReads - RateLimit for routes that only learn whether a request
+// writes once they have parsed it, like /graphql and its mutations. Every
+// request is charged as a read, the handler charges each write itself
+// with ratelimit.TakeWrite.
+func (h *Handler) RateLimitReads(
+	original func(w http.ResponseWriter, r *http.Request),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.allow(w, r, http.MethodGet) {
+			return
+		}
+		if h.Limiter != nil {
+			route := routeTemplate(r)
+			caller := r
+			r = r.WithContext(ratelimit.WithWriteTaker(r.Context(), func(ctx context.Context) (ratelimit.Result, error) {
+				return h.takeToken(caller.WithContext(ctx), route, http.MethodPost)
+			}))
+		}
+		original(w, r)
+	}
+}
+
+// allow - charges the caller of r one request to the limit of method,
+// answering it with a 429 and returning false when it is used up
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if h.Limiter == nil {
+		return true
+	}
+
+	res, err := h.takeToken(r, routeTemplate(r), method)
+	if err != nil {
+		// fail open, an unavailable limiter should not take the API down
+		h.Log.WithError(err).Warn(r.Context(), "rate limiter unavailable")
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		WriteProblem(w, r, ErrTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// takeToken - charges the caller of r one request to route and method,
+// which need not be the ones r itself was made to
+func (h *Handler) takeToken(r *http.Request, route, method string) (ratelimit.Result, error) {
+	limit, class := h.Limiter.limitFor(route, method)
+	key := clientKey(r) + "|" + class + "|" + route
+
+	return h.Limiter.Store.Take(r.Context(), key, limit)
+}
+
+func (l *RateLimiter) limitFor(route, method string) (ratelimit.Limit, string) {
+	policy, ok := l.Routes[route]
+	if !ok {
+		policy = l.Default
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return policy.Read, "read"
+	default:
+		return policy.Write, "write"
+	}
+}
+
+// clientKey - the authenticated subject when there is one, the client IP
+// otherwise. Nothing the client sends unverified can pick its bucket, so
+// there is no API key bucket until there are API keys to verify.
+func clientKey(r *http.Request) string {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+
+	return "ip:" + audit.RemoteIP(r.RemoteAddr)
+}
+
+// routeTemplate - the matched mux route template, the raw path otherwise
+func routeTemplate(r *http.Request) string {
+	if tpl, ok := matchedRoute(r); ok {
+		return tpl
+	}
+	return r.URL.Path
+}
+
+func matchedRoute(r *http.Request) (string, bool) {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl, true
+		}
+	}
+	return "", false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
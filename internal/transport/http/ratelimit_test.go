@@ -0,0 +1,117 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := &Handler{
+		Limiter: &RateLimiter{
+			Store: ratelimit.NewMemoryStore(time.Hour),
+			Default: RateLimitPolicy{
+				Read:  ratelimit.Limit{Rate: 0.001, Burst: 2},
+				Write: ratelimit.Limit{Rate: 0.001, Burst: 1},
+			},
+		},
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/things/{id}", h.RateLimit(ok)).Methods("GET", "POST")
+
+	do := func(method, remoteAddr string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/things/1", nil)
+		r.RemoteAddr = remoteAddr
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodGet, "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "10.0.0.1:1234").Code)
+
+	w = do(http.MethodGet, "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Too Many Requests",
+		"status": 429,
+		"detail": "rate limit exceeded, retry after the number of seconds in Retry-After"
+	}`, w.Body.String())
+
+	// writes have their own bucket
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "10.0.0.1:1234").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "10.0.0.1:1234").Code)
+
+	// and so does every other client
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "10.0.0.2:1234").Code)
+
+	// headers the client makes up don't buy it a fresh bucket
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "10.0.0.1:1234", "X-API-Key", "new").Code)
+}
+
+func TestRateLimitReads(t *testing.T) {
+	h := &Handler{
+		Limiter: &RateLimiter{
+			Store: ratelimit.NewMemoryStore(time.Hour),
+			Default: RateLimitPolicy{
+				Read:  ratelimit.Limit{Rate: 0.001, Burst: 3},
+				Write: ratelimit.Limit{Rate: 0.001, Burst: 1},
+			},
+		},
+	}
+
+	// writes when the body says so, like a GraphQL mutation
+	maybeWrite := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("write") != "" {
+			res, err := ratelimit.TakeWrite(r.Context())
+			if err != nil || !res.Allowed {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/graphql", h.RateLimitReads(maybeWrite)).Methods("POST")
+
+	do := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// POSTs are charged as reads
+	w := do("/graphql")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3", w.Header().Get("RateLimit-Limit"))
+
+	// writes inside them are charged on top
+	assert.Equal(t, http.StatusOK, do("/graphql?write=1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("/graphql?write=1").Code)
+
+	// the reads ran out along the way
+	assert.Equal(t, http.StatusTooManyRequests, do("/graphql").Code)
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+)
+
+// responseRecorder - wraps the ResponseWriter so middleware can see
+// what the handler actually sent back
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// Status - the status code sent, 200 if the handler never set one
+func (rr *responseRecorder) Status() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+// Flush - keeps streaming responses working through the wrapper
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack - lets websocket upgrades through the wrapper
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rr.ResponseWriter).Hijack()
+	if err == nil && rr.status == 0 {
+		rr.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap - lets http.ResponseController reach the underlying writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// principalHolder - JWTAuth runs inside the route handler, after the
+// router middleware. It records the subject here so the access log,
+// which wraps everything, can still report who made the request.
+type principalHolder struct {
+	subject string
+}
+
+type principalKey struct{}
+
+func withPrincipalHolder(ctx context.Context) (context.Context, *principalHolder) {
+	p := &principalHolder{}
+	return context.WithValue(ctx, principalKey{}, p), p
+}
+
+func setPrincipal(ctx context.Context, subject string) {
+	if p, ok := ctx.Value(principalKey{}).(*principalHolder); ok {
+		p.subject = subject
+	}
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+)
+
+// sseRetry - how long browsers wait before reconnecting, in milliseconds
+const sseRetry = 3000
+
+// WithBroker - mounts the live comment streams fed by b
+func WithBroker(b *stream.Broker) Option {
+	return func(h *Handler) {
+		h.Broker = b
+	}
+}
+
+// WithStreamHeartbeat - see Handler.StreamHeartbeat
+func WithStreamHeartbeat(d time.Duration) Option {
+	return func(h *Handler) {
+		h.StreamHeartbeat = d
+	}
+}
+
+// StreamComments - GET /api/v1/slugs/{slug}/comments/stream
+// Server-Sent Events for every comment created, updated or deleted under
+// the slug. A reconnecting client sends Last-Event-ID and gets what it
+// missed; a "reset" event means that wasn't possible and it should reload.
+func (h *Handler) StreamComments(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	// an id we can't parse is treated like one from before a restart
+	var lastEventID uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			id = ^uint64(0)
+		}
+		lastEventID = id
+	}
+
+	rc := http.NewResponseController(w)
+	// the server's read timeout would cancel the request mid-stream, the
+	// write timeout is replaced by a per-write one in writeDeadline
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.Log.WithError(err).Warn(r.Context(), "could not clear the read deadline of a stream")
+	}
+	writeDeadline := func() {
+		rc.SetWriteDeadline(time.Now().Add(2 * h.StreamHeartbeat))
+	}
+
+	sub, replay, complete := h.Broker.Subscribe(lastEventID, slug)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Connection", "keep-alive")
+	// keeps nginx and friends from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	writeDeadline()
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range replay {
+		if err := writeSSE(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.closing:
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, the client resumes
+				// from its last event id when it reconnects
+				return
+			}
+			if e.Type == stream.EventTyping {
+				// only the websocket relays typing indicators
+				continue
+			}
+			writeDeadline()
+			err = writeSSE(w, e)
+		case <-heartbeat.C:
+			writeDeadline()
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeSSE - one event, data is the comment as the REST API returns it
+func writeSSE(w io.Writer, e stream.Event) error {
+	data, err := json.Marshal(e.Comment)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSSE - the next event, heartbeats included, as its raw lines
+func readSSE(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestStreamComments(t *testing.T) {
+	broker := stream.NewBroker(stream.Options{})
+	h := NewHandler(nil, nil, nil, logging.Nop(),
+		WithBroker(broker),
+		WithStreamHeartbeat(50*time.Millisecond),
+	)
+
+	// far shorter than the stream stays open
+	srv := httptest.NewUnstartedServer(h.Router)
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	// registered first so it runs after the bodies are closed
+	t.Cleanup(srv.Close)
+
+	connect := func(lastEventID string) *bufio.Reader {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/slugs/go/comments/stream", nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		r := bufio.NewReader(resp.Body)
+		assert.Equal(t, []string{"retry: 3000"}, readSSE(t, r))
+		return r
+	}
+
+	r := connect("")
+
+	t.Run("outlives the server timeouts", func(t *testing.T) {
+		time.Sleep(300 * time.Millisecond)
+		broker.Publish(comment.Change{
+			Type:    comment.EventCommentCreated,
+			Comment: comment.Comment{ID: "42", Slug: "go", Body: "hi"},
+		})
+
+		var event []string
+		for event == nil || strings.HasPrefix(event[0], ":") {
+			event = readSSE(t, r)
+		}
+		assert.Equal(t, []string{
+			"id: 1",
+			"event: comment.created",
+			`data: {"id":"42","slug":"go","body":"hi","author":""}`,
+		}, event)
+	})
+
+	t.Run("sends heartbeats", func(t *testing.T) {
+		assert.Equal(t, []string{": heartbeat"}, readSSE(t, r))
+	})
+
+	t.Run("resumes from Last-Event-ID", func(t *testing.T) {
+		broker.Publish(comment.Change{
+			Type:    comment.EventCommentDeleted,
+			Comment: comment.Comment{ID: "42", Slug: "go"},
+		})
+
+		event := readSSE(t, connect("1"))
+		assert.Equal(t, "id: 2", event[0])
+		assert.Equal(t, "event: comment.deleted", event[1])
+	})
+
+	t.Run("asks for a reload when it can't resume", func(t *testing.T) {
+		event := readSSE(t, connect("999"))
+		assert.Equal(t, []string{"event: reset", "data: {}"}, event)
+	})
+}
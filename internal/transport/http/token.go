@@ -0,0 +1,138 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+)
+
+const (
+	GrantTypePassword     = "password"
+	GrantTypeRefreshToken = "refresh_token"
+)
+
+type AuthService interface {
+	Login(ctx context.Context, username, password string) (auth.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (auth.TokenPair, error)
+	Revoke(ctx context.Context, claims auth.Claims, refreshToken string) error
+	ValidateToken(ctx context.Context, accessToken string) (auth.Claims, error)
+}
+
+// TokenRequest - body of POST /api/v1/auth/token
+// username and password are required for the password grant,
+// refresh_token for the refresh_token grant
+type TokenRequest struct {
+	GrantType    string `json:"grant_type" validate:"required,oneof=password refresh_token"`
+	Username     string `json:"username" validate:"required_if=GrantType password"`
+	Password     string `json:"password" validate:"required_if=GrantType password"`
+	RefreshToken string `json:"refresh_token" validate:"required_if=GrantType refresh_token"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RevokeRequest - body of POST /api/v1/auth/revoke
+// the bearer token used to call the endpoint is always revoked
+type RevokeRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+var ErrUnauthorized = ApiError{
+	Error:      "unauthorized",
+	Details:    "invalid credentials or token",
+	StatusCode: http.StatusUnauthorized,
+}
+
+func (h *Handler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, r, ApiError{
+			Error:      "bad request",
+			Details:    "could not decode the request body",
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		WriteError(w, r, ApiError{
+			Error:      "unprocessable entity",
+			Details:    "unsupported grant_type or missing fields",
+			StatusCode: http.StatusUnprocessableEntity,
+		})
+		return
+	}
+
+	var (
+		pair auth.TokenPair
+		err  error
+	)
+	switch req.GrantType {
+	case GrantTypePassword:
+		pair, err = h.Auth.Login(r.Context(), req.Username, req.Password)
+	case GrantTypeRefreshToken:
+		pair, err = h.Auth.Refresh(r.Context(), req.RefreshToken)
+	}
+
+	if err != nil {
+		if isAuthError(err) {
+			WriteError(w, r, ErrUnauthorized)
+			return
+		}
+		h.Log.WithError(err).Error(r.Context(), "failed to issue token")
+		WriteError(w, r, ErrInernalServer)
+		return
+	}
+
+	// tokens must never end up in a shared cache
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJson(w, http.StatusOK, TokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(pair.ExpiresIn.Seconds()),
+		RefreshToken: pair.RefreshToken,
+	})
+}
+
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var req RevokeRequest
+
+	// the body is optional, an empty one only revokes the access token
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, r, ApiError{
+			Error:      "bad request",
+			Details:    "could not decode the request body",
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	if err := h.Auth.Revoke(r.Context(), claims, req.RefreshToken); err != nil {
+		if isAuthError(err) {
+			WriteError(w, r, ErrUnauthorized)
+			return
+		}
+		h.Log.WithError(err).Error(r.Context(), "failed to revoke token")
+		WriteError(w, r, ErrInernalServer)
+		return
+	}
+
+	WriteJson(w, http.StatusOK, map[string]string{"result": "token revoked"})
+}
+
+func isAuthError(err error) bool {
+	return errors.Is(err, auth.ErrInvalidCredentials) ||
+		errors.Is(err, auth.ErrInvalidToken) ||
+		errors.Is(err, auth.ErrTokenRevoked)
+}
@@ -0,0 +1,275 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+)
+
+const (
+	// maxValidatedBody - bodies are read whole to be checked, the largest
+	// any route takes is a comment of 10000 characters
+	maxValidatedBody = 1 << 20
+	// maxCheckedResponse - longer responses are sent on unchecked
+	maxCheckedResponse = 1 << 20
+)
+
+// Problem - one way a request breaks the OpenAPI document
+type Problem struct {
+	// In - path, query, header or body
+	In string `json:"in"`
+	// Name - the parameter, or a JSON pointer into the body
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// WithRequestValidation - requests that break OpenAPI() are rejected
+// before they reach a handler
+func WithRequestValidation() Option {
+	return func(h *Handler) {
+		h.ValidateRequests = true
+	}
+}
+
+// WithResponseValidation - responses that break OpenAPI() are logged.
+// Every response is copied to check it, so it is meant for development.
+func WithResponseValidation() Option {
+	return func(h *Handler) {
+		h.ValidateResponses = true
+	}
+}
+
+// Validate - wraps a route handler, checks requests and responses against
+// OpenAPI(), whichever of the two are enabled. It has to sit inside JWTAuth
+// and RateLimit, so callers learn nothing about the API before they are
+// authenticated and pay for the checks like for any other request.
+func (h *Handler) Validate(
+	original func(w http.ResponseWriter, r *http.Request),
+) func(w http.ResponseWriter, r *http.Request) {
+	if !h.ValidateRequests && !h.ValidateResponses {
+		return original
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		input, ok := validationInput(r)
+		if !ok {
+			// every mounted route is documented, TestOpenAPICoversRoutes sees to it
+			original(w, r)
+			return
+		}
+
+		if h.ValidateRequests {
+			if err := validateRequest(w, input); err != nil {
+				rejectRequest(w, r, err)
+				return
+			}
+		}
+		if !h.ValidateResponses {
+			original(w, r)
+			return
+		}
+
+		cw := &capturingWriter{responseRecorder: newResponseRecorder(w)}
+		original(cw, r)
+		h.checkResponse(input, cw)
+	}
+}
+
+// validationInput - the operation r was routed to, looked up by the
+// template of the mux route so the two can't disagree
+func validationInput(r *http.Request) (*openapi3filter.RequestValidationInput, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil, false
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return nil, false
+	}
+
+	doc := OpenAPI()
+	item := doc.Paths.Value(path)
+	if item == nil || item.GetOperation(r.Method) == nil {
+		return nil, false
+	}
+
+	return &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: mux.Vars(r),
+		Route: &routers.Route{
+			Spec:      doc,
+			Path:      path,
+			PathItem:  item,
+			Method:    r.Method,
+			Operation: item.GetOperation(r.Method),
+		},
+		Options: &openapi3filter.Options{
+			MultiError: true,
+			// tokens are checked by JWTAuth and friends, the document only names them
+			AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+			SkipSettingDefaults: true,
+			// GraphQL clients expect errors in the GraphQL shape, the
+			// handler checks its body itself
+			ExcludeRequestBody:    path == "/graphql",
+			IncludeResponseStatus: true,
+		},
+	}, true
+}
+
+func validateRequest(w http.ResponseWriter, input *openapi3filter.RequestValidationInput) error {
+	r := input.Request
+	if body := input.Route.Operation.RequestBody; body != nil && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, maxValidatedBody)
+
+		// the handlers have always decoded bodies as JSON whatever they
+		// were sent as, so clients that never set a type keep working
+		if mediaType(r.Header) != "application/json" && body.Value.Content.Get("application/json") != nil {
+			r.Header.Set("Content-Type", "application/json")
+		}
+	}
+
+	return openapi3filter.ValidateRequest(r.Context(), input)
+}
+
+// rejectRequest - 422 when only the fields of the body are wrong, the
+// same the handlers answer for those, 400 for anything else
+func rejectRequest(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		WriteProblem(w, r, ProblemDetails{
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("the body must be at most %d bytes", tooLarge.Limit),
+		})
+		return
+	}
+
+	problems, bodyOnly := problemsOf(err)
+	p := ProblemDetails{
+		Status:   http.StatusBadRequest,
+		Detail:   "the request does not match the API description at /openapi.json",
+		Problems: problems,
+	}
+	if bodyOnly {
+		p.Status = http.StatusUnprocessableEntity
+	}
+	WriteProblem(w, r, p)
+}
+
+// problemsOf - flattens what ValidateRequest returned, bodyOnly is
+// whether it was all about the fields of a body that did parse
+func problemsOf(err error) (problems []Problem, bodyOnly bool) {
+	bodyOnly = true
+
+	var walk func(err error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, err := range e {
+				walk(err)
+			}
+		case *openapi3filter.RequestError:
+			in, name := "body", ""
+			if e.Parameter != nil {
+				in, name = e.Parameter.In, e.Parameter.Name
+			}
+
+			schemaErrs := schemaErrors(e.Err)
+			if e.Parameter != nil || len(schemaErrs) == 0 {
+				bodyOnly = false
+			}
+			if len(schemaErrs) == 0 {
+				problems = append(problems, Problem{In: in, Name: name, Reason: e.Error()})
+				return
+			}
+			for _, se := range schemaErrs {
+				p := Problem{In: in, Name: name, Reason: se.Reason}
+				if e.Parameter == nil {
+					p.Name = "/" + strings.Join(se.JSONPointer(), "/")
+				}
+				problems = append(problems, p)
+			}
+		default:
+			bodyOnly = false
+			problems = append(problems, Problem{Reason: err.Error()})
+		}
+	}
+	walk(err)
+
+	return problems, bodyOnly && len(problems) > 0
+}
+
+func schemaErrors(err error) []*openapi3.SchemaError {
+	switch e := err.(type) {
+	case *openapi3.SchemaError:
+		return []*openapi3.SchemaError{e}
+	case openapi3.MultiError:
+		var errs []*openapi3.SchemaError
+		for _, err := range e {
+			errs = append(errs, schemaErrors(err)...)
+		}
+		return errs
+	default:
+		return nil
+	}
+}
+
+// checkResponse - logs how the response sent drifted from the document.
+// Sockets, streams and anything else that isn't JSON are left alone.
+func (h *Handler) checkResponse(input *openapi3filter.RequestValidationInput, cw *capturingWriter) {
+	if cw.truncated || cw.Status() == http.StatusSwitchingProtocols || mediaType(cw.Header()) != "application/json" {
+		return
+	}
+
+	r := input.Request
+	err := openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 cw.Status(),
+		Header:                 cw.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(cw.body.Bytes())),
+		Options:                input.Options,
+	})
+	if err != nil {
+		h.Log.WithError(err).WithFields(logging.Fields{
+			"method": r.Method,
+			"route":  input.Route.Path,
+			"status": cw.Status(),
+		}).Warn(r.Context(), "response does not match the OpenAPI document")
+	}
+}
+
+// capturingWriter - keeps a copy of the body for checkResponse, up to
+// maxCheckedResponse
+type capturingWriter struct {
+	*responseRecorder
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (cw *capturingWriter) Write(b []byte) (int, error) {
+	if !cw.truncated && cw.body.Len()+len(b) <= maxCheckedResponse {
+		cw.body.Write(b)
+	} else {
+		cw.truncated = true
+		cw.body.Reset()
+	}
+	return cw.responseRecorder.Write(b)
+}
+
+// mediaType - the Content-Type without its parameters, empty when unset
+func mediaType(header http.Header) string {
+	mt, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mt
+}
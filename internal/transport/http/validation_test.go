@@ -0,0 +1,189 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoService - stores nothing, answers like the real service would
+type echoService struct {
+	CommentService
+}
+
+func (echoService) PostComment(_ context.Context, c comment.Comment) (comment.Comment, error) {
+	c.ID = "42"
+	return c, nil
+}
+
+func (echoService) GetComment(_ context.Context, id string) (comment.Comment, error) {
+	return comment.Comment{ID: id, Slug: "go", Body: "hi", Author: "ann"}, nil
+}
+
+// driftingGraphQL - answers with errors that aren't a list
+type driftingGraphQL struct{}
+
+func (driftingGraphQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, `{"errors": "nope"}`)
+}
+
+func (driftingGraphQL) Shutdown() {}
+
+func TestRequestValidation(t *testing.T) {
+	h := NewHandler(echoService{}, tokenAuth{}, nil, logging.Nop(), WithRequestValidation())
+
+	send := func(r *http.Request, contentType string) (*httptest.ResponseRecorder, ProblemDetails) {
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, r)
+
+		var p ProblemDetails
+		if w.Code >= 400 && w.Header().Get("Content-Type") == "application/problem+json" {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		}
+		return w, p
+	}
+	do := func(method, target, contentType, body string) (*httptest.ResponseRecorder, ProblemDetails) {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer ann")
+		return send(r, contentType)
+	}
+
+	t.Run("valid requests reach the handler", func(t *testing.T) {
+		w, _ := do(http.MethodPost, "/api/v1/comment", "application/json", `{"slug": "go", "body": "hi", "author": "ann"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id": "42", "slug": "go", "body": "hi", "author": "ann"}`, w.Body.String())
+	})
+
+	t.Run("bodies are JSON whatever they are sent as", func(t *testing.T) {
+		w, _ := do(http.MethodPost, "/api/v1/comment", "", `{"slug": "go", "body": "hi", "author": "ann"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w, _ = do(http.MethodPost, "/api/v1/comment", "text/plain; charset=utf-8", `{"slug": "go", "body": "hi", "author": "ann"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("every problem with the body is listed", func(t *testing.T) {
+		w, p := do(http.MethodPost, "/api/v1/comment", "application/json",
+			`{"slug": "go", "body": "`+strings.Repeat("x", 10001)+`"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "about:blank", p.Type)
+		assert.Equal(t, "Unprocessable Entity", p.Title)
+		assert.Equal(t, http.StatusUnprocessableEntity, p.Status)
+		assert.NotEmpty(t, p.Detail)
+		assert.NotEmpty(t, p.RequestID)
+		require.Len(t, p.Problems, 2)
+		for _, problem := range p.Problems {
+			assert.Equal(t, "body", problem.In)
+			assert.NotEmpty(t, problem.Reason)
+		}
+		names := []string{p.Problems[0].Name, p.Problems[1].Name}
+		assert.Contains(t, names, "/body")
+	})
+
+	t.Run("a body that isn't JSON is a bad request", func(t *testing.T) {
+		w, p := do(http.MethodPost, "/api/v1/comment", "application/json", `{"slug":`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, http.StatusBadRequest, p.Status)
+		assert.Len(t, p.Problems, 1)
+	})
+
+	t.Run("query parameters are checked", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/audit?limit=0&from=yesterday", nil)
+		r.Header.Set("Authorization", "Bearer admin")
+		w, p := send(r, "")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		require.Len(t, p.Problems, 2)
+		assert.ElementsMatch(t, []Problem{
+			{In: "query", Name: "limit"},
+			{In: "query", Name: "from"},
+		}, []Problem{
+			{In: p.Problems[0].In, Name: p.Problems[0].Name},
+			{In: p.Problems[1].In, Name: p.Problems[1].Name},
+		})
+	})
+
+	t.Run("oversized bodies are refused", func(t *testing.T) {
+		w, p := do(http.MethodPost, "/api/v1/comment", "application/json", strings.Repeat(" ", maxValidatedBody+1))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, p.Status)
+	})
+
+	t.Run("callers without a token learn nothing about the API", func(t *testing.T) {
+		w, _ := send(httptest.NewRequest(http.MethodPost, "/api/v1/comment", strings.NewReader(`{"slug":`)), "application/json")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotContains(t, w.Body.String(), "problems")
+	})
+}
+
+func TestValidationIsRateLimited(t *testing.T) {
+	h := NewHandler(echoService{}, tokenAuth{}, nil, logging.Nop(),
+		WithRequestValidation(),
+		WithRateLimiter(&RateLimiter{
+			Store: ratelimit.NewMemoryStore(time.Hour),
+			Default: RateLimitPolicy{
+				Read:  ratelimit.Limit{Rate: 0.001, Burst: 1},
+				Write: ratelimit.Limit{Rate: 0.001, Burst: 1},
+			},
+		}),
+	)
+
+	invalid := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/comment", strings.NewReader(`{"slug":`))
+		r.Header.Set("Authorization", "Bearer ann")
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, invalid())
+	assert.Equal(t, http.StatusTooManyRequests, invalid())
+}
+
+func TestResponseValidation(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(logging.Options{Output: &buf})
+	require.NoError(t, err)
+
+	h := NewHandler(echoService{}, nil, nil, logger,
+		WithResponseValidation(),
+		WithGraphQL(driftingGraphQL{}),
+	)
+
+	t.Run("responses that keep to the document pass quietly", func(t *testing.T) {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/comment/42", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, buf.String(), "does not match")
+	})
+
+	t.Run("drift is logged, the response still goes out", func(t *testing.T) {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		h.Router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{}"}`)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"errors": "nope"}`, w.Body.String())
+		assert.Contains(t, buf.String(), "response does not match the OpenAPI document")
+		assert.Contains(t, buf.String(), `"route":"/graphql"`)
+	})
+}
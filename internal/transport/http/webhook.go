@@ -0,0 +1,192 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/webhook"
+)
+
+const (
+	defaultDeliveryLimit = 100
+	maxDeliveryLimit     = 1000
+)
+
+type WebhookService interface {
+	Register(ctx context.Context, r webhook.Registration) (webhook.Webhook, error)
+	List(ctx context.Context) ([]webhook.Webhook, error)
+	Delete(ctx context.Context, id string) error
+	Deliveries(ctx context.Context, f webhook.DeliveryFilter) ([]webhook.Delivery, error)
+	Replay(ctx context.Context, webhookID string, id int64) (webhook.Delivery, error)
+}
+
+// WithWebhooks - mounts the admin endpoints for managing webhooks
+func WithWebhooks(s WebhookService) Option {
+	return func(h *Handler) {
+		h.Webhooks = s
+	}
+}
+
+// RegisterWebhookRequest - events may be exact types, "comment.*" or "*"
+type RegisterWebhookRequest struct {
+	URL         string   `json:"url" validate:"required,url,max=2048"`
+	Events      []string `json:"events" validate:"required,min=1,dive,required,max=255"`
+	Description string   `json:"description" validate:"max=255"`
+}
+
+// writeWebhookError - maps webhook errors to responses
+func writeWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, webhook.ErrNotFound):
+		WriteError(w, r, ErrNotFound)
+	case errors.Is(err, webhook.ErrInvalid):
+		WriteError(w, r, ApiError{
+			Error:      "unprocessable entity",
+			Details:    err.Error(),
+			StatusCode: http.StatusUnprocessableEntity,
+		})
+	default:
+		WriteError(w, r, ErrInernalServer)
+	}
+}
+
+// RegisterWebhook - POST /api/v1/admin/webhooks
+// the response is the only time the signing secret is shown
+func (h *Handler) RegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	var req RegisterWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, r, ApiError{
+			Error:      "bad request",
+			Details:    "could not decode the request body",
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		WriteError(w, r, ApiError{
+			Error:      "unprocessable entity",
+			Details:    "url and at least one event type are required",
+			StatusCode: http.StatusUnprocessableEntity,
+		})
+		return
+	}
+
+	wh, err := h.Webhooks.Register(r.Context(), webhook.Registration{
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+	})
+	if err != nil {
+		if !errors.Is(err, webhook.ErrInvalid) {
+			h.Log.WithError(err).Error(r.Context(), "failed to register webhook")
+		}
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	WriteJson(w, http.StatusCreated, wh)
+}
+
+// ListWebhooks - GET /api/v1/admin/webhooks
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.Webhooks.List(r.Context())
+	if err != nil {
+		h.Log.WithError(err).Error(r.Context(), "failed to list webhooks")
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	WriteJson(w, http.StatusOK, ws)
+}
+
+// DeleteWebhook - DELETE /api/v1/admin/webhooks/{id}
+// pending deliveries are dropped with it
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := h.Webhooks.Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+		if !errors.Is(err, webhook.ErrNotFound) {
+			h.Log.WithError(err).Error(r.Context(), "failed to delete webhook")
+		}
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries - GET /api/v1/admin/webhooks/{id}/deliveries
+// supports ?status= (pending, succeeded or dead), ?after_id= and ?limit=
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	f := webhook.DeliveryFilter{
+		WebhookID: mux.Vars(r)["id"],
+		Status:    q.Get("status"),
+		Limit:     defaultDeliveryLimit,
+	}
+
+	badRequest := func(details string) {
+		WriteError(w, r, ApiError{
+			Error:      "bad request",
+			Details:    details,
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	switch f.Status {
+	case "", webhook.StatusPending, webhook.StatusSucceeded, webhook.StatusDead:
+	default:
+		badRequest("status must be pending, succeeded or dead")
+		return
+	}
+
+	var err error
+	if v := q.Get("after_id"); v != "" {
+		if f.AfterID, err = strconv.ParseInt(v, 10, 64); err != nil || f.AfterID < 0 {
+			badRequest("after_id must be a positive integer")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 || f.Limit > maxDeliveryLimit {
+			badRequest("limit must be between 1 and 1000")
+			return
+		}
+	}
+
+	ds, err := h.Webhooks.Deliveries(r.Context(), f)
+	if err != nil {
+		h.Log.WithError(err).Error(r.Context(), "failed to list webhook deliveries")
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	WriteJson(w, http.StatusOK, ds)
+}
+
+// ReplayWebhookDelivery - POST /api/v1/admin/webhooks/{id}/deliveries/{delivery_id}/replay
+// the delivery is sent again with a fresh set of attempts
+func (h *Handler) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	id, err := strconv.ParseInt(vars["delivery_id"], 10, 64)
+	if err != nil {
+		WriteError(w, r, ErrNotFound)
+		return
+	}
+
+	d, err := h.Webhooks.Replay(r.Context(), vars["id"], id)
+	if err != nil {
+		if !errors.Is(err, webhook.ErrNotFound) {
+			h.Log.WithError(err).Error(r.Context(), "failed to replay webhook delivery")
+		}
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	WriteJson(w, http.StatusAccepted, d)
+}
@@ -0,0 +1,367 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+)
+
+const (
+	// socketMessageLimit - a post with the longest allowed body fits easily,
+	// anything bigger closes the connection
+	socketMessageLimit = 32 << 10
+	// socketSendBuffer - replies queued for a client, once it is full we
+	// stop reading from the client until it catches up
+	socketSendBuffer = 16
+	// typingInterval - a connection relays at most one typing indicator
+	// per slug this often
+	typingInterval = time.Second
+)
+
+// what clients send
+const (
+	SocketSubscribe   = "subscribe"
+	SocketUnsubscribe = "unsubscribe"
+	SocketPost        = "post"
+	SocketTyping      = "typing"
+)
+
+// what clients get besides comment events and typing indicators
+const (
+	SocketSubscribed   = "subscribed"
+	SocketUnsubscribed = "unsubscribed"
+	SocketPosted       = "posted"
+	SocketError        = "error"
+)
+
+// upgrader - CheckOrigin is left nil, so only pages from our own
+// origin can open a socket with a user's token
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// WithMaxSubscriptions - see Handler.MaxSubscriptions
+func WithMaxSubscriptions(n int) Option {
+	return func(h *Handler) {
+		h.MaxSubscriptions = n
+	}
+}
+
+// SocketRequest - a message from the client, Ref is echoed on the reply
+type SocketRequest struct {
+	Type    string              `json:"type"`
+	Ref     string              `json:"ref,omitempty"`
+	Slug    string              `json:"slug,omitempty"`
+	Comment *PostCommentRequest `json:"comment,omitempty"`
+}
+
+// SocketMessage - a message to the client. Comment events carry the
+// same id and type as on the SSE stream, typing indicators Slug and User.
+type SocketMessage struct {
+	Type    string           `json:"type"`
+	Ref     string           `json:"ref,omitempty"`
+	ID      uint64           `json:"id,omitempty"`
+	Slug    string           `json:"slug,omitempty"`
+	User    string           `json:"user,omitempty"`
+	Comment *comment.Comment `json:"comment,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// SocketAuth - JWTAuth for the websocket handshake. Browsers can't set
+// headers on it, so without an Authorization header the token is taken
+// from ?access_token= instead.
+func (h *Handler) SocketAuth(
+	original func(w http.ResponseWriter, r *http.Request),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := socketToken(r)
+		if err != nil || token == "" {
+			unauthorized(w, r)
+			return
+		}
+
+		r, ok := h.authenticate(r, token)
+		if !ok {
+			unauthorized(w, r)
+			return
+		}
+
+		original(w, r)
+	}
+}
+
+// socketToken - the bearer token, or ?access_token= without an
+// Authorization header. Empty when there is neither.
+func socketToken(r *http.Request) (string, error) {
+	if len(r.Header.Values("Authorization")) == 0 {
+		return r.URL.Query().Get("access_token"), nil
+	}
+	return bearerToken(r)
+}
+
+// CommentSocket - GET /api/v1/comments/ws
+// A two-way channel: clients subscribe to up to MaxSubscriptions slugs,
+// post comments and tell the other subscribers of a slug they are typing.
+// A client too slow to keep up with its slugs is disconnected with 1013
+// and should reload and subscribe again, one whose token expires with
+// 1008 and should connect again with a fresh one.
+func (h *Handler) CommentSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has answered the request already
+		return
+	}
+	defer conn.Close()
+
+	sub, _, _ := h.Broker.Subscribe(0)
+	defer sub.Close()
+
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	user := claims.Username
+	if user == "" {
+		user = claims.Subject
+	}
+
+	s := &socket{
+		h:          h,
+		r:          r,
+		conn:       conn,
+		sub:        sub,
+		user:       user,
+		slugs:      map[string]bool{},
+		typed:      map[string]time.Time{},
+		send:       make(chan SocketMessage, socketSendBuffer),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+		log:        h.Log.WithFields(logging.Fields{"subject": claims.Subject}),
+	}
+	if claims.ExpiresAt != nil {
+		s.expires = claims.ExpiresAt.Time
+	}
+
+	go s.writeLoop()
+	s.readLoop()
+
+	close(s.done)
+	<-s.writerDone
+}
+
+// socket - one websocket connection. readLoop runs on the request
+// goroutine, writeLoop is the only one writing to conn.
+type socket struct {
+	h    *Handler
+	r    *http.Request
+	conn *websocket.Conn
+	sub  *stream.Subscription
+	user string
+	log  logging.Logger
+	// expires - when the token of the handshake runs out and the
+	// connection with it, zero for tokens that don't
+	expires time.Time
+
+	// slugs and typed - only touched by readLoop
+	slugs map[string]bool
+	typed map[string]time.Time
+
+	send chan SocketMessage
+	// done - readLoop is over, writerDone - writeLoop is
+	done       chan struct{}
+	writerDone chan struct{}
+}
+
+// deadline - how long a peer gets to answer a ping or take a write
+func (s *socket) deadline() time.Time {
+	return time.Now().Add(2 * s.h.StreamHeartbeat)
+}
+
+func (s *socket) readLoop() {
+	s.conn.SetReadLimit(socketMessageLimit)
+	// replaces whatever read timeout the server had set before the hijack
+	s.conn.SetReadDeadline(s.deadline())
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(s.deadline())
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.conn.SetReadDeadline(s.deadline())
+
+		var req SocketRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			if !s.reply(SocketMessage{Type: SocketError, Error: "could not decode the message"}) {
+				return
+			}
+			continue
+		}
+		if !s.reply(s.handle(req)) {
+			return
+		}
+	}
+}
+
+// reply - queues msg for the writer, waiting while the client is behind.
+// False means the writer is gone and the connection with it.
+func (s *socket) reply(msg SocketMessage) bool {
+	if msg.Type == "" {
+		return true
+	}
+	select {
+	case s.send <- msg:
+		return true
+	case <-s.writerDone:
+		return false
+	}
+}
+
+// handle - the reply to req, with no Type when there is nothing to say
+func (s *socket) handle(req SocketRequest) SocketMessage {
+	fail := func(format string, args ...any) SocketMessage {
+		return SocketMessage{Type: SocketError, Ref: req.Ref, Slug: req.Slug, Error: fmt.Sprintf(format, args...)}
+	}
+
+	switch req.Type {
+	case SocketSubscribe:
+		if req.Slug == "" || len(req.Slug) > 255 {
+			return fail("slug is required and at most 255 characters")
+		}
+		if !s.slugs[req.Slug] {
+			if len(s.slugs) >= s.h.MaxSubscriptions {
+				return fail("at most %d subscriptions per connection", s.h.MaxSubscriptions)
+			}
+			s.slugs[req.Slug] = true
+			s.sub.Follow(req.Slug)
+		}
+		return SocketMessage{Type: SocketSubscribed, Ref: req.Ref, Slug: req.Slug}
+
+	case SocketUnsubscribe:
+		delete(s.slugs, req.Slug)
+		delete(s.typed, req.Slug)
+		s.sub.Unfollow(req.Slug)
+		return SocketMessage{Type: SocketUnsubscribed, Ref: req.Ref, Slug: req.Slug}
+
+	case SocketTyping:
+		if !s.slugs[req.Slug] {
+			return fail("subscribe to the slug first")
+		}
+		if time.Since(s.typed[req.Slug]) >= typingInterval {
+			s.typed[req.Slug] = time.Now()
+			s.sub.Typing(req.Slug, s.user)
+		}
+		return SocketMessage{}
+
+	case SocketPost:
+		return s.post(req)
+
+	default:
+		return fail("type must be subscribe, unsubscribe, post or typing")
+	}
+}
+
+// post - PostComment over the socket, charged to the same rate limit
+func (s *socket) post(req SocketRequest) SocketMessage {
+	fail := func(msg string) SocketMessage {
+		return SocketMessage{Type: SocketError, Ref: req.Ref, Error: msg}
+	}
+	ctx := s.r.Context()
+
+	if req.Comment == nil {
+		return fail("comment is required")
+	}
+	if err := validate.Struct(*req.Comment); err != nil {
+		return fail("some required fields are missing")
+	}
+
+	if s.h.Limiter != nil {
+		res, err := s.h.takeToken(s.r, "/api/v1/comment", http.MethodPost)
+		if err != nil {
+			// fail open, like RateLimit
+			s.log.WithError(err).Warn(ctx, "rate limiter unavailable")
+		} else if !res.Allowed {
+			return fail(fmt.Sprintf("rate limit exceeded, retry in %d seconds", ceilSeconds(res.RetryAfter)))
+		}
+	}
+
+	posted, err := s.h.Service.PostComment(ctx, convertPostCmtReqToCmt(*req.Comment))
+	if err != nil {
+		s.log.WithError(err).Error(ctx, "failed to post comment over websocket")
+		if errors.Is(err, comment.ErrUnavailable) {
+			return fail(ErrServiceUnavailable.Details)
+		}
+		return fail(ErrInernalServer.Details)
+	}
+
+	return SocketMessage{Type: SocketPosted, Ref: req.Ref, Comment: &posted}
+}
+
+func (s *socket) writeLoop() {
+	defer close(s.writerDone)
+	// wakes up a readLoop still waiting on the client
+	defer s.conn.Close()
+
+	ping := time.NewTicker(s.h.StreamHeartbeat)
+	defer ping.Stop()
+
+	var expired <-chan time.Time
+	if !s.expires.IsZero() {
+		t := time.NewTimer(time.Until(s.expires))
+		defer t.Stop()
+		expired = t.C
+	}
+
+	closeWith := func(code int, text string) {
+		s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), s.deadline())
+	}
+
+	for {
+		var msg SocketMessage
+		select {
+		case <-s.done:
+			return
+		case <-s.h.closing:
+			closeWith(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-expired:
+			// clients reconnect with a fresh token
+			closeWith(websocket.ClosePolicyViolation, "token expired")
+			return
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, s.deadline()); err != nil {
+				return
+			}
+			continue
+		case msg = <-s.send:
+		case e, ok := <-s.sub.C:
+			if !ok {
+				closeWith(websocket.CloseTryAgainLater, "too far behind, subscribe again")
+				return
+			}
+			msg = socketEvent(e)
+		}
+
+		s.conn.SetWriteDeadline(s.deadline())
+		if err := s.conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// socketEvent - a broker event as sent to socket clients
+func socketEvent(e stream.Event) SocketMessage {
+	if e.Type == stream.EventTyping {
+		return SocketMessage{Type: e.Type, Slug: e.Slug, User: e.User}
+	}
+	c := e.Comment
+	return SocketMessage{Type: e.Type, ID: e.ID, Slug: c.Slug, Comment: &c}
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/auth"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/comment"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenAuth - every token is valid and names its subject, "admin" is one
+// of an admin and "brief" expires in a second
+type tokenAuth struct {
+	AuthService
+}
+
+func (tokenAuth) ValidateToken(_ context.Context, token string) (auth.Claims, error) {
+	if token == "bad" {
+		return auth.Claims{}, errors.New("invalid token")
+	}
+	claims := auth.Claims{Username: token}
+	claims.Subject = "user-" + token
+	if token == "admin" {
+		claims.Role = auth.RoleAdmin
+	}
+	if token == "brief" {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Second))
+	}
+	return claims, nil
+}
+
+// notifyingService - posts like comment.Service, committed changes go to the broker
+type notifyingService struct {
+	CommentService
+	broker *stream.Broker
+}
+
+func (s notifyingService) PostComment(_ context.Context, c comment.Comment) (comment.Comment, error) {
+	c.ID = "42"
+	s.broker.Publish(comment.Change{Type: comment.EventCommentCreated, Comment: c})
+	return c, nil
+}
+
+func TestCommentSocket(t *testing.T) {
+	broker := stream.NewBroker(stream.Options{})
+	h := NewHandler(notifyingService{broker: broker}, tokenAuth{}, nil, logging.Nop(),
+		WithBroker(broker),
+		WithMaxSubscriptions(2),
+	)
+
+	srv := httptest.NewServer(h.Router)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/comments/ws"
+
+	dial := func(url string, header http.Header) *websocket.Conn {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		require.NoError(t, err)
+		resp.Body.Close()
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	send := func(conn *websocket.Conn, req SocketRequest) {
+		require.NoError(t, conn.WriteJSON(req))
+	}
+	receive := func(conn *websocket.Conn) SocketMessage {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg SocketMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	t.Run("a token is required", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		_, resp, err = websocket.DefaultDialer.Dial(url+"?access_token=bad", nil)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	alice := dial(url+"?access_token=alice", nil)
+	bob := dial(url, http.Header{"Authorization": {"Bearer bob"}})
+
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		send(conn, SocketRequest{Type: SocketSubscribe, Ref: "1", Slug: "go"})
+		assert.Equal(t, SocketMessage{Type: SocketSubscribed, Ref: "1", Slug: "go"}, receive(conn))
+	}
+
+	t.Run("subscriptions are capped", func(t *testing.T) {
+		send(alice, SocketRequest{Type: SocketSubscribe, Slug: "rust"})
+		assert.Equal(t, SocketSubscribed, receive(alice).Type)
+
+		send(alice, SocketRequest{Type: SocketSubscribe, Ref: "2", Slug: "zig"})
+		msg := receive(alice)
+		assert.Equal(t, SocketError, msg.Type)
+		assert.Equal(t, "2", msg.Ref)
+		assert.Contains(t, msg.Error, "at most 2")
+	})
+
+	t.Run("typing is relayed to the others", func(t *testing.T) {
+		send(alice, SocketRequest{Type: SocketTyping, Slug: "go"})
+		assert.Equal(t, SocketMessage{Type: stream.EventTyping, Slug: "go", User: "alice"}, receive(bob))
+	})
+
+	t.Run("posted comments reach every subscriber", func(t *testing.T) {
+		send(alice, SocketRequest{Type: SocketPost, Ref: "3", Comment: &PostCommentRequest{
+			Slug: "go", Body: "hi", Author: "alice",
+		}})
+
+		// the reply and the event may come in either order
+		got := map[string]SocketMessage{}
+		for i := 0; i < 2; i++ {
+			msg := receive(alice)
+			got[msg.Type] = msg
+		}
+		assert.Equal(t, "3", got[SocketPosted].Ref)
+		assert.Equal(t, "42", got[SocketPosted].Comment.ID)
+		assert.Equal(t, uint64(1), got[comment.EventCommentCreated].ID)
+
+		msg := receive(bob)
+		assert.Equal(t, comment.EventCommentCreated, msg.Type)
+		assert.Equal(t, "hi", msg.Comment.Body)
+	})
+
+	t.Run("invalid posts are refused", func(t *testing.T) {
+		send(bob, SocketRequest{Type: SocketPost, Ref: "4", Comment: &PostCommentRequest{Slug: "go"}})
+		msg := receive(bob)
+		assert.Equal(t, SocketError, msg.Type)
+		assert.Equal(t, "4", msg.Ref)
+	})
+
+	t.Run("the socket closes when its token expires", func(t *testing.T) {
+		conn := dial(url+"?access_token=brief", nil)
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "got %v", err)
+	})
+}
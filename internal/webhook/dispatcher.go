@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/outbox"
+)
+
+// Fanout - an outbox.Publisher that turns each event into one pending
+// delivery per matching webhook. Sending is left to the Dispatcher, so a
+// slow or broken receiver only holds up its own deliveries.
+type Fanout struct {
+	Store Store
+}
+
+func (f Fanout) Publish(ctx context.Context, e outbox.Event) error {
+	webhooks, err := f.Store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+
+	var deliveries []Delivery
+	for _, w := range webhooks {
+		if w.Matches(e.Type) {
+			deliveries = append(deliveries, Delivery{
+				WebhookID: w.ID,
+				EventID:   e.ID,
+				EventType: e.Type,
+				Payload:   payload,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return f.Store.EnqueueWebhookDeliveries(ctx, deliveries)
+}
+
+// DispatcherOptions - how deliveries are sent and retried
+type DispatcherOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease - how long a claimed delivery is hidden from other dispatchers
+	Lease time.Duration
+	// Timeout - per attempt, a receiver slower than this counts as failed
+	Timeout time.Duration
+	// MaxAttempts - failed attempts before a delivery is dead
+	MaxAttempts int
+	// MinBackoff, MaxBackoff - delay before the next attempt, doubled each time
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// AllowPrivate - lets deliveries reach loopback, link-local and private
+	// addresses, which are refused so a webhook can't be pointed at the
+	// network the dispatcher runs in. For development and tests.
+	AllowPrivate bool
+}
+
+// Dispatcher - sends pending deliveries, signed with their webhook's secret.
+// A 2xx response is a success, anything else is retried with exponential
+// backoff until MaxAttempts, after which the delivery is dead and stays
+// in the log until it is replayed.
+type Dispatcher struct {
+	Store  Store
+	Client *http.Client
+	Log    logging.Logger
+	opts   DispatcherOptions
+	now    func() time.Time
+}
+
+// NewDispatcher - fills in defaults for every option left at zero
+func NewDispatcher(store Store, opts DispatcherOptions, logger logging.Logger) *Dispatcher {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 50
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Lease <= 0 {
+		// deliveries in a batch are sent one after another
+		opts.Lease = time.Duration(opts.BatchSize) * opts.Timeout
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 10 * time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = time.Hour
+	}
+
+	return &Dispatcher{
+		Store: store,
+		// no redirects: a signed payload only goes where it was registered
+		Client: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: newTransport(opts.AllowPrivate),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Log:  logger.WithFields(logging.Fields{"component": "webhook"}),
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// ErrForbiddenTarget - a delivery resolved to an address it may not reach
+var ErrForbiddenTarget = errors.New("webhook target is a loopback, link-local or private address")
+
+// newTransport - dials webhook URLs directly, no proxy, so the address
+// checked is the one delivered to. The check runs on the resolved address
+// of every connection, a name that resolves differently later is caught too.
+func newTransport(allowPrivate bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	if allowPrivate {
+		return t
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || forbidden(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenTarget, host)
+			}
+			return nil
+		},
+	}
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+// forbidden - addresses inside the network rather than on the internet
+func forbidden(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace - RFC 6598, carrier-grade NAT and some cloud networks
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Run - polls until ctx is cancelled, a full batch is followed
+// by another poll straight away
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.Log.Info(ctx, "webhook dispatcher started")
+	defer d.Log.Info(ctx, "webhook dispatcher stopped")
+
+	for {
+		n, err := d.DispatchOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.Log.WithError(err).Error(ctx, "failed to dispatch webhook deliveries")
+		}
+
+		wait := d.opts.PollInterval
+		if err == nil && n == d.opts.BatchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// DispatchOnce - claims one batch and sends it,
+// returns how many deliveries were claimed
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	deliveries, err := d.Store.ClaimWebhookDeliveries(ctx, d.opts.BatchSize, d.opts.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, dl := range deliveries {
+		// finish what was claimed, like the outbox relay does
+		d.deliver(context.WithoutCancel(ctx), dl)
+	}
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, dl Delivery) {
+	log := d.Log.WithFields(logging.Fields{
+		"webhook_id":  dl.WebhookID,
+		"delivery_id": dl.ID,
+		"event_type":  dl.EventType,
+		"attempts":    dl.Attempts,
+	})
+
+	a := d.send(ctx, dl)
+	switch {
+	case a.Status == StatusSucceeded:
+		log.Debug(ctx, "delivered a webhook")
+	case dl.Attempts >= d.opts.MaxAttempts:
+		a.Status = StatusDead
+		log.WithFields(logging.Fields{"error": a.Error}).Error(ctx, "webhook delivery is dead, giving up")
+	default:
+		a.Status = StatusPending
+		a.NextAttemptAt = d.now().Add(outbox.Backoff(dl.Attempts, d.opts.MinBackoff, d.opts.MaxBackoff))
+		log.WithFields(logging.Fields{"error": a.Error}).Warn(ctx, "webhook delivery failed, will retry")
+	}
+
+	if err := d.Store.RecordWebhookAttempt(ctx, dl.ID, a); err != nil {
+		// the lease runs out and the delivery is attempted again
+		log.WithError(err).Error(ctx, "failed to record webhook attempt")
+	}
+}
+
+// send - one signed POST, the status is only ever set on success
+func (d *Dispatcher) send(ctx context.Context, dl Delivery) Attempt {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return Attempt{Error: err.Error()}
+	}
+
+	now := d.now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "comments-api-webhooks")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(dl.Secret, now, dl.Payload))
+	req.Header.Set(EventHeader, dl.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(dl.ID, 10))
+	// the same for every delivery of the event, receivers dedupe on it
+	req.Header.Set("Idempotency-Key", dl.EventID)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return Attempt{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	a := Attempt{StatusCode: resp.StatusCode}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.Error = "receiver responded with " + resp.Status
+		return a
+	}
+	a.Status = StatusSucceeded
+	return a
+}
@@ -0,0 +1,238 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// ErrNotFound - no webhook or delivery with the given id
+	ErrNotFound = errors.New("webhook not found")
+	// ErrInvalid - the registration was rejected, the message says why
+	ErrInvalid = errors.New("invalid webhook")
+)
+
+// Delivery states. Pending deliveries are retried until they succeed or
+// run out of attempts, dead ones stay put until they are replayed.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+// Webhook - a URL subscribed to some or all event types
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events - event types delivered, "*" or a "comment.*" prefix match many
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Secret - the HMAC key, only ever shown in the response to Register
+	Secret string `json:"secret,omitempty"`
+}
+
+// Matches - whether events of this type are delivered to the webhook
+func (w Webhook) Matches(eventType string) bool {
+	for _, f := range w.Events {
+		if f == "*" || f == eventType ||
+			(strings.HasSuffix(f, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(f, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery - one event on its way to one webhook, with its latest attempt
+type Delivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      string          `json:"webhook_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// URL and Secret - filled in when a delivery is claimed for sending
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// Attempt - the outcome of sending a delivery once
+type Attempt struct {
+	Status        string
+	StatusCode    int
+	Error         string
+	NextAttemptAt time.Time
+}
+
+// DeliveryFilter - criteria for the delivery log, zero values are ignored
+type DeliveryFilter struct {
+	WebhookID string
+	Status    string
+	AfterID   int64
+	Limit     int
+}
+
+// Store - persistence for webhooks and their deliveries
+type Store interface {
+	CreateWebhook(ctx context.Context, w Webhook) (Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	// DeleteWebhook - also deletes its deliveries, false if there was none
+	DeleteWebhook(ctx context.Context, id string) (bool, error)
+
+	// EnqueueWebhookDeliveries - a delivery that already exists for the
+	// same webhook and event is skipped, so redelivered events are harmless
+	EnqueueWebhookDeliveries(ctx context.Context, ds []Delivery) error
+	// ClaimWebhookDeliveries - leases up to limit due pending deliveries
+	// and counts the attempt, other dispatchers skip them meanwhile
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	RecordWebhookAttempt(ctx context.Context, id int64, a Attempt) error
+	ListWebhookDeliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error)
+	// ReplayWebhookDelivery - makes the delivery pending and due now with
+	// a fresh set of attempts, ErrNotFound if it isn't the webhook's
+	ReplayWebhookDelivery(ctx context.Context, webhookID string, id int64) (Delivery, error)
+}
+
+// Registration - what an admin sends to subscribe a URL
+type Registration struct {
+	URL         string
+	Events      []string
+	Description string
+}
+
+// Service - manages webhook subscriptions and their delivery log
+type Service struct {
+	Store Store
+	Log   logging.Logger
+	// eventTypes - the types a filter may name
+	eventTypes []string
+}
+
+// NewService - eventTypes are the event types there are to subscribe to
+func NewService(store Store, eventTypes []string, logger logging.Logger) *Service {
+	return &Service{
+		Store:      store,
+		Log:        logger.WithFields(logging.Fields{"component": "webhook"}),
+		eventTypes: eventTypes,
+	}
+}
+
+// Register - stores the webhook with a new signing secret. The returned
+// webhook is the only place the secret is ever shown.
+func (s *Service) Register(ctx context.Context, r Registration) (Webhook, error) {
+	if err := s.validate(r); err != nil {
+		return Webhook{}, err
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	w, err := s.Store.CreateWebhook(ctx, Webhook{
+		ID:          uuid.NewV4().String(),
+		URL:         r.URL,
+		Events:      r.Events,
+		Description: r.Description,
+		Secret:      secret,
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	s.Log.WithFields(logging.Fields{"webhook_id": w.ID, "events": w.Events}).
+		Info(ctx, "registered a webhook")
+	return w, nil
+}
+
+func (s *Service) validate(r Registration) error {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https url", ErrInvalid)
+	}
+	if len(r.Events) == 0 {
+		return fmt.Errorf("%w: at least one event type is required", ErrInvalid)
+	}
+
+	for _, f := range r.Events {
+		probe := Webhook{Events: []string{f}}
+		known := false
+		for _, t := range s.eventTypes {
+			if probe.Matches(t) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: %q matches no event type, expected one of %s",
+				ErrInvalid, f, strings.Join(s.eventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// List - every webhook, without secrets
+func (s *Service) List(ctx context.Context) ([]Webhook, error) {
+	ws, err := s.Store.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ws {
+		ws[i].Secret = ""
+	}
+	return ws, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id string) error {
+	deleted, err := s.Store.DeleteWebhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrNotFound
+	}
+	s.Log.WithFields(logging.Fields{"webhook_id": id}).Info(ctx, "deleted a webhook")
+	return nil
+}
+
+// Deliveries - the delivery log, newest last
+func (s *Service) Deliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error) {
+	return s.Store.ListWebhookDeliveries(ctx, f)
+}
+
+// Replay - sends a delivery again, typically a dead one after the
+// receiver has been fixed
+func (s *Service) Replay(ctx context.Context, webhookID string, id int64) (Delivery, error) {
+	d, err := s.Store.ReplayWebhookDelivery(ctx, webhookID, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	s.Log.WithFields(logging.Fields{"webhook_id": webhookID, "delivery_id": id}).
+		Info(ctx, "replaying a webhook delivery")
+	return d, nil
+}
+
+// newSecret - 32 random bytes, prefixed so a leaked one is easy to spot
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate a webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,292 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/logging"
+	"github.com/ridwanulhoquejr/go-rest-api-v2/internal/outbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore - claims and records deliveries the same way the postgres store does
+type memStore struct {
+	now        func() time.Time
+	webhooks   []Webhook
+	deliveries []Delivery
+}
+
+func (m *memStore) CreateWebhook(_ context.Context, w Webhook) (Webhook, error) {
+	w.CreatedAt = m.now()
+	m.webhooks = append(m.webhooks, w)
+	return w, nil
+}
+
+func (m *memStore) ListWebhooks(context.Context) ([]Webhook, error) {
+	return append([]Webhook(nil), m.webhooks...), nil
+}
+
+func (m *memStore) DeleteWebhook(_ context.Context, id string) (bool, error) {
+	for i, w := range m.webhooks {
+		if w.ID == id {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memStore) EnqueueWebhookDeliveries(_ context.Context, ds []Delivery) error {
+next:
+	for _, d := range ds {
+		for _, existing := range m.deliveries {
+			if existing.WebhookID == d.WebhookID && existing.EventID == d.EventID {
+				continue next
+			}
+		}
+		d.ID = int64(len(m.deliveries) + 1)
+		d.Status = StatusPending
+		d.NextAttemptAt = m.now()
+		m.deliveries = append(m.deliveries, d)
+	}
+	return nil
+}
+
+func (m *memStore) ClaimWebhookDeliveries(_ context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	var out []Delivery
+	for i := range m.deliveries {
+		d := &m.deliveries[i]
+		if len(out) == limit || d.Status != StatusPending || d.NextAttemptAt.After(m.now()) {
+			continue
+		}
+		d.Attempts++
+		d.NextAttemptAt = m.now().Add(lease)
+
+		claimed := *d
+		for _, w := range m.webhooks {
+			if w.ID == d.WebhookID {
+				claimed.URL, claimed.Secret = w.URL, w.Secret
+			}
+		}
+		out = append(out, claimed)
+	}
+	return out, nil
+}
+
+func (m *memStore) RecordWebhookAttempt(_ context.Context, id int64, a Attempt) error {
+	d := &m.deliveries[id-1]
+	d.Status = a.Status
+	d.LastStatusCode = a.StatusCode
+	d.LastError = a.Error
+	if !a.NextAttemptAt.IsZero() {
+		d.NextAttemptAt = a.NextAttemptAt
+	}
+	return nil
+}
+
+func (m *memStore) ListWebhookDeliveries(_ context.Context, f DeliveryFilter) ([]Delivery, error) {
+	var out []Delivery
+	for _, d := range m.deliveries {
+		if (f.WebhookID == "" || d.WebhookID == f.WebhookID) && (f.Status == "" || d.Status == f.Status) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) ReplayWebhookDelivery(_ context.Context, webhookID string, id int64) (Delivery, error) {
+	if id < 1 || int(id) > len(m.deliveries) || m.deliveries[id-1].WebhookID != webhookID {
+		return Delivery{}, ErrNotFound
+	}
+	d := &m.deliveries[id-1]
+	d.Status, d.Attempts, d.NextAttemptAt = StatusPending, 0, m.now()
+	return *d, nil
+}
+
+// receiver - an integrator's endpoint checking signatures like they should
+type receiver struct {
+	t      *testing.T
+	secret string
+	status int
+
+	mu       sync.Mutex
+	received []outbox.Event
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	assert.NoError(rc.t, Verify(rc.secret, r.Header, body, time.Now(), 5*time.Minute))
+
+	var e outbox.Event
+	require.NoError(rc.t, json.Unmarshal(body, &e))
+	assert.Equal(rc.t, e.ID, r.Header.Get("Idempotency-Key"))
+	assert.Equal(rc.t, e.Type, r.Header.Get(EventHeader))
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.status != 0 {
+		w.WriteHeader(rc.status)
+		return
+	}
+	rc.received = append(rc.received, e)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":"1"}`)
+
+	h := http.Header{}
+	h.Set(TimestampHeader, "1700000000")
+	h.Set(SignatureHeader, Sign("secret", time.Unix(1700000000, 0), body))
+
+	assert.NoError(t, Verify("secret", h, body, time.Unix(1700000060, 0), 5*time.Minute))
+	assert.ErrorIs(t, Verify("other", h, body, time.Unix(1700000060, 0), 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", h, []byte(`{"id":"2"}`), time.Unix(1700000060, 0), 5*time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", h, body, now, 5*time.Minute), ErrInvalidSignature, "too old")
+}
+
+func TestMatches(t *testing.T) {
+	assert.True(t, Webhook{Events: []string{"*"}}.Matches("comment.created"))
+	assert.True(t, Webhook{Events: []string{"comment.*"}}.Matches("comment.deleted"))
+	assert.True(t, Webhook{Events: []string{"comment.updated", "comment.created"}}.Matches("comment.created"))
+	assert.False(t, Webhook{Events: []string{"comment.updated"}}.Matches("comment.created"))
+	assert.False(t, Webhook{Events: []string{"comment.*"}}.Matches("commentary.created"))
+}
+
+func TestRegister(t *testing.T) {
+	store := &memStore{now: time.Now}
+	s := NewService(store, []string{"comment.created", "comment.deleted"}, logging.Nop())
+	ctx := context.Background()
+
+	_, err := s.Register(ctx, Registration{URL: "ftp://example.com", Events: []string{"*"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = s.Register(ctx, Registration{URL: "https://example.com", Events: []string{"user.created"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	w, err := s.Register(ctx, Registration{URL: "https://example.com/hook", Events: []string{"comment.*"}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, w.ID)
+	assert.Contains(t, w.Secret, "whsec_")
+
+	ws, err := s.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, ws, 1)
+	assert.Empty(t, ws[0].Secret, "the secret is only shown once")
+
+	assert.NoError(t, s.Delete(ctx, w.ID))
+	assert.ErrorIs(t, s.Delete(ctx, w.ID), ErrNotFound)
+}
+
+func TestDelivery(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	store := &memStore{now: clock}
+	s := NewService(store, []string{"comment.created", "comment.deleted"}, logging.Nop())
+	ctx := context.Background()
+
+	rc := &receiver{t: t}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	subscribed, err := s.Register(ctx, Registration{URL: srv.URL, Events: []string{"comment.created"}})
+	require.NoError(t, err)
+	rc.secret = subscribed.Secret
+	_, err = s.Register(ctx, Registration{URL: srv.URL + "/other", Events: []string{"comment.deleted"}})
+	require.NoError(t, err)
+
+	dispatcher := NewDispatcher(store, DispatcherOptions{
+		MaxAttempts: 2,
+		MinBackoff:  time.Second,
+		// the receiver is on loopback
+		AllowPrivate: true,
+	}, logging.Nop())
+	dispatcher.now = clock
+
+	event := outbox.Event{
+		ID:          "2b1c7a0e-0000-4000-8000-000000000001",
+		Type:        "comment.created",
+		AggregateID: "42",
+		Data:        json.RawMessage(`{"comment":{"id":"42"}}`),
+	}
+	fanout := Fanout{Store: store}
+	require.NoError(t, fanout.Publish(ctx, event))
+	// the outbox delivers at least once, the second copy is dropped
+	require.NoError(t, fanout.Publish(ctx, event))
+
+	t.Run("only matching webhooks get a delivery", func(t *testing.T) {
+		n, err := dispatcher.DispatchOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		require.Len(t, rc.received, 1)
+		assert.Equal(t, event.ID, rc.received[0].ID)
+		assert.Equal(t, StatusSucceeded, store.deliveries[0].Status)
+	})
+
+	t.Run("failed deliveries are retried, then dead", func(t *testing.T) {
+		rc.status = http.StatusInternalServerError
+		event.ID = "2b1c7a0e-0000-4000-8000-000000000002"
+		require.NoError(t, fanout.Publish(ctx, event))
+
+		_, err := dispatcher.DispatchOnce(ctx)
+		require.NoError(t, err)
+		d := store.deliveries[1]
+		assert.Equal(t, StatusPending, d.Status)
+		assert.Equal(t, http.StatusInternalServerError, d.LastStatusCode)
+		assert.True(t, d.NextAttemptAt.After(now), "backs off")
+
+		now = now.Add(2 * time.Second)
+		_, err = dispatcher.DispatchOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, StatusDead, store.deliveries[1].Status)
+
+		dead, err := s.Deliveries(ctx, DeliveryFilter{WebhookID: subscribed.ID, Status: StatusDead})
+		require.NoError(t, err)
+		assert.Len(t, dead, 1)
+	})
+
+	t.Run("a replayed delivery is sent again", func(t *testing.T) {
+		rc.status = 0
+
+		_, err := s.Replay(ctx, subscribed.ID, 2)
+		require.NoError(t, err)
+		_, err = dispatcher.DispatchOnce(ctx)
+		require.NoError(t, err)
+
+		assert.Equal(t, StatusSucceeded, store.deliveries[1].Status)
+		assert.Len(t, rc.received, 2)
+
+		_, err = s.Replay(ctx, "someone-else", 2)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestPrivateTargetsAreRefused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a delivery reached a loopback address")
+	}))
+	defer srv.Close()
+
+	dispatcher := NewDispatcher(&memStore{}, DispatcherOptions{}, logging.Nop())
+	_, err := dispatcher.Client.Get(srv.URL)
+	assert.ErrorIs(t, err, ErrForbiddenTarget)
+
+	for _, addr := range []string{
+		"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1",
+		"169.254.169.254", "fe80::1", "fc00::1", "0.0.0.0", "100.64.0.1", "::ffff:127.0.0.1",
+	} {
+		assert.True(t, forbidden(net.ParseIP(addr)), addr)
+	}
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1::1", "100.128.0.1"} {
+		assert.False(t, forbidden(net.ParseIP(addr)), addr)
+	}
+}
@@ -0,0 +1,8 @@
+-- unlogged: buckets are cheap to lose on a crash, every request writes here
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets (
+  key text PRIMARY KEY,
+  tokens double precision NOT NULL,
+  updated_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_updated_at_idx ON rate_limit_buckets (updated_at);
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS comments_author_idx;
+DROP INDEX IF EXISTS comments_slug_idx;
+
+ALTER TABLE comments
+  DROP CONSTRAINT IF EXISTS comments_body_length,
+  DROP CONSTRAINT IF EXISTS comments_author_length,
+  DROP CONSTRAINT IF EXISTS comments_slug_length,
+  ALTER COLUMN body DROP NOT NULL,
+  ALTER COLUMN author DROP NOT NULL,
+  ALTER COLUMN slug DROP NOT NULL,
+  ALTER COLUMN id DROP DEFAULT,
+  DROP CONSTRAINT IF EXISTS comments_pkey;
@@ -0,0 +1,33 @@
+-- gen_random_uuid() is only built in from postgres 13
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+-- give rows without an id, or sharing one, an id of their own
+UPDATE comments SET id = gen_random_uuid() WHERE id IS NULL;
+UPDATE comments SET id = gen_random_uuid()
+WHERE ctid IN (
+  SELECT ctid FROM (
+    SELECT ctid, row_number() OVER (PARTITION BY id) AS n FROM comments
+  ) dup
+  WHERE dup.n > 1
+);
+
+UPDATE comments SET slug = '' WHERE slug IS NULL;
+UPDATE comments SET author = '' WHERE author IS NULL;
+UPDATE comments SET body = '' WHERE body IS NULL;
+
+ALTER TABLE comments
+  ADD CONSTRAINT comments_pkey PRIMARY KEY (id),
+  ALTER COLUMN id SET DEFAULT gen_random_uuid(),
+  ALTER COLUMN slug SET NOT NULL,
+  ALTER COLUMN author SET NOT NULL,
+  ALTER COLUMN body SET NOT NULL;
+
+-- NOT VALID: rows written before the checks existed are left alone,
+-- every insert and update from now on has to pass them
+ALTER TABLE comments
+  ADD CONSTRAINT comments_slug_length CHECK (char_length(slug) BETWEEN 1 AND 255) NOT VALID,
+  ADD CONSTRAINT comments_author_length CHECK (char_length(author) BETWEEN 1 AND 255) NOT VALID,
+  ADD CONSTRAINT comments_body_length CHECK (char_length(body) BETWEEN 1 AND 10000) NOT VALID;
+
+CREATE INDEX IF NOT EXISTS comments_slug_idx ON comments (slug);
+CREATE INDEX IF NOT EXISTS comments_author_idx ON comments (author);
@@ -0,0 +1,21 @@
+-- events are written in the same transaction as the change they describe
+-- and relayed from here, see internal/outbox
+CREATE TABLE IF NOT EXISTS outbox (
+  seq bigserial PRIMARY KEY,
+  -- sent with every delivery so consumers can drop duplicates
+  event_id uuid NOT NULL UNIQUE,
+  event_type text NOT NULL,
+  aggregate_type text NOT NULL,
+  aggregate_id text NOT NULL,
+  occurred_at timestamptz NOT NULL,
+  request_id text NOT NULL DEFAULT '',
+  data json NOT NULL,
+  attempts integer NOT NULL DEFAULT 0,
+  -- when the event may be claimed next: after a lease or a retry backoff
+  available_at timestamptz NOT NULL DEFAULT now(),
+  last_error text,
+  published_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (available_at, seq) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_published_at_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
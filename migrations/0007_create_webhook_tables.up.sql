@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+  id uuid PRIMARY KEY,
+  url text NOT NULL,
+  -- the HMAC key deliveries are signed with, it has to be kept in the clear
+  secret text NOT NULL,
+  events text[] NOT NULL,
+  description text NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id bigserial PRIMARY KEY,
+  webhook_id uuid NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+  event_id uuid NOT NULL,
+  event_type text NOT NULL,
+  payload json NOT NULL,
+  status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'dead')),
+  attempts integer NOT NULL DEFAULT 0,
+  -- when a pending delivery may be claimed next: after a lease or a backoff
+  next_attempt_at timestamptz NOT NULL DEFAULT now(),
+  last_status_code integer,
+  last_error text,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  delivered_at timestamptz,
+  -- the outbox may publish an event twice, it is delivered once per webhook
+  UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx
+  ON webhook_deliveries (next_attempt_at, id) WHERE status = 'pending';
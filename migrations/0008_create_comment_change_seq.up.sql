@@ -0,0 +1,9 @@
+-- numbers the live comment change notifications every replica listens
+-- for, see db.NotifyCommentChange. A single row: bumping it serializes
+-- the notifications, so they arrive in the order of their ids.
+CREATE TABLE IF NOT EXISTS comment_change_seq (
+  id boolean PRIMARY KEY DEFAULT true CHECK (id),
+  seq bigint NOT NULL
+);
+
+INSERT INTO comment_change_seq (seq) VALUES (0) ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+// Package migrations - the SQL migrations, embedded into the binary
+// so it can migrate from any working directory
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// every up migration needs a down migration that actually undoes something,
+// otherwise `app migrate down` silently does nothing
+func TestMigrationsArePaired(t *testing.T) {
+	ups, err := fs.Glob(FS, "*.up.sql")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ups)
+
+	for _, up := range ups {
+		down := strings.TrimSuffix(up, ".up.sql") + ".down.sql"
+
+		body, err := fs.ReadFile(FS, down)
+		if assert.NoError(t, err, "%s has no down migration", up) {
+			assert.NotEmpty(t, strings.TrimSpace(string(body)), "%s is empty", down)
+		}
+	}
+}
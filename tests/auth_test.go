@@ -0,0 +1,42 @@
+//go:build e2e
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssueToken(t *testing.T) {
+	t.Run("rejects unknown credentials", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetBody(`{"grant_type": "password", "username": "nobody", "password": "nope"}`).
+			Post("http://localhost:8080/api/v1/auth/token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 401, resp.StatusCode())
+	})
+
+	t.Run("rejects unknown refresh token", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetBody(`{"grant_type": "refresh_token", "refresh_token": "not-a-token"}`).
+			Post("http://localhost:8080/api/v1/auth/token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 401, resp.StatusCode())
+	})
+
+	t.Run("rejects unsupported grant type", func(t *testing.T) {
+		client := resty.New()
+		resp, err := client.R().
+			SetBody(`{"grant_type": "client_credentials"}`).
+			Post("http://localhost:8080/api/v1/auth/token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 422, resp.StatusCode())
+	})
+}
@@ -5,16 +5,30 @@ package tests
 
 import (
 	"fmt"
+	"os"
 	"testing"
+	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 )
 
+// jwtSecret - JWT_SECRET, or the one docker-compose starts the api with
+func jwtSecret() string {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return secret
+	}
+	return "local-dev-secret-change-me-0123456789"
+}
+
 func createToken() string {
-	token := jwt.New(jwt.SigningMethodHS256)
-	tokenString, err := token.SignedString([]byte("missionimpossible"))
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "e2etest",
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	tokenString, err := token.SignedString([]byte(jwtSecret()))
 
 	if err != nil {
 		fmt.Println(err)
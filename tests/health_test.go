@@ -16,3 +16,22 @@ func TestHealthCheckEndpoint(t *testing.T) {
 
 	assert.Equal(t, 200, resp.StatusCode())
 }
+
+func TestLivenessEndpoint(t *testing.T) {
+	client := resty.New()
+	resp, err := client.R().Get("http://localhost:8080/healthz")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Contains(t, resp.String(), `"status":"ok"`)
+}
+
+func TestReadinessEndpoint(t *testing.T) {
+	client := resty.New()
+	resp, err := client.R().Get("http://localhost:8080/readyz")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode())
+	assert.Contains(t, resp.String(), `"database"`)
+	assert.Contains(t, resp.String(), `"migrations"`)
+}